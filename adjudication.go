@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// adjudication records that the owner of a project has reviewed the scores
+// of a completed document and settled them. Scores can still be changed
+// afterwards; the owner can reopen the document and adjudicate it again.
+type adjudication struct {
+	By      *lcmUser
+	Created time.Time
+}
+
+// Adjudication returns the adjudication of the document, or nil if it
+// hasn't been adjudicated.
+func (d *document) Adjudication() *adjudication {
+	var adj *adjudication
+	rows := csql.Query(db, `
+		SELECT
+			adjudicated_by, adjudicated
+		FROM
+			document_adjudication
+		WHERE
+			project_owner = $1 AND project_name = $2
+			AND document_name = $3 AND document_recorded = $4
+	`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		adj = &adjudication{}
+		var by string
+		csql.Scan(row, &by, &adj.Created)
+		adj.By = findUserByNo(by)
+		if adj.By == nil {
+			adj.By = &lcmUser{configUser{Id: by, Name: by}}
+		}
+	})
+	return adj
+}
+
+// adjudicateDocument finishes the adjudication of a document, or reopens
+// it. Only completed documents can be adjudicated, and webhooks are told
+// when adjudication finishes.
+func adjudicateDocument(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can adjudicate documents."))
+	}
+	var form struct {
+		Reopen bool
+	}
+	w.decode(&form)
+
+	d.lock()
+	defer d.unlock()
+	if form.Reopen {
+		csql.Exec(db, `
+			DELETE FROM document_adjudication
+			WHERE project_owner = $1 AND project_name = $2
+				AND document_name = $3 AND document_recorded = $4
+			`, proj.Owner.Id, proj.Name, d.Name, d.Recorded)
+	} else {
+		if d.Adjudication() != nil {
+			panic(ue("Document **%s** has already been adjudicated.",
+				d.Display))
+		}
+		if !d.completed() {
+			panic(ue("Document **%s** can't be adjudicated until every "+
+				"word is scored.", d.Display))
+		}
+		csql.Exec(db, `
+			INSERT INTO document_adjudication (
+				project_owner, project_name, document_name, document_recorded,
+				adjudicated_by, adjudicated
+			) VALUES ($1, $2, $3, $4, $5, $6)
+			`, proj.Owner.Id, proj.Name, d.Name, d.Recorded,
+			w.user.Id, time.Now().UTC())
+		proj.triggerHooks(hookDocumentAdjudicated, w.user, d)
+	}
+	http.Redirect(w.w, w.r, w.routes.URLFor("document",
+		proj.Owner.Id, proj.Name, d.Name, d.RecordedKey()), 302)
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The formats of other annotation tools that documents can be imported
+// from.
+const (
+	formatBrat    = "brat"
+	formatWebAnno = "webanno"
+	formatCoNLLU  = "conllu"
+)
+
+var annotationFormats = []string{formatBrat, formatWebAnno, formatCoNLLU}
+
+// annotatedDocument is a document read from another annotation tool, along
+// with the labelled spans of its text.
+type annotatedDocument struct {
+	Display string
+	Content string
+	Spans   []annotationSpan
+
+	// Problems are parts of the source that couldn't be read, such as
+	// tokens that aren't in the document's text.
+	Problems []string
+}
+
+// annotationSpan is a labelled part of a document's text. Start and End are
+// byte offsets into the content.
+type annotationSpan struct {
+	Start int
+	End   int
+	Label string
+
+	// Text is the text that the source says is at the offsets, if it
+	// says. It is checked against the content.
+	Text string
+
+	// Source locates the annotation in the source, for messages.
+	Source string
+}
+
+// uploadedFile is a file uploaded with a form.
+type uploadedFile struct {
+	Name string
+	Data []byte
+}
+
+// parseAnnotations reads documents from files in the given format. How
+// `field` is used depends on the format: see parseWebAnno and parseCoNLLU.
+func parseAnnotations(
+	format, field string,
+	files []uploadedFile,
+) ([]*annotatedDocument, error) {
+	if len(files) == 0 {
+		return nil, ue("No files were uploaded.")
+	}
+	switch format {
+	case formatBrat:
+		return parseBrat(files)
+	case formatWebAnno:
+		docs := make([]*annotatedDocument, 0, len(files))
+		for _, f := range files {
+			d, err := parseWebAnno(f, field)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, d)
+		}
+		return docs, nil
+	case formatCoNLLU:
+		docs := make([]*annotatedDocument, 0, len(files))
+		for _, f := range files {
+			ds, err := parseCoNLLU(f, field)
+			if err != nil {
+				return nil, err
+			}
+			docs = append(docs, ds...)
+		}
+		return docs, nil
+	}
+	return nil, ue("Unknown annotation format **%s**.", format)
+}
+
+var reInvalidDisplay = regexp.MustCompile("[^-a-zA-Z0-9 ]+")
+
+// importDisplay makes a document name from a file name or identifier used
+// by another tool, removing any extension and characters that aren't
+// allowed in document names.
+func importDisplay(name string) string {
+	name = strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	name = reInvalidDisplay.ReplaceAllString(name, " ")
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// byteOffsets maps the character offsets used by other tools to byte
+// offsets into `content`. Characters are code points, or UTF-16 code units
+// if `utf16` is set, as they are in tools written in Java. There is an
+// entry for every offset up to and including the end of the content.
+func byteOffsets(content string, utf16 bool) []int {
+	offs := make([]int, 0, len(content)+1)
+	for i, r := range content {
+		offs = append(offs, i)
+		if utf16 && r >= 0x10000 {
+			offs = append(offs, i)
+		}
+	}
+	return append(offs, len(content))
+}
+
+// parseBrat reads documents in BRAT standoff format. Each document is a
+// pair of files with the same name: its text in `.txt` and its annotations
+// in `.ann`. Only text-bound annotations (`T` lines) are read, and their
+// type is the label. The offsets of discontinuous annotations are read as
+// separate spans.
+func parseBrat(files []uploadedFile) ([]*annotatedDocument, error) {
+	texts := make(map[string][]byte)
+	anns := make(map[string][]byte)
+	for _, f := range files {
+		base := strings.TrimSuffix(f.Name, filepath.Ext(f.Name))
+		switch strings.ToLower(filepath.Ext(f.Name)) {
+		case ".txt":
+			texts[base] = f.Data
+		case ".ann":
+			anns[base] = f.Data
+		default:
+			return nil, ue("**%s** is not a BRAT text (.txt) or annotation "+
+				"(.ann) file.", f.Name)
+		}
+	}
+	names := make([]string, 0, len(texts))
+	for base := range texts {
+		names = append(names, base)
+	}
+	for base := range anns {
+		if _, ok := texts[base]; !ok {
+			return nil, ue("**%s.ann** has no text file **%s.txt**.",
+				base, base)
+		}
+	}
+	sort.Strings(names)
+
+	docs := make([]*annotatedDocument, 0, len(names))
+	for _, base := range names {
+		d := &annotatedDocument{
+			Display: importDisplay(base),
+			Content: string(texts[base]),
+		}
+		offs := byteOffsets(d.Content, false)
+		lines := bufio.NewScanner(bytes.NewReader(anns[base]))
+		for n := 1; lines.Scan(); n++ {
+			line := lines.Text()
+			if !strings.HasPrefix(line, "T") {
+				continue
+			}
+			src := base + ".ann line " + strconv.Itoa(n)
+			fields := strings.SplitN(line, "\t", 3)
+			if len(fields) != 3 {
+				d.Problems = append(d.Problems, src+": not a text-bound "+
+					"annotation.")
+				continue
+			}
+			src += " (" + fields[0] + ")"
+			spans, err := bratSpans(fields[1], fields[2], src, offs)
+			if err != nil {
+				d.Problems = append(d.Problems, src+": "+plainError(err))
+				continue
+			}
+			d.Spans = append(d.Spans, spans...)
+		}
+		if err := lines.Err(); err != nil {
+			return nil, ue("Could not read **%s.ann**: %s", base, err)
+		}
+		docs = append(docs, d)
+	}
+	return docs, nil
+}
+
+// bratSpans reads the type and offsets of a BRAT text-bound annotation,
+// such as `Positive 0 5;10 14`. The text of a discontinuous annotation is
+// its fragments joined by spaces, so it is split up again for each span.
+func bratSpans(
+	typeOffsets, text, src string,
+	offs []int,
+) ([]annotationSpan, error) {
+	fields := strings.SplitN(typeOffsets, " ", 2)
+	if len(fields) != 2 {
+		return nil, ue("Missing offsets.")
+	}
+	spans := make([]annotationSpan, 0, 1)
+	for _, frag := range strings.Split(fields[1], ";") {
+		pair := strings.Fields(frag)
+		if len(pair) != 2 {
+			return nil, ue("Invalid offsets **%s**.", frag)
+		}
+		start, err1 := strconv.Atoi(pair[0])
+		end, err2 := strconv.Atoi(pair[1])
+		if err1 != nil || err2 != nil || start < 0 || start > end ||
+			end >= len(offs) {
+			return nil, ue("Invalid offsets **%s**.", frag)
+		}
+		start, end = offs[start], offs[end]
+
+		var fragText string
+		if n := end - start; n <= len(text) {
+			fragText, text = text[:n], strings.TrimPrefix(text[n:], " ")
+		}
+		spans = append(spans, annotationSpan{
+			Start:  start,
+			End:    end,
+			Label:  fields[0],
+			Text:   fragText,
+			Source: src,
+		})
+	}
+	return spans, nil
+}
+
+// parseWebAnno reads a document in WebAnno TSV 3 format. The text is
+// rebuilt from the `#Text` lines at the offsets of their tokens.
+//
+// Labels are read from the feature column named by `field`, either in full
+// (`webanno.custom.Sentiment|label`) or by its layer's short name
+// (`Sentiment`). The first span layer's first feature is used if no field
+// is given. Stacked labels are all read, and the `[n]` suffixes that link
+// the tokens of a span are dropped, since each token is labelled anyway.
+func parseWebAnno(f uploadedFile, field string) (*annotatedDocument, error) {
+	d := &annotatedDocument{Display: importDisplay(f.Name)}
+	columns := make([]string, 0)
+	spanColumns := make([]string, 0)
+	type webAnnoToken struct {
+		start, end int
+		text       string
+		labels     []string
+		src        string
+	}
+	var (
+		content strings.Builder
+		length  int // of the content in UTF-16 code units
+		toks    []webAnnoToken
+		text    []string
+	)
+	flush := func(first int) error {
+		if len(text) == 0 {
+			return nil
+		}
+		sentence := strings.Join(text, "\n")
+		text = nil
+		if first < length {
+			return ue("**%s**: sentences overlap at offset %d.",
+				f.Name, first)
+		}
+		if first > length && length > 0 {
+			content.WriteString("\n")
+			length++
+		}
+		for ; length < first; length++ {
+			content.WriteString(" ")
+		}
+		content.WriteString(sentence)
+		length += len(byteOffsets(sentence, true)) - 1
+		return nil
+	}
+
+	col := -1
+	lines := bufio.NewScanner(bytes.NewReader(f.Data))
+	lines.Buffer(nil, 1<<24)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimRight(lines.Text(), "\r")
+		switch {
+		case n == 1 && !strings.HasPrefix(line, "#FORMAT=WebAnno TSV 3"):
+			return nil, ue("**%s** is not a WebAnno TSV 3 file.", f.Name)
+		case strings.HasPrefix(line, "#T_"):
+			kind := line[1:5]
+			pieces := strings.Split(line[6:], "|")
+			for _, feat := range pieces[1:] {
+				name := pieces[0] + "|" + feat
+				columns = append(columns, name)
+				if kind == "T_SP" {
+					spanColumns = append(spanColumns, name)
+				}
+			}
+		case strings.HasPrefix(line, "#Text="):
+			text = append(text, strings.TrimPrefix(line, "#Text="))
+		case len(line) == 0 || strings.HasPrefix(line, "#"):
+		default:
+			if col == -1 {
+				var err error
+				if col, err = webAnnoColumn(columns, spanColumns,
+					field); err != nil {
+					return nil, ue("**%s**: %s", f.Name, err)
+				}
+			}
+			fields := strings.Split(line, "\t")
+			src := f.Name + " line " + strconv.Itoa(n)
+			if len(fields) < 3 {
+				d.Problems = append(d.Problems, src+": not a token.")
+				continue
+			}
+			if strings.Contains(fields[0], ".") {
+				// Sub-tokens overlap their token and are skipped.
+				continue
+			}
+			var end int
+			pair := strings.SplitN(fields[1], "-", 2)
+			start, err1 := strconv.Atoi(pair[0])
+			if len(pair) == 2 {
+				end, _ = strconv.Atoi(pair[1])
+			}
+			if err1 != nil || len(pair) != 2 || start > end {
+				d.Problems = append(d.Problems,
+					src+": invalid offsets "+fields[1]+".")
+				continue
+			}
+			if err := flush(start); err != nil {
+				return nil, err
+			}
+			tok := webAnnoToken{start: start, end: end, text: fields[2],
+				src: src}
+			if i := 3 + col; i < len(fields) {
+				tok.labels = webAnnoLabels(fields[i])
+			}
+			toks = append(toks, tok)
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, ue("Could not read **%s**: %s", f.Name, err)
+	}
+	if err := flush(length); err != nil {
+		return nil, err
+	}
+	d.Content = content.String()
+
+	offs := byteOffsets(d.Content, true)
+	for _, tok := range toks {
+		if tok.end >= len(offs) {
+			d.Problems = append(d.Problems, tok.src+": offsets are past "+
+				"the end of the text.")
+			continue
+		}
+		for _, label := range tok.labels {
+			d.Spans = append(d.Spans, annotationSpan{
+				Start:  offs[tok.start],
+				End:    offs[tok.end],
+				Label:  label,
+				Text:   tok.text,
+				Source: tok.src,
+			})
+		}
+	}
+	return d, nil
+}
+
+// webAnnoColumn finds the index of the feature column named `field`
+// among the annotation columns of a WebAnno file.
+func webAnnoColumn(columns, spanColumns []string, field string) (int, error) {
+	if len(field) == 0 {
+		if len(spanColumns) == 0 {
+			return 0, ue("There are no span annotations.")
+		}
+		field = spanColumns[0]
+	}
+	for i, name := range columns {
+		layer := strings.SplitN(name, "|", 2)[0]
+		short := layer[strings.LastIndex(layer, ".")+1:]
+		if name == field || strings.EqualFold(short, field) {
+			return i, nil
+		}
+	}
+	return 0, ue("There is no annotation column **%s**. The columns are: "+
+		"%s.", field, strings.Join(columns, ", "))
+}
+
+var reWebAnnoIndex = regexp.MustCompile(`\[[0-9_]+\]$`)
+
+// webAnnoLabels returns the labels in a WebAnno feature column. `_` means
+// there is no annotation and `*` an annotation without a label.
+func webAnnoLabels(value string) []string {
+	labels := make([]string, 0)
+	if value == "_" || value == "*" {
+		return labels
+	}
+	for _, label := range strings.Split(value, "|") {
+		label = reWebAnnoIndex.ReplaceAllString(label, "")
+		label = strings.Replace(label, `\`, "", -1)
+		if len(label) > 0 && label != "*" && label != "_" {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// conlluColumns are the CoNLL-U columns that can be used as labels, by
+// their index.
+var conlluColumns = map[string]int{
+	"LEMMA": 2, "UPOS": 3, "XPOS": 4, "DEPREL": 7,
+}
+
+// parseCoNLLU reads documents in CoNLL-U format. A file holds a single
+// document unless it has `# newdoc id = ...` comments, which start a new
+// document named by the id. The text of each sentence is taken from its
+// `# text = ...` comment (or its word forms and `SpaceAfter=No`), and
+// sentences are separated by new lines.
+//
+// Labels are read from the column named by `field` (LEMMA, UPOS, XPOS or
+// DEPREL), or else from the FEATS or MISC attribute of that name.
+func parseCoNLLU(f uploadedFile, field string) ([]*annotatedDocument, error) {
+	if len(field) == 0 {
+		return nil, ue("Please name the CoNLL-U column or attribute that " +
+			"holds the labels.")
+	}
+	docs := make([]*annotatedDocument, 0, 1)
+	var (
+		d        *annotatedDocument
+		content  strings.Builder
+		sentText string
+		hasText  bool
+		words    [][]string
+		wordSrc  []string
+	)
+	newDoc := func(name string) {
+		if d != nil {
+			d.Content = content.String()
+		}
+		d = &annotatedDocument{Display: importDisplay(name)}
+		docs = append(docs, d)
+		content.Reset()
+	}
+	endSentence := func() {
+		if len(words) == 0 {
+			return
+		}
+		if d == nil {
+			newDoc(f.Name)
+		}
+		if content.Len() > 0 {
+			content.WriteString("\n")
+		}
+		if !hasText {
+			sentText = conlluText(words)
+		}
+		base := content.Len()
+		content.WriteString(sentText)
+		conlluSpans(d, sentText, base, words, wordSrc, field)
+		words, wordSrc, sentText, hasText = nil, nil, "", false
+	}
+
+	lines := bufio.NewScanner(bytes.NewReader(f.Data))
+	lines.Buffer(nil, 1<<24)
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimRight(lines.Text(), "\r")
+		switch {
+		case len(strings.TrimSpace(line)) == 0:
+			endSentence()
+		case strings.HasPrefix(line, "#"):
+			key, value := conlluComment(line)
+			switch key {
+			case "newdoc id", "newdoc":
+				endSentence()
+				if len(value) == 0 {
+					value = f.Name
+				}
+				newDoc(value)
+			case "text":
+				sentText, hasText = value, true
+			}
+		default:
+			cols := strings.Split(line, "\t")
+			if len(cols) != 10 {
+				return nil, ue("**%s** line %d does not have 10 columns.",
+					f.Name, n)
+			}
+			words = append(words, cols)
+			wordSrc = append(wordSrc, f.Name+" line "+strconv.Itoa(n))
+		}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, ue("Could not read **%s**: %s", f.Name, err)
+	}
+	endSentence()
+	if d == nil {
+		return nil, ue("**%s** has no sentences.", f.Name)
+	}
+	d.Content = content.String()
+	return docs, nil
+}
+
+// conlluComment splits a comment such as `# text = Hello` into its key and
+// value.
+func conlluComment(line string) (key, value string) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+	pieces := strings.SplitN(line, "=", 2)
+	key = strings.TrimSpace(pieces[0])
+	if len(pieces) == 2 {
+		value = strings.TrimSpace(pieces[1])
+	}
+	return key, value
+}
+
+// conlluText rebuilds the text of a sentence from its surface tokens.
+func conlluText(words [][]string) string {
+	var text strings.Builder
+	skipTo := 0
+	for _, cols := range words {
+		id := cols[0]
+		if strings.Contains(id, ".") || conlluIndex(id) <= skipTo {
+			continue
+		}
+		if r := strings.SplitN(id, "-", 2); len(r) == 2 {
+			skipTo = conlluIndex(r[1])
+		}
+		text.WriteString(cols[1])
+		if conlluAttr(cols[9], "SpaceAfter") != "No" {
+			text.WriteString(" ")
+		}
+	}
+	return strings.TrimRight(text.String(), " ")
+}
+
+// conlluSpans finds the words of a sentence in its text and adds a span for
+// each of their labels. The words of a multiword token share its span.
+func conlluSpans(
+	d *annotatedDocument,
+	text string,
+	base int,
+	words [][]string,
+	wordSrc []string,
+	field string,
+) {
+	cursor := 0
+	var start, end, skipTo int
+	for i, cols := range words {
+		id := cols[0]
+		if strings.Contains(id, ".") {
+			continue
+		}
+		if conlluIndex(id) > skipTo {
+			// A surface token: find it in the text.
+			at := strings.Index(text[cursor:], cols[1])
+			if at == -1 {
+				d.Problems = append(d.Problems, wordSrc[i]+": "+cols[1]+
+					" is not in the sentence text.")
+				start, end = -1, -1
+			} else {
+				start, end = cursor+at, cursor+at+len(cols[1])
+				cursor = end
+			}
+			if r := strings.SplitN(id, "-", 2); len(r) == 2 {
+				skipTo = conlluIndex(r[1])
+			}
+		}
+		label := conlluLabel(cols, field)
+		if start == -1 || len(label) == 0 || label == "_" {
+			continue
+		}
+		d.Spans = append(d.Spans, annotationSpan{
+			Start:  base + start,
+			End:    base + end,
+			Label:  label,
+			Source: wordSrc[i],
+		})
+	}
+}
+
+// conlluIndex returns the number of a word, or the first word of a range.
+func conlluIndex(id string) int {
+	n, _ := strconv.Atoi(strings.SplitN(id, "-", 2)[0])
+	return n
+}
+
+// conlluLabel returns the label of a word from the named column or
+// attribute.
+func conlluLabel(cols []string, field string) string {
+	if i, ok := conlluColumns[strings.ToUpper(field)]; ok {
+		return cols[i]
+	}
+	if v := conlluAttr(cols[9], field); len(v) > 0 {
+		return v
+	}
+	return conlluAttr(cols[5], field)
+}
+
+// conlluAttr returns the value of an attribute in a FEATS or MISC column,
+// such as `SpaceAfter` in `SpaceAfter=No|Gloss=cat`.
+func conlluAttr(attrs, name string) string {
+	if attrs == "_" {
+		return ""
+	}
+	for _, attr := range strings.Split(attrs, "|") {
+		pieces := strings.SplitN(attr, "=", 2)
+		if len(pieces) == 2 && pieces[0] == name {
+			return pieces[1]
+		}
+	}
+	return ""
+}
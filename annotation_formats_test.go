@@ -0,0 +1,239 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fixture joins the lines of a test file, so that tabs in them stay visible.
+func fixture(ls ...string) []byte {
+	return []byte(strings.Join(ls, "\n") + "\n")
+}
+
+// checkAnnotated compares parsed documents with the expected ones.
+func checkAnnotated(t *testing.T, got, want []*annotatedDocument) {
+	if len(got) != len(want) {
+		t.Fatalf("got %d documents, want %d", len(got), len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Display != w.Display {
+			t.Errorf("document %d is named %q, want %q",
+				i, g.Display, w.Display)
+		}
+		if g.Content != w.Content {
+			t.Errorf("%s: content is %q, want %q",
+				w.Display, g.Content, w.Content)
+		}
+		if !reflect.DeepEqual(g.Spans, w.Spans) {
+			t.Errorf("%s: spans are\n%+v\nwant\n%+v",
+				w.Display, g.Spans, w.Spans)
+		}
+		if !reflect.DeepEqual(g.Problems, w.Problems) {
+			t.Errorf("%s: problems are\n%q\nwant\n%q",
+				w.Display, g.Problems, w.Problems)
+		}
+	}
+}
+
+func TestParseBrat(t *testing.T) {
+	files := []uploadedFile{
+		{"day.txt", []byte("Good day, ünïcode!")},
+		{"day.ann", fixture(
+			"T1\tPositive 0 4\tGood",
+			"T2\tPlace 10 17\tünïcode",
+			"T3\tPositive 0 4;5 8\tGood day",
+			"A1\tNegated T1",
+			"#1\tAnnotatorNotes T1\tA note",
+			"T4\tPlace 10 19\tünïcode!",
+			"T5\tPositive 0",
+			"T6\tPositive 4 2\tx",
+		)},
+		{"empty.txt", []byte("No annotations")},
+	}
+	docs, err := parseBrat(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := func(n, id string) string { return "day.ann line " + n + id }
+	checkAnnotated(t, docs, []*annotatedDocument{
+		{
+			Display: "day",
+			Content: "Good day, ünïcode!",
+			Spans: []annotationSpan{
+				{0, 4, "Positive", "Good", src("1", " (T1)")},
+				{10, 19, "Place", "ünïcode", src("2", " (T2)")},
+				{0, 4, "Positive", "Good", src("3", " (T3)")},
+				{5, 8, "Positive", "day", src("3", " (T3)")},
+			},
+			Problems: []string{
+				src("6", " (T4)") + ": Invalid offsets 10 19.",
+				src("7", "") + ": not a text-bound annotation.",
+				src("8", " (T6)") + ": Invalid offsets 4 2.",
+			},
+		},
+		{Display: "empty", Content: "No annotations"},
+	})
+
+	for _, files := range [][]uploadedFile{
+		{{"day.ann", []byte("T1\tPositive 0 4\tGood\n")}},
+		{{"day.csv", []byte("")}},
+	} {
+		if _, err := parseBrat(files); err == nil {
+			t.Errorf("%s was accepted", files[0].Name)
+		}
+	}
+}
+
+func TestParseWebAnno(t *testing.T) {
+	// The emoji is two UTF-16 code units long, as offsets count it.
+	f := uploadedFile{"Sentiment.tsv", fixture(
+		"#FORMAT=WebAnno TSV 3.2",
+		"#T_SP=webanno.custom.Sentiment|label|polarity",
+		"",
+		"",
+		"#Text=Good day",
+		"1-1\t0-4\tGood\tPositive[1]\t_",
+		"1-2\t5-8\tday\tPositive[1]\t_",
+		"",
+		"#Text=😀 ok",
+		"2-1\t9-11\t😀\t*\t_",
+		"2-1.1\t9-10\t😀\tIgnored\t_",
+		"2-2\t12-14\tok\tNeutral|Mixed\tweak",
+		"2-3\t12-99\tok\tBad\t_",
+		"2-4\tx",
+	)}
+	d, err := parseWebAnno(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := func(n string) string { return "Sentiment.tsv line " + n }
+	checkAnnotated(t, []*annotatedDocument{d}, []*annotatedDocument{{
+		Display: "Sentiment",
+		Content: "Good day\n😀 ok",
+		Spans: []annotationSpan{
+			{0, 4, "Positive", "Good", src("6")},
+			{5, 8, "Positive", "day", src("7")},
+			{14, 16, "Neutral", "ok", src("12")},
+			{14, 16, "Mixed", "ok", src("12")},
+		},
+		Problems: []string{
+			src("14") + ": not a token.",
+			src("13") + ": offsets are past the end of the text.",
+		},
+	}})
+
+	d, err = parseWebAnno(f, "webanno.custom.Sentiment|polarity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Spans) != 1 || d.Spans[0].Label != "weak" {
+		t.Errorf("spans of the polarity column are %+v", d.Spans)
+	}
+	if _, err := parseWebAnno(f, "Nope"); err == nil {
+		t.Error("a missing column was accepted")
+	}
+	if _, err := parseWebAnno(uploadedFile{"x.tsv", []byte("x\n")},
+		""); err == nil {
+		t.Error("a file that isn't WebAnno TSV 3 was accepted")
+	}
+}
+
+// conllu makes the ten columns of a CoNLL-U word.
+func conllu(id, form, upos, misc string) string {
+	return strings.Join([]string{
+		id, form, "_", upos, "_", "_", "_", "_", "_", misc}, "\t")
+}
+
+func TestParseCoNLLU(t *testing.T) {
+	f := uploadedFile{"corpus.conllu", fixture(
+		"# newdoc id = doc-1",
+		"# text = Vámonos al mar.",
+		conllu("1-2", "Vámonos", "_", "_"),
+		conllu("1", "Vamos", "VERB", "_"),
+		conllu("2", "nos", "PRON", "_"),
+		conllu("3-4", "al", "_", "_"),
+		conllu("3", "a", "ADP", "_"),
+		conllu("4", "el", "DET", "_"),
+		conllu("5", "mar", "NOUN", "SpaceAfter=No"),
+		conllu("6", ".", "PUNCT", "_"),
+		"",
+		"# text = Not here",
+		conllu("1", "Missing", "X", "_"),
+		"",
+		"# newdoc id = doc-2",
+		conllu("1", "Hi", "INTJ", "SpaceAfter=No"),
+		conllu("2", "!", "PUNCT", "_"),
+		conllu("2.1", "elided", "X", "_"),
+		"",
+		conllu("1-2", "del", "_", "_"),
+		conllu("1", "de", "ADP", "_"),
+		conllu("2", "el", "DET", "_"),
+		conllu("3", "mar", "NOUN", "_"),
+	)}
+	docs, err := parseCoNLLU(f, "upos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := func(n string) string { return "corpus.conllu line " + n }
+	checkAnnotated(t, docs, []*annotatedDocument{
+		{
+			Display: "doc-1",
+			Content: "Vámonos al mar.\nNot here",
+			Spans: []annotationSpan{
+				{0, 8, "VERB", "", src("4")},
+				{0, 8, "PRON", "", src("5")},
+				{9, 11, "ADP", "", src("7")},
+				{9, 11, "DET", "", src("8")},
+				{12, 15, "NOUN", "", src("9")},
+				{15, 16, "PUNCT", "", src("10")},
+			},
+			Problems: []string{
+				src("13") + ": Missing is not in the sentence text.",
+			},
+		},
+		{
+			Display: "doc-2",
+			Content: "Hi!\ndel mar",
+			Spans: []annotationSpan{
+				{0, 2, "INTJ", "", src("16")},
+				{2, 3, "PUNCT", "", src("17")},
+				{4, 7, "ADP", "", src("21")},
+				{4, 7, "DET", "", src("22")},
+				{8, 11, "NOUN", "", src("23")},
+			},
+		},
+	})
+
+	// Labels can come from the MISC and FEATS attributes too.
+	f = uploadedFile{"gloss.conllu", fixture(
+		strings.Join([]string{"1", "Hola", "_", "_", "_",
+			"Polite=Form", "_", "_", "_", "Gloss=hello"}, "\t"),
+	)}
+	for field, label := range map[string]string{
+		"Gloss": "hello", "Polite": "Form"} {
+		docs, err := parseCoNLLU(f, field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(docs[0].Spans) != 1 || docs[0].Spans[0].Label != label {
+			t.Errorf("%s: spans are %+v, want %s",
+				field, docs[0].Spans, label)
+		}
+	}
+
+	for _, test := range []struct {
+		name, field string
+		data        []byte
+	}{
+		{"no field", "", fixture(conllu("1", "a", "X", "_"))},
+		{"short line", "upos", fixture("1\ta\t_")},
+		{"no sentences", "upos", fixture("# text = a")},
+	} {
+		f := uploadedFile{"x.conllu", test.data}
+		if _, err := parseCoNLLU(f, test.field); err == nil {
+			t.Errorf("%s: accepted", test.name)
+		}
+	}
+}
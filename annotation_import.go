@@ -0,0 +1,398 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// formAnnotationImport is posted when files are uploaded and again when
+// their labels have been mapped. After the upload, the documents read from
+// the files are carried in Data as JSON.
+type formAnnotationImport struct {
+	UploadFiles string
+	Format      string
+	Field       string
+	Scheme      string
+	Recorded    string
+	Data        string
+
+	// Labels and Mapping pair each label in the files with the key of the
+	// category it becomes. Labels mapped to nothing aren't imported.
+	Labels  []string
+	Mapping []string
+	Apply   bool
+}
+
+// importAnnotationsPage adds documents annotated with another tool to a
+// project. The uploaded files are read and checked first, and their labels
+// are then mapped to the categories of a scoring scheme before anything is
+// saved.
+func importAnnotationsPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	show := func(
+		form formAnnotationImport,
+		imp *annotationImport,
+		msg string,
+	) {
+		w.html("annotation-import", m{
+			"Title":   "Import annotations into " + proj.Display,
+			"Nav":     documentNav(w, proj, nil, "Import annotations"),
+			"P":       proj,
+			"Form":    form,
+			"Import":  imp,
+			"Formats": annotationFormats,
+			"Schemes": conf.Categories(),
+			"Conf":    conf,
+			"Message": formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(formAnnotationImport{
+			Recorded: time.Now().Format(recordedFmt),
+		}, nil, "")
+		return
+	} else if w.r.Method != "POST" {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+
+	var form formAnnotationImport
+	w.multiDecode(&form)
+	var docs []*annotatedDocument
+	if len(form.Data) == 0 {
+		if _, ok := conf.Scores[form.Scheme]; !ok {
+			show(form, nil, "Please choose a scoring scheme.")
+			return
+		}
+		field := form.Field
+		if len(field) == 0 && form.Format == formatCoNLLU {
+			field = form.Scheme
+		}
+		var err error
+		if docs, err = parseAnnotations(form.Format, field,
+			w.uploadedFiles("UploadFiles")); err != nil {
+			show(form, nil, err.Error())
+			return
+		}
+		data, err := json.Marshal(docs)
+		assert(err)
+		form.Data = string(data)
+	} else if err := json.Unmarshal([]byte(form.Data), &docs); err != nil {
+		panic(ue("Could not read the uploaded documents: %s", err))
+	}
+
+	imp, err := proj.checkAnnotationImport(w.user, form.Scheme,
+		form.Recorded, docs)
+	if err != nil {
+		show(form, nil, err.Error())
+		return
+	}
+	if !form.Apply {
+		show(form, imp, "")
+		return
+	}
+	if err := imp.setMapping(form.Labels, form.Mapping); err != nil {
+		show(form, imp, err.Error())
+		return
+	}
+	res, err := proj.applyAnnotationImport(w.user, imp)
+	if err != nil {
+		show(form, imp, err.Error())
+		return
+	}
+	msg := fmt.Sprintf("Added %d documents with %d scores.",
+		res.Documents, res.Scores)
+	if len(res.Unmapped) > 0 {
+		msg += " These labels weren't mapped to a category and weren't " +
+			"imported: " + strings.Join(res.Unmapped, ", ") + "."
+	}
+	show(formAnnotationImport{Recorded: form.Recorded}, nil, msg)
+}
+
+// uploadedFiles reads every file uploaded in the named field of a
+// multipart form.
+func (w *web) uploadedFiles(field string) []uploadedFile {
+	files := make([]uploadedFile, 0)
+	if w.r.MultipartForm == nil {
+		return files
+	}
+	for _, header := range w.r.MultipartForm.File[field] {
+		file, err := header.Open()
+		if err != nil {
+			panic(ue("There was a problem uploading **%s**: %s",
+				header.Filename, err))
+		}
+		data, err := ioutil.ReadAll(file)
+		file.Close()
+		if err != nil {
+			panic(ue("There was a problem reading **%s**: %s",
+				header.Filename, err))
+		}
+		files = append(files, uploadedFile{header.Filename, data})
+	}
+	return files
+}
+
+// annotationImport is a set of documents from another annotation tool
+// after their spans have been matched to words.
+type annotationImport struct {
+	Scheme    string
+	Recorded  time.Time
+	Documents []*importedDocument
+	Labels    []*importLabel
+}
+
+// importedDocument is a document from another annotation tool along with
+// the label of each of its words.
+type importedDocument struct {
+	*annotatedDocument
+	Tokens []token
+	Words  map[int]string
+
+	// Error says why the document can't be added, for example because the
+	// project already has a document with the same name.
+	Error string
+}
+
+// importLabel is a label used in an import, the number of words it is on
+// and the key of the category it is mapped to.
+type importLabel struct {
+	Label    string
+	Words    int
+	Category string
+}
+
+// Errors returns the number of documents that can't be added.
+func (imp *annotationImport) Errors() int {
+	n := 0
+	for _, d := range imp.Documents {
+		if len(d.Error) > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// checkAnnotationImport matches the spans of each document to its words and
+// checks that the documents can be added to the project. Each label is
+// mapped to the category of the scheme with the same key or name, if
+// there is one.
+func (proj *project) checkAnnotationImport(
+	user *lcmUser,
+	scheme, recorded string,
+	docs []*annotatedDocument,
+) (*annotationImport, error) {
+	if _, ok := conf.Scores[scheme]; !ok {
+		return nil, ue("Scoring scheme **%s** does not exist.", scheme)
+	}
+	rec, err := time.Parse(recordedFmt, recorded)
+	if err != nil {
+		return nil, ue("Could not parse date **%s**. Please use the "+
+			"format YYYY-MM-DD.", recorded)
+	}
+	if len(docs) == 0 {
+		return nil, ue("No documents were found in the uploaded files.")
+	}
+
+	imp := &annotationImport{Scheme: scheme, Recorded: rec}
+	names := make(map[string]bool)
+	counts := make(map[string]int)
+	for _, ad := range docs {
+		d := &importedDocument{annotatedDocument: ad}
+		d.matchWords()
+		for _, label := range d.Words {
+			counts[label]++
+		}
+
+		doc := &document{
+			Project:    proj,
+			Display:    ad.Display,
+			Name:       displayToName(ad.Display),
+			Recorded:   rec,
+			Categories: []string{scheme},
+			CreatedBy:  user,
+		}
+		if err := doc.validate(); err != nil {
+			d.Error = plainError(err)
+		} else if names[doc.Name] {
+			d.Error = "Another uploaded document has the same name."
+		}
+		names[doc.Name] = true
+		imp.Documents = append(imp.Documents, d)
+	}
+
+	for label, n := range counts {
+		cat := schemeCategoryKey(scheme, label)
+		if _, ok := conf.Scores[scheme].Categories[cat]; !ok {
+			cat = ""
+		}
+		imp.Labels = append(imp.Labels, &importLabel{label, n, cat})
+	}
+	sort.Slice(imp.Labels, func(i, j int) bool {
+		return imp.Labels[i].Label < imp.Labels[j].Label
+	})
+	return imp, nil
+}
+
+// matchWords labels the words of a document that are covered by its spans.
+// A span must cover whole words and its text, if the source gave it, must
+// be the text at its offsets. Spans that don't are added to the document's
+// problems and skipped, and spans of punctuation alone are ignored. If
+// spans with different labels cover the same word, the first is used.
+func (d *importedDocument) matchWords() {
+	d.Tokens = tokenize(d.Content)
+	d.Words = make(map[int]string)
+	problem := func(sp annotationSpan, format string, v ...interface{}) {
+		d.Problems = append(d.Problems,
+			sp.Source+": "+fmt.Sprintf(format, v...))
+	}
+	for _, sp := range d.Spans {
+		if sp.Start < 0 || sp.End > len(d.Content) || sp.Start >= sp.End {
+			problem(sp, "the offsets are outside the text.")
+			continue
+		}
+		text := d.Content[sp.Start:sp.End]
+		if len(sp.Text) > 0 && text != sp.Text {
+			problem(sp, "the text at its offsets is %q, not %q.",
+				text, sp.Text)
+			continue
+		}
+		if len(tokenize(text)) == 0 {
+			// Punctuation isn't scored, so there's nothing to label.
+			continue
+		}
+
+		first := sort.Search(len(d.Tokens), func(i int) bool {
+			return d.Tokens[i].End > sp.Start
+		})
+		last := first
+		for last < len(d.Tokens) && d.Tokens[last].Start < sp.End {
+			last++
+		}
+		if first == last {
+			problem(sp, "%q does not cover any word.", text)
+			continue
+		}
+		if tok := d.Tokens[first]; tok.Start < sp.Start {
+			problem(sp, "%q starts in the middle of the word %q.",
+				text, tok.Text)
+			continue
+		}
+		if tok := d.Tokens[last-1]; tok.End > sp.End {
+			problem(sp, "%q ends in the middle of the word %q.",
+				text, tok.Text)
+			continue
+		}
+		for _, tok := range d.Tokens[first:last] {
+			if label, ok := d.Words[tok.Index]; ok && label != sp.Label {
+				problem(sp, "word %d (%q) is already labelled %s.",
+					tok.Index, tok.Text, label)
+				continue
+			}
+			d.Words[tok.Index] = sp.Label
+		}
+	}
+}
+
+// setMapping maps labels to the keys of categories in the import's scheme.
+// An empty key leaves the label unmapped.
+func (imp *annotationImport) setMapping(labels, mapping []string) error {
+	if len(labels) != len(mapping) {
+		return ue("The mapping of labels to categories is incomplete.")
+	}
+	cats := make(map[string]string)
+	for i, label := range labels {
+		cats[label] = mapping[i]
+	}
+	for _, l := range imp.Labels {
+		l.Category = cats[l.Label]
+		if len(l.Category) == 0 {
+			continue
+		}
+		if _, ok := conf.Scores[imp.Scheme].Categories[l.Category]; !ok {
+			return ue("**%s** is not a category in the **%s** scoring "+
+				"scheme.", l.Category, imp.Scheme)
+		}
+	}
+	return nil
+}
+
+// annotationImportResult describes what an import of annotations saved.
+type annotationImportResult struct {
+	Documents int
+	Scores    int
+	Unmapped  []string
+}
+
+// applyAnnotationImport adds the documents of an import to the project and
+// scores their words with the categories their labels are mapped to. All
+// documents and scores are added in one transaction, so nothing is added if
+// any document can't be.
+func (proj *project) applyAnnotationImport(
+	user *lcmUser,
+	imp *annotationImport,
+) (annotationImportResult, error) {
+	var res annotationImportResult
+	if n := imp.Errors(); n > 0 {
+		return res, ue("%d documents can't be added. Please rename or "+
+			"remove them and upload the files again.", n)
+	}
+	cats := make(map[string]string)
+	for _, l := range imp.Labels {
+		if len(l.Category) == 0 {
+			res.Unmapped = append(res.Unmapped, l.Label)
+		}
+		cats[l.Label] = l.Category
+	}
+
+	docs := make([]*document, len(imp.Documents))
+	words := make([][]int, len(imp.Documents))
+	for i, id := range imp.Documents {
+		d, err := newDocument(user, proj, id.Display, imp.Recorded,
+			[]string{imp.Scheme}, id.Content)
+		if err != nil {
+			return res, ue("**%s** can't be added: %s", id.Display, err)
+		}
+		docs[i] = d
+		for word, label := range id.Words {
+			if len(cats[label]) > 0 {
+				words[i] = append(words[i], word)
+			}
+		}
+		sort.Ints(words[i])
+	}
+
+	now := time.Now().UTC()
+	csql.Tx(db, func(tx *sql.Tx) {
+		for i, d := range docs {
+			d.insert(tx)
+			for _, word := range words[i] {
+				csql.Exec(tx, `
+					INSERT INTO score (
+						project_owner, project_name,
+						document_name, document_recorded,
+						word, category, name, created_by, created
+					) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+					`, proj.Owner.Id, proj.Name, d.Name, d.Recorded,
+					word, imp.Scheme, cats[imp.Documents[i].Words[word]],
+					user.Id, now)
+			}
+		}
+	})
+
+	for i, d := range docs {
+		res.Documents++
+		res.Scores += len(words[i])
+		proj.triggerHooks(hookDocumentAdded, user, d)
+		if len(words[i]) > 0 && d.completed() {
+			proj.triggerHooks(hookDocumentCompleted, user, d)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+)
+
+// The JSON API is served under apiPrefix. Its version is part of the URL so
+// that incompatible changes can be made under a new prefix while scripts
+// written against the old one keep working. Scripts authenticate with an
+// API token sent as a bearer credential (see apiToken).
+//
+// Requests with a body send it as a JSON object. Responses use the same
+// envelope as every other JSON response (see w.json and handleJsonError),
+// along with an HTTP status code. A successful response is
+//
+//	{"status": "success", "content": ...}
+//
+// where the content is the JSON representation of the resource (with the
+// same field names as the Go types below). The status code is 200, or 201
+// when something was created. When there is nothing to return, the status
+// code is 204 and there is no body. Errors look like
+//
+//	{"status": "fail", "message": "..."}
+//
+// where the status is classified by errorStatus: "noauth" (401) when the
+// user isn't logged in or their token is invalid, "fail" (400) when the
+// request can't be satisfied (such as a missing project or an invalid
+// score) and "error" (500) for anything else.
+//
+// Lists of projects and documents are paginated. The content is the list
+// itself, and the page is described by the X-Total-Count, X-Page and
+// X-Per-Page headers along with a Link header to the next and previous
+// pages (see listPage).
+var apiPrefix = fmt.Sprintf("/api/v%d", apiVersion)
+
+// apiRoutes registers every route of the API. Each one must be described in
+// apiSpecs.
+func apiRoutes(r martini.Router) {
+	proj := "/projects/:owner/:project"
+	doc := proj + "/documents/:document/:recorded"
+	r.Get("/openapi.json", webGuest, openAPIDocument).Name("api-openapi")
+	r.Get("/schemes", webAuth, apiSchemes).Name("api-schemes")
+	r.Get("/projects", webAuth, apiProjects).Name("api-projects")
+	r.Post("/projects", webAuth, apiAddProject).Name("api-project-add")
+	r.Get(proj, webAuth, apiGetProject).Name("api-project")
+	r.Delete(proj, webAuth, apiDeleteProject).Name("api-project-delete")
+	r.Get(proj+"/collaborators", webAuth, apiCollaborators).
+		Name("api-collaborators")
+	r.Put(proj+"/collaborators", webAuth, apiSetCollaborators).
+		Name("api-collaborators-set")
+	r.Get(proj+"/documents", webAuth, apiDocuments).Name("api-documents")
+	r.Post(proj+"/documents", webAuth, apiAddDocument).
+		Name("api-document-add")
+	r.Get(doc, webAuth, apiGetDocument).Name("api-document")
+	r.Get(doc+"/tokens", webAuth, apiTokens).Name("api-tokens")
+	r.Get(doc+"/scores", webAuth, apiScores).Name("api-scores")
+	r.Put(doc+"/scores/:scheme/:word", webAuth, apiSetScore).
+		Name("api-score-set")
+	r.Delete(doc+"/scores/:scheme/:word", webAuth, apiDeleteScore).
+		Name("api-score-delete")
+	r.Get(proj+"/export/scores", webAuth, apiExportScores).
+		Name("api-export-scores")
+	r.Get(proj+"/export/summary", webAuth, apiExportSummaries).
+		Name("api-export-summary")
+}
+
+var apiStatusCodes = map[string]int{
+	"noauth": http.StatusUnauthorized,
+	"fail":   http.StatusBadRequest,
+	"error":  http.StatusInternalServerError,
+}
+
+type apiError struct {
+	error
+}
+
+// apiResp turns any error raised by the handlers after it into an apiError,
+// in the same way that jsonResp does for jsonError.
+func apiResp(c martini.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(error); ok {
+				if _, ok := err.(apiError); ok {
+					panic(r)
+				} else {
+					panic(apiError{err})
+				}
+			}
+			panic(r)
+		}
+	}()
+	c.Next()
+}
+
+func handleAPIError(err apiError, ren render.Render) {
+	status := errorStatus(err.error)
+	msg := err.Error()
+	if status == "noauth" && len(msg) == 0 {
+		msg = "You must be logged in."
+	}
+	ren.JSON(apiStatusCodes[status], m{
+		"status":  status,
+		"message": strings.Replace(msg, "**", "", -1),
+	})
+}
+
+// apiJSON responds with the JSON representation of `v`.
+func (w *web) apiJSON(status int, v interface{}) {
+	w.ren.JSON(status, m{
+		"status":  "success",
+		"content": v,
+	})
+}
+
+// apiJSONArray responds with a JSON array in the usual envelope, writing
+// each element as soon as `each` adds it so that large results are never
+// held in memory. The output is indented like that of apiJSON.
+//
+// Nothing is written until the first element is added, so an error before
+// then gets the usual error response. An error after that can no longer
+// be reported, so the connection is closed to show the client that the
+// response is incomplete.
+func (w *web) apiJSONArray(each func(add func(v interface{}))) {
+	var out *bufio.Writer
+	write := func(s string) {
+		_, err := out.WriteString(s)
+		assert(err)
+	}
+	start := func() {
+		w.w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.w.WriteHeader(http.StatusOK)
+		out = bufio.NewWriter(w.w)
+		write("{\n  \"content\": [")
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if out == nil {
+				panic(r)
+			}
+			log.Printf("Aborting response to %s: %v", w.r.URL.Path, r)
+			w.abort()
+		}
+	}()
+
+	each(func(v interface{}) {
+		bs, err := json.MarshalIndent(v, "    ", "  ")
+		assert(err)
+		if out == nil {
+			start()
+		} else {
+			write(",")
+		}
+		write("\n    " + string(bs))
+	})
+	if out == nil {
+		start()
+		write("]")
+	} else {
+		write("\n  ]")
+	}
+	write(",\n  \"status\": \"success\"\n}")
+	assert(out.Flush())
+}
+
+// abort closes the connection of a response that has already been started.
+func (w *web) abort() {
+	hj, ok := w.w.(http.Hijacker)
+	if !ok {
+		return
+	}
+	if conn, _, err := hj.Hijack(); err == nil {
+		conn.Close()
+	}
+}
+
+// apiPage describes the page of a list in the headers of the response. It
+// must be called before the response is written.
+func (w *web) apiPage(page listPage) {
+	page.setHeaders(w.w.Header())
+}
+
+// apiNoContent responds with an empty body.
+func (w *web) apiNoContent() {
+	w.w.WriteHeader(http.StatusNoContent)
+}
+
+// decodeJSON decodes the JSON body of a request into `v`.
+func (w *web) decodeJSON(v interface{}) {
+	if err := json.NewDecoder(w.r.Body).Decode(v); err != nil {
+		panic(ue("Could not read the request body as JSON: %s", err))
+	}
+}
+
+type apiUser struct {
+	Id   string
+	Name string
+}
+
+func newAPIUsers(users []*lcmUser) []apiUser {
+	apiUsers := make([]apiUser, len(users))
+	for i, user := range users {
+		apiUsers[i] = apiUser{Id: user.Id, Name: user.Name}
+	}
+	return apiUsers
+}
+
+type apiProject struct {
+	Owner         string
+	Name          string
+	Display       string
+	Added         time.Time
+	Documents     int
+	Collaborators []apiUser
+}
+
+func newAPIProject(proj *project) apiProject {
+	return apiProject{
+		Owner:         proj.Owner.Id,
+		Name:          proj.Name,
+		Display:       proj.Display,
+		Added:         proj.Added,
+		Documents:     proj.NumDocuments(),
+		Collaborators: newAPIUsers(proj.Collaborators()),
+	}
+}
+
+type apiScheme struct {
+	Name       string
+	Version    string
+	Categories []apiCategory
+}
+
+type apiCategory struct {
+	Key   string
+	Name  string
+	Value int
+}
+
+// apiSchemes lists the scoring schemes and their categories in order.
+func apiSchemes(w *web) {
+	schemes := make([]apiScheme, 0, len(conf.Scores))
+	for _, name := range conf.Categories() {
+		scheme := conf.Scores[name]
+		s := apiScheme{
+			Name:       name,
+			Version:    scheme.VersionString(),
+			Categories: make([]apiCategory, 0, len(scheme.Categories)),
+		}
+		for _, cat := range scheme.Ordered() {
+			s.Categories = append(s.Categories, apiCategory{
+				Key:   cat.Key,
+				Name:  cat.Name,
+				Value: cat.Value,
+			})
+		}
+		schemes = append(schemes, s)
+	}
+	w.apiJSON(200, schemes)
+}
+
+// apiProjects lists a page of the projects owned by the user.
+func apiProjects(w *web) {
+	var opts projectListOptions
+	w.decodeQuery(&opts)
+	projs, page := w.user.projects(opts)
+	list := make([]apiProject, len(projs))
+	for i, proj := range projs {
+		list[i] = newAPIProject(proj)
+	}
+	w.apiPage(page)
+	w.apiJSON(200, list)
+}
+
+type apiFormProject struct {
+	Display string
+}
+
+func apiAddProject(w *web) {
+	var form apiFormProject
+	w.decodeJSON(&form)
+	proj, err := insertProject(w.user, form.Display)
+	assert(err)
+	w.apiJSON(http.StatusCreated, newAPIProject(proj))
+}
+
+func apiGetProject(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	w.apiJSON(200, newAPIProject(proj))
+}
+
+// apiDeleteProject deletes a project along with all of its documents and
+// scores.
+func apiDeleteProject(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can delete them."))
+	}
+	proj.delete()
+	w.apiNoContent()
+}
+
+func apiCollaborators(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	w.apiJSON(200, newAPIUsers(proj.Collaborators()))
+}
+
+type apiFormCollaborators struct {
+	Collaborators []string
+}
+
+// apiSetCollaborators replaces the collaborators of a project.
+func apiSetCollaborators(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can change their collaborators."))
+	}
+	var form apiFormCollaborators
+	w.decodeJSON(&form)
+	proj.setCollaborators(form.Collaborators)
+	w.apiJSON(200, newAPIUsers(proj.Collaborators()))
+}
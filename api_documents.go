@@ -0,0 +1,294 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+type apiDocument struct {
+	Name      string
+	Display   string
+	Recorded  string
+	CreatedBy string
+	Created   time.Time
+	Modified  time.Time
+}
+
+func newAPIDocument(d *document) apiDocument {
+	doc := apiDocument{
+		Name:     d.Name,
+		Display:  d.Display,
+		Recorded: d.RecordedKey(),
+		Created:  d.Created,
+		Modified: d.Modified,
+	}
+	if d.CreatedBy != nil {
+		doc.CreatedBy = d.CreatedBy.Id
+	}
+	return doc
+}
+
+// apiDocumentDetail is a document with everything needed to score it.
+type apiDocumentDetail struct {
+	apiDocument
+	Categories []string
+	Metadata   map[string]string
+	Content    string
+}
+
+func newAPIDocumentDetail(d *document) apiDocumentDetail {
+	return apiDocumentDetail{
+		apiDocument: newAPIDocument(d),
+		Categories:  d.Categories,
+		Metadata:    d.Metadata,
+		Content:     d.Content,
+	}
+}
+
+type apiScore struct {
+	Word      int
+	Scheme    string
+	Category  string
+	CreatedBy string
+	Created   time.Time
+}
+
+func newAPIScore(s *score) apiScore {
+	return apiScore{
+		Word:      s.Word,
+		Scheme:    s.Category,
+		Category:  s.Name,
+		CreatedBy: s.CreatedBy.Id,
+		Created:   s.Created,
+	}
+}
+
+// apiListedDocument is a document in a list, along with its progress.
+type apiListedDocument struct {
+	apiDocument
+	Units  int
+	Scored int
+	Status string
+}
+
+// apiDocuments lists a page of the documents in a project.
+func apiDocuments(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts documentListOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+	docs, page := proj.listDocuments(opts)
+	list := make([]apiListedDocument, len(docs))
+	for i, ld := range docs {
+		list[i] = apiListedDocument{
+			apiDocument: newAPIDocument(ld.document),
+			Units:       ld.Units,
+			Scored:      ld.Scored,
+			Status:      ld.Status(),
+		}
+	}
+	w.apiPage(page)
+	w.apiJSON(200, list)
+}
+
+type apiFormDocument struct {
+	Display string
+
+	// Recorded is formatted as YYYY-MM-DD.
+	Recorded string
+
+	// Categories are the names of the scoring schemes the document is
+	// scored with.
+	Categories []string
+	Content    string
+	Metadata   map[string]string
+}
+
+func apiAddDocument(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var form apiFormDocument
+	w.decodeJSON(&form)
+
+	recorded, err := time.Parse(recordedFmt, form.Recorded)
+	if err != nil {
+		panic(ue("Could not parse date **%s**. Please use the format "+
+			"YYYY-MM-DD.", form.Recorded))
+	}
+	for key := range form.Metadata {
+		if !reMetadataKey.MatchString(key) {
+			panic(ue("Metadata key **%s** must start with a letter and "+
+				"contain only letters, numbers and underscores.", key))
+		}
+	}
+	d, err := newDocument(w.user, proj, form.Display, recorded,
+		form.Categories, form.Content)
+	assert(err)
+	meta := form.Metadata
+	if meta == nil {
+		meta = make(map[string]string)
+	}
+
+	// The document and its metadata are added together, so that a failure
+	// doesn't leave the document without its metadata.
+	csql.Tx(db, func(tx *sql.Tx) {
+		d.insert(tx)
+		d.saveMetadata(tx, meta)
+	})
+	proj.triggerHooks(hookDocumentAdded, w.user, d)
+	w.apiJSON(http.StatusCreated, newAPIDocumentDetail(d))
+}
+
+func apiGetDocument(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	w.apiJSON(200, newAPIDocumentDetail(d))
+}
+
+// apiTokens lists the words of a document. Scores refer to words by their
+// index.
+func apiTokens(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	w.apiJSON(200, d.Tokens())
+}
+
+func apiScores(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	scores := d.scores()
+	list := make([]apiScore, len(scores))
+	for i, s := range scores {
+		list[i] = newAPIScore(s)
+	}
+	w.apiJSON(200, list)
+}
+
+type apiFormScore struct {
+	// Category is the key of a category in the scheme given in the URL.
+	Category string
+}
+
+// apiScoreParams returns the document, word and scheme of a score from the
+// URL.
+func apiScoreParams(w *web) (*document, int, string) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	word, err := strconv.Atoi(w.params["word"])
+	if err != nil {
+		panic(ue("Word **%s** is not a number.", w.params["word"]))
+	}
+	return d, word, w.params["scheme"]
+}
+
+// apiSetScore sets the score of a word in a scheme, replacing any score it
+// already had.
+func apiSetScore(w *web) {
+	d, word, scheme := apiScoreParams(w)
+	var form apiFormScore
+	w.decodeJSON(&form)
+	s, err := d.setScore(w.user, word, scheme, form.Category)
+	assert(err)
+	w.apiJSON(200, newAPIScore(s))
+}
+
+func apiDeleteScore(w *web) {
+	d, word, scheme := apiScoreParams(w)
+	d.deleteScore(w.user, word, scheme)
+	w.apiNoContent()
+}
+
+// apiExportScore is a row of the scores export.
+type apiExportScore struct {
+	Document string
+	Recorded string
+	Word     int
+	Surface  string
+	Scheme   string
+	Category string
+	Value    string
+	Coder    string
+	Created  time.Time
+}
+
+// apiExportScores returns every score in a project that passes the filter
+// given in the query string. The scores are streamed, since a project can
+// have far more of them than fit in memory.
+func apiExportScores(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+	w.apiJSONArray(func(add func(v interface{})) {
+		proj.eachScore(filter, func(row scoreRow) {
+			add(apiExportScore{
+				Document: row.Document.Name,
+				Recorded: row.Document.RecordedKey(),
+				Word:     row.Word,
+				Surface:  row.Surface,
+				Scheme:   row.Scheme,
+				Category: row.Category,
+				Value:    row.Value(),
+				Coder:    row.Coder,
+				Created:  row.Created,
+			})
+		})
+	})
+}
+
+// apiExportSummary is a row of the per-document summary export. The
+// abstraction index is null when no word is scored.
+type apiExportSummary struct {
+	Document      string
+	Recorded      string
+	Scheme        string
+	SchemeVersion string
+	Layer         string
+	Tokens        int
+	Scored        int
+	Counts        map[string]int
+	Proportions   map[string]float64
+	Abstraction   *float64
+	Metadata      map[string]string
+}
+
+// apiExportSummaries returns the per-document summaries of a project for
+// the scheme and layer given in the query string.
+func apiExportSummaries(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts summaryOptions
+	w.decodeQuery(&opts)
+	scheme := opts.scheme()
+	meta := proj.metadata()
+
+	sums := proj.summaries(opts)
+	rows := make([]apiExportSummary, len(sums))
+	for i, sum := range sums {
+		row := apiExportSummary{
+			Document:      sum.Document.Name,
+			Recorded:      sum.Document.RecordedKey(),
+			Scheme:        opts.Scheme,
+			SchemeVersion: scheme.VersionString(),
+			Layer:         opts.Layer(),
+			Tokens:        sum.Tokens,
+			Scored:        sum.Scored(),
+			Counts:        make(map[string]int),
+			Proportions:   make(map[string]float64),
+			Metadata:      meta.ByDocument[sum.Document.Key()],
+		}
+		for _, cat := range scheme.Ordered() {
+			row.Counts[cat.Key] = sum.Counts[cat.Key]
+			row.Proportions[cat.Key] = sum.Proportion(cat.Key)
+		}
+		if abs, ok := sum.Abstraction(); ok {
+			row.Abstraction = &abs
+		}
+		if row.Metadata == nil {
+			row.Metadata = make(map[string]string)
+		}
+		rows[i] = row
+	}
+	w.apiJSON(200, rows)
+}
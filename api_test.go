@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// The streamed array must be exactly what apiJSON writes for a slice.
+func TestAPIJSONArray(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		items := make([]m, 0)
+		for i := 0; i < n; i++ {
+			items = append(items, m{"Word": i, "Words": []int{i, i + 1}})
+		}
+		rec := httptest.NewRecorder()
+		w := &web{w: rec, r: httptest.NewRequest("GET", "/", nil)}
+		w.apiJSONArray(func(add func(v interface{})) {
+			for _, item := range items {
+				add(item)
+			}
+		})
+
+		want, err := json.MarshalIndent(
+			m{"status": "success", "content": items}, "", "  ")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rec.Body.String(); got != string(want) {
+			t.Errorf("%d items: got\n%s\nwant\n%s", n, got, want)
+		}
+	}
+}
+
+// An error before the first element leaves the response untouched, so
+// that the usual error response can be written.
+func TestAPIJSONArrayEarlyError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &web{w: rec, r: httptest.NewRequest("GET", "/", nil)}
+	failure := errors.New("query failed")
+	func() {
+		defer func() {
+			if r := recover(); r != failure {
+				t.Errorf("recovered %v, want %v", r, failure)
+			}
+		}()
+		w.apiJSONArray(func(add func(v interface{})) {
+			panic(failure)
+		})
+	}()
+	if rec.Body.Len() > 0 || len(rec.Header()) > 0 {
+		t.Errorf("response was started: %v %q", rec.Header(), rec.Body)
+	}
+}
+
+// An error after the response has started closes the connection, so the
+// client can't mistake the partial body for a whole one.
+func TestAPIJSONArrayLateError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(rw http.ResponseWriter, r *http.Request) {
+			w := &web{w: rw, r: r}
+			w.apiJSONArray(func(add func(v interface{})) {
+				add(m{"Word": 0})
+				panic(errors.New("connection lost"))
+			})
+		}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if body, err := ioutil.ReadAll(resp.Body); err == nil {
+		t.Errorf("read a whole response after an error: %q", body)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// Scopes of API tokens. A read-only token can only be used for GET and HEAD
+// requests.
+const (
+	tokenRead  = "read"
+	tokenWrite = "write"
+)
+
+// tokenPrefix starts every API token, so that they are easy to recognize
+// (for example, when they are committed to a repository by mistake).
+const tokenPrefix = "lcm_"
+
+// apiToken is a named credential that lets scripts use the API (or any
+// other page) on behalf of a user. Only a hash of the secret is stored, so
+// it is shown to the user once when the token is created.
+type apiToken struct {
+	Id       int
+	UserId   string
+	Name     string
+	Scope    string
+	Created  time.Time
+	Expires  *time.Time
+	LastUsed *time.Time
+}
+
+// Expired reports whether the token can no longer be used.
+func (tok *apiToken) Expired() bool {
+	return tok.Expires != nil && !time.Now().Before(*tok.Expires)
+}
+
+// hashToken returns the hash of a secret as it is stored. The secrets are
+// long random strings, so a fast hash doesn't make them any easier to guess.
+func hashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newTokenSecret() string {
+	bs := make([]byte, 32)
+	_, err := rand.Read(bs)
+	assert(err)
+	return tokenPrefix + hex.EncodeToString(bs)
+}
+
+// insertAPIToken creates a token for the user and returns it along with its
+// secret. `expires` may be nil.
+func insertAPIToken(
+	user *lcmUser,
+	name, scope string,
+	expires *time.Time,
+) (*apiToken, string, error) {
+	tok := &apiToken{
+		UserId:  user.Id,
+		Name:    strings.TrimSpace(name),
+		Scope:   scope,
+		Created: time.Now().UTC(),
+		Expires: expires,
+	}
+	if err := tok.validate(); err != nil {
+		return nil, "", err
+	}
+	secret := newTokenSecret()
+	err := db.QueryRow(`
+		INSERT INTO api_token
+			(userid, name, hash, scope, created, expires)
+		VALUES
+			($1, $2, $3, $4, $5, $6)
+		RETURNING id
+		`, tok.UserId, tok.Name, hashToken(secret), tok.Scope, tok.Created,
+		tok.Expires).Scan(&tok.Id)
+	assert(err)
+	return tok, secret, nil
+}
+
+func (tok *apiToken) validate() error {
+	if len(tok.Name) < 1 {
+		return ue("API tokens must have a name.")
+	}
+	if len(tok.Name) >= 100 {
+		return ue("API token names must be fewer than 100 characters.")
+	}
+	if tok.Scope != tokenRead && tok.Scope != tokenWrite {
+		return ue("**%s** is not a valid scope for an API token.", tok.Scope)
+	}
+	if tok.Expired() {
+		return ue("API tokens must expire in the future.")
+	}
+	return nil
+}
+
+// findAPIToken returns the token with the given secret, or nil if there is
+// no such token or it has expired. The time it was last used is updated.
+func findAPIToken(secret string) *apiToken {
+	tok := &apiToken{}
+	err := db.QueryRow(`
+		UPDATE api_token
+		SET last_used = $2
+		WHERE hash = $1
+		RETURNING id, userid, name, scope, created, expires
+		`, hashToken(secret), time.Now().UTC()).Scan(&tok.Id, &tok.UserId,
+		&tok.Name, &tok.Scope, &tok.Created, &tok.Expires)
+	if err != nil || tok.Expired() {
+		return nil
+	}
+	return tok
+}
+
+// bearerToken returns the secret given in the Authorization header of a
+// request, or an empty string if there isn't one.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(auth[7:])
+}
+
+// allows reports whether the token may be used for a request with the given
+// method.
+func (tok *apiToken) allows(method string) bool {
+	return tok.Scope == tokenWrite || method == "GET" || method == "HEAD"
+}
+
+// apiTokens returns the tokens of the user, newest first.
+func (user *lcmUser) apiTokens() []*apiToken {
+	toks := make([]*apiToken, 0)
+	rows := csql.Query(db, `
+		SELECT
+			id, name, scope, created, expires, last_used
+		FROM
+			api_token
+		WHERE
+			userid = $1
+		ORDER BY
+			created DESC
+	`, user.Id)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		tok := &apiToken{UserId: user.Id}
+		csql.Scan(row, &tok.Id, &tok.Name, &tok.Scope, &tok.Created,
+			&tok.Expires, &tok.LastUsed)
+		toks = append(toks, tok)
+	})
+	return toks
+}
+
+type formAPIToken struct {
+	Name  string
+	Scope string
+
+	// Expires is a date formatted as YYYY-MM-DD, or empty for a token that
+	// doesn't expire. Tokens expire at the start of the day (in UTC).
+	Expires string
+}
+
+// apiTokenSettings lists the API tokens of the user and creates new ones.
+// The secret of a new token is shown only once.
+//
+// Tokens can only be managed when logged in. Otherwise a leaked token could
+// be used to make more of them.
+func apiTokenSettings(w *web) {
+	if w.token != nil {
+		panic(ue("API tokens cannot be managed with an API token."))
+	}
+	show := func(form formAPIToken, tok *apiToken, secret, msg string) {
+		w.html("settings-tokens", m{
+			"Title": "API tokens",
+			"Nav": w.mkNav(
+				nav{"Projects", w.routes.URLFor("project-list")},
+				nav{"API tokens", ""},
+			),
+			"Tokens":   w.user.apiTokens(),
+			"Form":     form,
+			"NewToken": tok,
+			"Secret":   secret,
+			"Message":  formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(formAPIToken{Scope: tokenRead}, nil, "", "")
+	} else if w.r.Method == "POST" {
+		var form formAPIToken
+		w.decode(&form)
+
+		var expires *time.Time
+		if len(form.Expires) > 0 {
+			t, err := time.Parse(recordedFmt, form.Expires)
+			if err != nil {
+				show(form, nil, "", fmt.Sprintf("Could not parse date "+
+					"**%s**. Please use the format YYYY-MM-DD.", form.Expires))
+				return
+			}
+			expires = &t
+		}
+		tok, secret, err := insertAPIToken(w.user, form.Name, form.Scope,
+			expires)
+		if err != nil {
+			show(form, nil, "", err.Error())
+			return
+		}
+		show(formAPIToken{Scope: tokenRead}, tok, secret, "")
+	} else {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+}
+
+// revokeAPIToken deletes one of the user's tokens. It stops working
+// immediately.
+func revokeAPIToken(w *web) {
+	if w.token != nil {
+		panic(ue("API tokens cannot be managed with an API token."))
+	}
+	id, err := strconv.Atoi(w.params["id"])
+	if err != nil {
+		panic(ue("Invalid API token **%s**.", w.params["id"]))
+	}
+	csql.Exec(db, `
+		DELETE FROM api_token
+		WHERE id = $1 AND userid = $2
+		`, id, w.user.Id)
+	http.Redirect(w.w, w.r, w.routes.URLFor("settings-tokens"), 302)
+}
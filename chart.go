@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	html "html/template"
+	"math"
+	"sort"
+	"time"
+)
+
+// This file renders simple line charts over time as SVG, so that charts can
+// be shown (and printed) without any client side code.
+
+// Dimensions of a chart in pixels. The plot area is inset by the margins,
+// which leave room for the axes on the left and bottom and for the legend on
+// the right.
+const (
+	chartWidth        = 760
+	chartHeight       = 280
+	chartMarginTop    = 30
+	chartMarginRight  = 170
+	chartMarginBottom = 40
+	chartMarginLeft   = 50
+)
+
+// lineChart is a chart of one or more series of values over time.
+type lineChart struct {
+	Title string
+
+	// YMin and YMax are the range of the Y axis. Values outside of it are
+	// clamped.
+	YMin, YMax float64
+
+	// XLabel formats the times on the X axis.
+	XLabel func(t time.Time) string
+
+	Series []chartSeries
+}
+
+type chartSeries struct {
+	Name   string
+	Color  string
+	Points []chartPoint
+}
+
+type chartPoint struct {
+	X time.Time
+	Y float64
+
+	// Label is shown when hovering over the point.
+	Label string
+}
+
+// chartColor returns the color for the i'th series of a chart.
+func chartColor(i int) string {
+	return chartPalette[i%len(chartPalette)]
+}
+
+// chartPalette is used for lines, so its colors are darker than
+// categoryPalette.
+var chartPalette = []string{
+	"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd",
+	"#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf",
+}
+
+// SVG renders the chart.
+func (c lineChart) SVG() html.HTML {
+	buf := new(bytes.Buffer)
+	pr := func(format string, v ...interface{}) {
+		fmt.Fprintf(buf, format, v...)
+	}
+	esc := html.HTMLEscapeString
+	plotW := float64(chartWidth - chartMarginLeft - chartMarginRight)
+	plotH := float64(chartHeight - chartMarginTop - chartMarginBottom)
+
+	xs := c.times()
+	xpos := func(t time.Time) float64 {
+		if len(xs) <= 1 {
+			return chartMarginLeft + plotW/2
+		}
+		first, last := xs[0].Unix(), xs[len(xs)-1].Unix()
+		frac := float64(t.Unix()-first) / float64(last-first)
+		return chartMarginLeft + frac*plotW
+	}
+	ypos := func(y float64) float64 {
+		y = math.Max(c.YMin, math.Min(c.YMax, y))
+		frac := (y - c.YMin) / (c.YMax - c.YMin)
+		return chartMarginTop + (1-frac)*plotH
+	}
+
+	pr(`<svg xmlns="http://www.w3.org/2000/svg" class="chart" `+
+		`width="%d" height="%d" viewBox="0 0 %d %d">`,
+		chartWidth, chartHeight, chartWidth, chartHeight)
+	pr(`<text class="chart-title" x="%d" y="18">%s</text>`,
+		chartMarginLeft, esc(c.Title))
+
+	// Y axis with five ticks and grid lines.
+	for i := 0; i <= 4; i++ {
+		y := c.YMin + float64(i)*(c.YMax-c.YMin)/4
+		pr(`<line class="chart-grid" x1="%d" x2="%.1f" y1="%.1f" y2="%.1f"/>`,
+			chartMarginLeft, chartMarginLeft+plotW, ypos(y), ypos(y))
+		pr(`<text class="chart-tick" x="%d" y="%.1f" `+
+			`text-anchor="end">%s</text>`,
+			chartMarginLeft-6, ypos(y)+4, formatTick(y))
+	}
+
+	// X axis, labeling at most eight of the times.
+	base := chartMarginTop + plotH
+	pr(`<line class="chart-axis" x1="%d" x2="%.1f" y1="%.1f" y2="%.1f"/>`,
+		chartMarginLeft, chartMarginLeft+plotW, base, base)
+	step := (len(xs) + 7) / 8
+	for i := 0; i < len(xs); i += step {
+		x := xpos(xs[i])
+		pr(`<line class="chart-axis" x1="%.1f" x2="%.1f" y1="%.1f" y2="%.1f"/>`,
+			x, x, base, base+4)
+		pr(`<text class="chart-tick" x="%.1f" y="%.1f" `+
+			`text-anchor="middle">%s</text>`,
+			x, base+18, esc(c.XLabel(xs[i])))
+	}
+
+	for i, s := range c.Series {
+		pr(`<g class="chart-series">`)
+		if len(s.Points) > 1 {
+			pr(`<polyline fill="none" stroke="%s" stroke-width="2" points="`,
+				s.Color)
+			for _, p := range s.Points {
+				pr("%.1f,%.1f ", xpos(p.X), ypos(p.Y))
+			}
+			pr(`"/>`)
+		}
+		for _, p := range s.Points {
+			pr(`<circle cx="%.1f" cy="%.1f" r="3" fill="%s">`+
+				`<title>%s</title></circle>`,
+				xpos(p.X), ypos(p.Y), s.Color, esc(p.Label))
+		}
+
+		ly := chartMarginTop + 16*i
+		lx := chartWidth - chartMarginRight + 15
+		pr(`<rect x="%d" y="%d" width="12" height="12" fill="%s"/>`,
+			lx, ly, s.Color)
+		pr(`<text class="chart-legend" x="%d" y="%d">%s</text>`,
+			lx+18, ly+10, esc(truncateRunes(s.Name, 22)))
+		pr(`</g>`)
+	}
+	pr(`</svg>`)
+	return html.HTML(buf.String())
+}
+
+// times returns every distinct time in the chart in order.
+func (c lineChart) times() []time.Time {
+	seen := make(map[int64]bool)
+	xs := make([]time.Time, 0)
+	for _, s := range c.Series {
+		for _, p := range s.Points {
+			if !seen[p.X.Unix()] {
+				seen[p.X.Unix()] = true
+				xs = append(xs, p.X)
+			}
+		}
+	}
+	sort.Sort(timeSlice(xs))
+	return xs
+}
+
+type timeSlice []time.Time
+
+func (ts timeSlice) Len() int           { return len(ts) }
+func (ts timeSlice) Less(i, j int) bool { return ts[i].Before(ts[j]) }
+func (ts timeSlice) Swap(i, j int)      { ts[i], ts[j] = ts[j], ts[i] }
+
+func formatTick(f float64) string {
+	return fmt.Sprintf("%.4g", f)
+}
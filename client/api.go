@@ -0,0 +1,528 @@
+package client
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The types below mirror the JSON representations used by the API. Dates
+// of documents (Recorded) are formatted as YYYY-MM-DD.
+
+type User struct {
+	Id   string
+	Name string
+}
+
+type Project struct {
+	Owner         string
+	Name          string
+	Display       string
+	Added         time.Time
+	Documents     int
+	Collaborators []User
+}
+
+type Scheme struct {
+	Name       string
+	Version    string
+	Categories []Category
+}
+
+type Category struct {
+	Key   string
+	Name  string
+	Value int
+}
+
+// Document is a document in a project. Categories, Metadata and Content are
+// only filled in by Client.Document and Client.AddDocument.
+type Document struct {
+	Name      string
+	Display   string
+	Recorded  string
+	CreatedBy string
+	Created   time.Time
+	Modified  time.Time
+
+	Categories []string          `json:",omitempty"`
+	Metadata   map[string]string `json:",omitempty"`
+	Content    string            `json:",omitempty"`
+
+	// Units, Scored and Status are only filled in by Client.ListDocuments
+	// and Client.Documents. A unit is a word in one of the schemes of the
+	// document, and Status is "unscored", "partial" or "complete".
+	Units  int    `json:",omitempty"`
+	Scored int    `json:",omitempty"`
+	Status string `json:",omitempty"`
+}
+
+// NewDocument is a document to add to a project.
+type NewDocument struct {
+	Display  string
+	Recorded string
+
+	// Categories are the names of the scoring schemes the document is
+	// scored with.
+	Categories []string
+	Content    string
+	Metadata   map[string]string
+}
+
+// Token is a word of a document. Start and End are byte offsets into the
+// content of the document.
+type Token struct {
+	Index int
+	Text  string
+	Start int
+	End   int
+}
+
+type Score struct {
+	Word      int
+	Scheme    string
+	Category  string
+	CreatedBy string
+	Created   time.Time
+}
+
+type ExportScore struct {
+	Document string
+	Recorded string
+	Word     int
+	Surface  string
+	Scheme   string
+	Category string
+	Value    string
+	Coder    string
+	Created  time.Time
+}
+
+type Summary struct {
+	Document      string
+	Recorded      string
+	Scheme        string
+	SchemeVersion string
+	Layer         string
+	Tokens        int
+	Scored        int
+	Counts        map[string]int
+	Proportions   map[string]float64
+	Abstraction   *float64
+	Metadata      map[string]string
+}
+
+// ScoreFilter restricts the scores that are exported. Empty fields don't
+// restrict anything.
+type ScoreFilter struct {
+	// Documents are document keys (the name and recording date joined by
+	// a slash).
+	Documents []string
+
+	// Coders are the ids of the users who created the scores.
+	Coders []string
+
+	Schemes    []string
+	Categories []string
+}
+
+// Values returns the filter as a query string.
+func (f ScoreFilter) Values() url.Values {
+	q := url.Values{}
+	q["Documents"] = f.Documents
+	q["Coders"] = f.Coders
+	q["Schemes"] = f.Schemes
+	q["Categories"] = f.Categories
+	for k, vs := range q {
+		if len(vs) == 0 {
+			delete(q, k)
+		}
+	}
+	return q
+}
+
+// SummaryOptions selects the scores that are summarized.
+type SummaryOptions struct {
+	// Scheme is the name of a scoring scheme. Only documents scored with
+	// this scheme are summarized.
+	Scheme string
+
+	// Coder restricts the scores to those created by the user with this id.
+	// When empty, every score is used (the consensus layer).
+	Coder string
+}
+
+// Values returns the options as a query string.
+func (opts SummaryOptions) Values() url.Values {
+	q := url.Values{}
+	setValue(q, "Scheme", opts.Scheme)
+	setValue(q, "Coder", opts.Coder)
+	return q
+}
+
+// ReportOptions selects what is shown in the PDF report of a document.
+type ReportOptions struct {
+	Scheme string
+	Coder  string
+
+	// Style is "color" or "underline".
+	Style string
+}
+
+// Values returns the options as a query string.
+func (opts ReportOptions) Values() url.Values {
+	q := url.Values{}
+	setValue(q, "Scheme", opts.Scheme)
+	setValue(q, "Coder", opts.Coder)
+	setValue(q, "Style", opts.Style)
+	return q
+}
+
+func setValue(q url.Values, key, value string) {
+	if len(value) > 0 {
+		q.Set(key, value)
+	}
+}
+
+// Page describes one page of a list. Pages are numbered from 1.
+type Page struct {
+	Page    int
+	PerPage int
+	Total   int
+}
+
+// Last reports whether this is the last page of the list.
+func (p *Page) Last() bool {
+	return p.PerPage == 0 || p.Page*p.PerPage >= p.Total
+}
+
+// pageFromHeader reads the page of a list from the headers of a response.
+// A response without them has the whole list.
+func pageFromHeader(h http.Header, n int) *Page {
+	atoi := func(key string) int {
+		v, _ := strconv.Atoi(h.Get(key))
+		return v
+	}
+	p := &Page{
+		Page:    atoi("X-Page"),
+		PerPage: atoi("X-Per-Page"),
+		Total:   atoi("X-Total-Count"),
+	}
+	if p.Page == 0 {
+		return &Page{Page: 1, PerPage: n, Total: n}
+	}
+	return p
+}
+
+// MaxPerPage is the largest page the server returns.
+const MaxPerPage = 500
+
+// ProjectListOptions sorts and paginates a list of projects. Zero values
+// use the server's defaults.
+type ProjectListOptions struct {
+	// Sort is "name", "added", "documents" or "modified", prefixed by "-"
+	// to sort in descending order.
+	Sort string
+
+	Page    int
+	PerPage int
+}
+
+// Values returns the options as a query string.
+func (opts ProjectListOptions) Values() url.Values {
+	q := url.Values{}
+	setValue(q, "Sort", opts.Sort)
+	setInt(q, "Page", opts.Page)
+	setInt(q, "PerPage", opts.PerPage)
+	return q
+}
+
+// DocumentListOptions selects, sorts and paginates a list of documents.
+// Zero values use the server's defaults.
+type DocumentListOptions struct {
+	// Sort is "name", "recorded", "progress" or "modified", prefixed by "-"
+	// to sort in descending order.
+	Sort string
+
+	// Status is "unscored", "partial" or "complete".
+	Status string
+
+	// Metadata are filters of the form KEY=VALUE.
+	Metadata []string
+
+	Page    int
+	PerPage int
+}
+
+// Values returns the options as a query string.
+func (opts DocumentListOptions) Values() url.Values {
+	q := url.Values{}
+	setValue(q, "Sort", opts.Sort)
+	setValue(q, "Status", opts.Status)
+	if len(opts.Metadata) > 0 {
+		q["Metadata"] = opts.Metadata
+	}
+	setInt(q, "Page", opts.Page)
+	setInt(q, "PerPage", opts.PerPage)
+	return q
+}
+
+func setInt(q url.Values, key string, value int) {
+	if value > 0 {
+		q.Set(key, strconv.Itoa(value))
+	}
+}
+
+// Schemes returns the scoring schemes and their categories.
+func (c *Client) Schemes() ([]Scheme, error) {
+	var schemes []Scheme
+	err := c.api("GET", "/schemes", nil, nil, &schemes)
+	return schemes, err
+}
+
+// ListProjects returns a page of the projects owned by the user of the
+// token.
+func (c *Client) ListProjects(
+	opts ProjectListOptions,
+) ([]Project, *Page, error) {
+	var projs []Project
+	h, err := c.apiHeader("GET", "/projects", opts.Values(), nil, &projs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return projs, pageFromHeader(h, len(projs)), nil
+}
+
+// Projects returns every project owned by the user of the token, sorted by
+// name.
+func (c *Client) Projects() ([]Project, error) {
+	all := make([]Project, 0)
+	opts := ProjectListOptions{Page: 1, PerPage: MaxPerPage}
+	for ; ; opts.Page++ {
+		projs, page, err := c.ListProjects(opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, projs...)
+		if page.Last() || len(projs) == 0 {
+			return all, nil
+		}
+	}
+}
+
+// CreateProject creates a project with the given display name.
+func (c *Client) CreateProject(display string) (*Project, error) {
+	var proj Project
+	body := map[string]string{"Display": display}
+	if err := c.api("POST", "/projects", nil, body, &proj); err != nil {
+		return nil, err
+	}
+	return &proj, nil
+}
+
+func (c *Client) Project(owner, project string) (*Project, error) {
+	var proj Project
+	err := c.api("GET", path("projects", owner, project), nil, nil, &proj)
+	if err != nil {
+		return nil, err
+	}
+	return &proj, nil
+}
+
+// DeleteProject deletes a project with all of its documents and scores.
+func (c *Client) DeleteProject(owner, project string) error {
+	return c.api("DELETE", path("projects", owner, project), nil, nil, nil)
+}
+
+func (c *Client) Collaborators(owner, project string) ([]User, error) {
+	var users []User
+	err := c.api("GET", path("projects", owner, project, "collaborators"),
+		nil, nil, &users)
+	return users, err
+}
+
+// SetCollaborators replaces the collaborators of a project with the users
+// with the given ids.
+func (c *Client) SetCollaborators(
+	owner, project string,
+	userids []string,
+) ([]User, error) {
+	var users []User
+	body := map[string][]string{"Collaborators": userids}
+	err := c.api("PUT", path("projects", owner, project, "collaborators"),
+		nil, body, &users)
+	return users, err
+}
+
+// ListDocuments returns a page of the documents in a project that match the
+// options.
+func (c *Client) ListDocuments(
+	owner, project string,
+	opts DocumentListOptions,
+) ([]Document, *Page, error) {
+	var docs []Document
+	h, err := c.apiHeader("GET", path("projects", owner, project, "documents"),
+		opts.Values(), nil, &docs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return docs, pageFromHeader(h, len(docs)), nil
+}
+
+// Documents returns every document in a project, sorted by name.
+func (c *Client) Documents(owner, project string) ([]Document, error) {
+	all := make([]Document, 0)
+	opts := DocumentListOptions{Page: 1, PerPage: MaxPerPage}
+	for ; ; opts.Page++ {
+		docs, page, err := c.ListDocuments(owner, project, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, docs...)
+		if page.Last() || len(docs) == 0 {
+			return all, nil
+		}
+	}
+}
+
+func (c *Client) AddDocument(
+	owner, project string,
+	doc NewDocument,
+) (*Document, error) {
+	var added Document
+	err := c.api("POST", path("projects", owner, project, "documents"),
+		nil, doc, &added)
+	if err != nil {
+		return nil, err
+	}
+	return &added, nil
+}
+
+// Document returns a document with its content, schemes and metadata.
+func (c *Client) Document(
+	owner, project, name, recorded string,
+) (*Document, error) {
+	var doc Document
+	err := c.api("GET", docPath(owner, project, name, recorded), nil, nil,
+		&doc)
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Tokens returns the words of a document.
+func (c *Client) Tokens(
+	owner, project, name, recorded string,
+) ([]Token, error) {
+	var toks []Token
+	err := c.api("GET", docPath(owner, project, name, recorded)+"/tokens",
+		nil, nil, &toks)
+	return toks, err
+}
+
+// Scores returns the scores of a document.
+func (c *Client) Scores(
+	owner, project, name, recorded string,
+) ([]Score, error) {
+	var scores []Score
+	err := c.api("GET", docPath(owner, project, name, recorded)+"/scores",
+		nil, nil, &scores)
+	return scores, err
+}
+
+// SetScore scores the word with the given index in a scheme with the
+// category with the given key.
+func (c *Client) SetScore(
+	owner, project, name, recorded string,
+	scheme string, word int, category string,
+) (*Score, error) {
+	var score Score
+	body := map[string]string{"Category": category}
+	err := c.api("PUT", scorePath(owner, project, name, recorded, scheme,
+		word), nil, body, &score)
+	if err != nil {
+		return nil, err
+	}
+	return &score, nil
+}
+
+// DeleteScore removes the score of a word in a scheme.
+func (c *Client) DeleteScore(
+	owner, project, name, recorded string,
+	scheme string, word int,
+) error {
+	return c.api("DELETE", scorePath(owner, project, name, recorded, scheme,
+		word), nil, nil, nil)
+}
+
+// ExportScores returns the scores of a project that match the filter.
+func (c *Client) ExportScores(
+	owner, project string,
+	filter ScoreFilter,
+) ([]ExportScore, error) {
+	var scores []ExportScore
+	err := c.api("GET", path("projects", owner, project, "export", "scores"),
+		filter.Values(), nil, &scores)
+	return scores, err
+}
+
+// Summaries returns a summary of each document in a project.
+func (c *Client) Summaries(
+	owner, project string,
+	opts SummaryOptions,
+) ([]Summary, error) {
+	var sums []Summary
+	err := c.api("GET", path("projects", owner, project, "export", "summary"),
+		opts.Values(), nil, &sums)
+	return sums, err
+}
+
+// ExportFiles are the files that can be downloaded with Client.Export. The
+// summaries take SummaryOptions and the scores take a ScoreFilter.
+var ExportFiles = []string{
+	"scores.csv",
+	"scores.sav",
+	"scores.parquet",
+	"summary.csv",
+	"summary.tsv",
+	"summary.sav",
+	"summary.parquet",
+	"workbook.xlsx",
+	"conllu.zip",
+	"brat.zip",
+	"annotations.jsonld",
+}
+
+// Export downloads one of ExportFiles for a project and writes it to `w`.
+func (c *Client) Export(
+	owner, project, file string,
+	query url.Values,
+	w io.Writer,
+) error {
+	return c.download(path(owner, project, "export", file), query, w)
+}
+
+// Report downloads the PDF report of a document and writes it to `w`.
+func (c *Client) Report(
+	owner, project, name, recorded string,
+	opts ReportOptions,
+	w io.Writer,
+) error {
+	return c.download(path(owner, project, name, recorded, "report.pdf"),
+		opts.Values(), w)
+}
+
+func docPath(owner, project, name, recorded string) string {
+	return path("projects", owner, project, "documents", name, recorded)
+}
+
+func scorePath(
+	owner, project, name, recorded, scheme string,
+	word int,
+) string {
+	return docPath(owner, project, name, recorded) +
+		path("scores", scheme, strconv.Itoa(word))
+}
@@ -0,0 +1,235 @@
+// Package client is a Go client for lcmweb. It uses the JSON API (see
+// api.go in lcmweb) for projects, documents and scores, and downloads
+// exports and reports from the same URLs as the web pages.
+//
+// Requests are authenticated with a personal API token, which can be
+// created on the "API tokens" page of lcmweb. Errors reported by the server
+// are returned as *AuthError, *UserError or *ServerError.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// APIPrefix is the path of the version of the API this package uses.
+const APIPrefix = "/api/v1"
+
+// Client makes requests to an lcmweb server on behalf of the owner of a
+// token.
+type Client struct {
+	// BaseURL is the URL of the server, such as `https://lcm.example.com`.
+	BaseURL string
+
+	// Token is sent as a bearer credential with every request.
+	Token string
+
+	// HTTP is used to make requests.
+	HTTP *http.Client
+}
+
+// New returns a client for the server at `baseURL` using the given API
+// token.
+func New(baseURL, token string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		Token:   token,
+		HTTP:    http.DefaultClient,
+	}
+}
+
+// AuthError means the server didn't accept the token (the status was
+// "noauth"). The token may be wrong, expired or revoked, or it may be
+// read-only.
+type AuthError struct {
+	Message string
+}
+
+func (e *AuthError) Error() string {
+	return "lcmweb: not authenticated: " + e.Message
+}
+
+// UserError means the request couldn't be satisfied (the status was
+// "fail"), for example because a project doesn't exist or a score is
+// invalid.
+type UserError struct {
+	Message string
+}
+
+func (e *UserError) Error() string {
+	return "lcmweb: " + e.Message
+}
+
+// ServerError means the server failed (the status was "error") or its
+// response couldn't be understood.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("lcmweb: server error (%d): %s",
+		e.StatusCode, e.Message)
+}
+
+// envelope is the form of every JSON response from lcmweb.
+type envelope struct {
+	Status  string          `json:"status"`
+	Content json.RawMessage `json:"content"`
+	Message string          `json:"message"`
+}
+
+var reTag = regexp.MustCompile(`<[^>]*>`)
+
+// plainMessage removes the HTML from messages, which the server formats
+// for web pages.
+func plainMessage(s string) string {
+	return html.UnescapeString(reTag.ReplaceAllString(s, ""))
+}
+
+// decodeResponse decodes the envelope of a response, storing its content in
+// `v` (which may be nil) or returning the error it describes.
+func decodeResponse(resp *http.Response, v interface{}) error {
+	var env envelope
+	if err := json.NewDecoder(resp.Body).Decode(&env); err != nil {
+		return &ServerError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("invalid response: %s", err),
+		}
+	}
+	msg := plainMessage(env.Message)
+	switch env.Status {
+	case "success":
+		if v == nil || len(env.Content) == 0 {
+			return nil
+		}
+		if err := json.Unmarshal(env.Content, v); err != nil {
+			return &ServerError{
+				StatusCode: resp.StatusCode,
+				Message:    fmt.Sprintf("invalid content: %s", err),
+			}
+		}
+		return nil
+	case "noauth":
+		return &AuthError{Message: msg}
+	case "fail":
+		return &UserError{Message: msg}
+	case "error":
+		return &ServerError{StatusCode: resp.StatusCode, Message: msg}
+	}
+	return &ServerError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("unknown status %q", env.Status),
+	}
+}
+
+// path joins escaped path segments.
+func path(segments ...string) string {
+	escaped := make([]string, len(segments))
+	for i, s := range segments {
+		escaped[i] = url.PathEscape(s)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// request sends a request to `path` (relative to the server) and returns
+// the response. A non-nil `body` is sent as JSON.
+func (c *Client) request(
+	method, path string,
+	query url.Values,
+	body interface{},
+) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r = bytes.NewReader(bs)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.HTTP.Do(req)
+}
+
+// api makes a request to the API and decodes the content of the response
+// into `v`, which may be nil.
+func (c *Client) api(
+	method, path string,
+	query url.Values,
+	body, v interface{},
+) error {
+	_, err := c.apiHeader(method, path, query, body, v)
+	return err
+}
+
+// apiHeader is like api, but also returns the headers of the response.
+func (c *Client) apiHeader(
+	method, path string,
+	query url.Values,
+	body, v interface{},
+) (http.Header, error) {
+	resp, err := c.request(method, APIPrefix+path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return resp.Header, nil
+	}
+	return resp.Header, decodeResponse(resp, v)
+}
+
+// download copies a file from `path` to `w`. Errors are reported in JSON
+// when a token is used, so a JSON response is an error.
+func (c *Client) download(path string, query url.Values, w io.Writer) error {
+	resp, err := c.request("GET", path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ctype := resp.Header.Get("Content-Type")
+	if strings.HasPrefix(ctype, "application/json") {
+		if err := decodeResponse(resp, nil); err != nil {
+			return err
+		}
+		return &ServerError{
+			StatusCode: resp.StatusCode,
+			Message:    "expected a file but got JSON",
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &ServerError{
+			StatusCode: resp.StatusCode,
+			Message:    resp.Status,
+		}
+	}
+	if strings.HasPrefix(ctype, "text/html") {
+		return &ServerError{
+			StatusCode: resp.StatusCode,
+			Message:    "expected a file but got a web page",
+		}
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
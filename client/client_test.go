@@ -0,0 +1,308 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+const testToken = "lcm_secret"
+
+// testServer serves `handler` behind a check of the bearer token, which
+// responds like lcmweb does when the token is wrong.
+func testServer(handler http.HandlerFunc) (*Client, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer "+testToken {
+				respond(w, 401, map[string]interface{}{
+					"status":  "noauth",
+					"message": "Invalid or expired API token.",
+				})
+				return
+			}
+			handler(w, r)
+		}))
+	return New(srv.URL+"/", testToken), srv.Close
+}
+
+func respond(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func success(w http.ResponseWriter, code int, content interface{}) {
+	respond(w, code, map[string]interface{}{
+		"status":  "success",
+		"content": content,
+	})
+}
+
+func TestProjects(t *testing.T) {
+	c, done := testServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/api/v1/projects" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		success(w, 200, []map[string]interface{}{{
+			"Owner":         "andrew",
+			"Name":          "news",
+			"Display":       "News",
+			"Added":         "2016-01-02T03:04:05Z",
+			"Documents":     3,
+			"Collaborators": []map[string]string{{"Id": "kim", "Name": "Kim"}},
+		}})
+	})
+	defer done()
+
+	projs, err := c.Projects()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(projs) != 1 {
+		t.Fatalf("got %d projects, expected 1", len(projs))
+	}
+	p := projs[0]
+	if p.Owner != "andrew" || p.Name != "news" || p.Documents != 3 {
+		t.Errorf("unexpected project %+v", p)
+	}
+	if p.Added.Year() != 2016 {
+		t.Errorf("unexpected time %s", p.Added)
+	}
+	if len(p.Collaborators) != 1 || p.Collaborators[0].Id != "kim" {
+		t.Errorf("unexpected collaborators %+v", p.Collaborators)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	c, done := testServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/projects/andrew/missing":
+			respond(w, 400, map[string]string{
+				"status":  "fail",
+				"message": "Project missing does not exist.",
+			})
+		case "/api/v1/projects/andrew/broken":
+			respond(w, 500, map[string]string{
+				"status":  "error",
+				"message": "database is down",
+			})
+		case "/api/v1/projects/andrew/legacy":
+			// Messages of the other JSON responses are formatted as HTML
+			// and sent with a 200 status.
+			respond(w, 200, map[string]string{
+				"status":  "fail",
+				"message": "Project <strong>legacy</strong> isn&#39;t here.",
+			})
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(502)
+			w.Write([]byte("<html>Bad gateway</html>"))
+		}
+	})
+	defer done()
+
+	_, err := c.Project("andrew", "missing")
+	if e, ok := err.(*UserError); !ok {
+		t.Errorf("expected a UserError but got %#v", err)
+	} else if e.Message != "Project missing does not exist." {
+		t.Errorf("unexpected message %q", e.Message)
+	}
+
+	_, err = c.Project("andrew", "broken")
+	if e, ok := err.(*ServerError); !ok {
+		t.Errorf("expected a ServerError but got %#v", err)
+	} else if e.StatusCode != 500 || e.Message != "database is down" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	_, err = c.Project("andrew", "legacy")
+	if e, ok := err.(*UserError); !ok {
+		t.Errorf("expected a UserError but got %#v", err)
+	} else if e.Message != "Project legacy isn't here." {
+		t.Errorf("unexpected message %q", e.Message)
+	}
+
+	_, err = c.Project("andrew", "proxy")
+	if e, ok := err.(*ServerError); !ok {
+		t.Errorf("expected a ServerError but got %#v", err)
+	} else if e.StatusCode != 502 {
+		t.Errorf("unexpected status code %d", e.StatusCode)
+	}
+
+	c.Token = "wrong"
+	if _, err := c.Projects(); err == nil {
+		t.Error("expected an error with the wrong token")
+	} else if _, ok := err.(*AuthError); !ok {
+		t.Errorf("expected an AuthError but got %#v", err)
+	}
+}
+
+func TestAddDocument(t *testing.T) {
+	c, done := testServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" ||
+			r.URL.EscapedPath() != "/api/v1/projects/a%20b/news/documents" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.EscapedPath())
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		var doc NewDocument
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			t.Error(err)
+		}
+		if doc.Display != "Speech" || doc.Metadata["Speaker"] != "Kim" {
+			t.Errorf("unexpected document %+v", doc)
+		}
+		success(w, 201, map[string]interface{}{
+			"Name":       "Speech",
+			"Display":    doc.Display,
+			"Recorded":   doc.Recorded,
+			"Categories": doc.Categories,
+			"Metadata":   doc.Metadata,
+			"Content":    doc.Content,
+		})
+	})
+	defer done()
+
+	doc, err := c.AddDocument("a b", "news", NewDocument{
+		Display:    "Speech",
+		Recorded:   "2016-05-01",
+		Categories: []string{"verbs"},
+		Content:    "They help us.",
+		Metadata:   map[string]string{"Speaker": "Kim"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Name != "Speech" || doc.Content != "They help us." ||
+		len(doc.Categories) != 1 {
+		t.Errorf("unexpected document %+v", doc)
+	}
+}
+
+func TestScores(t *testing.T) {
+	const path = "/api/v1/projects/andrew/news/documents/Speech/2016-05-01" +
+		"/scores/verbs/4"
+	c, done := testServer(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		switch r.Method {
+		case "PUT":
+			var form map[string]string
+			json.NewDecoder(r.Body).Decode(&form)
+			success(w, 200, map[string]interface{}{
+				"Word":      4,
+				"Scheme":    "verbs",
+				"Category":  form["Category"],
+				"CreatedBy": "andrew",
+				"Created":   "2016-05-02T00:00:00Z",
+			})
+		case "DELETE":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	defer done()
+
+	score, err := c.SetScore("andrew", "news", "Speech", "2016-05-01",
+		"verbs", 4, "IAV")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score.Word != 4 || score.Category != "IAV" {
+		t.Errorf("unexpected score %+v", score)
+	}
+	err = c.DeleteScore("andrew", "news", "Speech", "2016-05-01", "verbs", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	pdf := []byte("%PDF-1.3 ...")
+	c, done := testServer(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/andrew/news/Speech/2016-05-01/report.pdf":
+			if r.URL.Query().Get("Style") != "underline" {
+				t.Errorf("unexpected query %s", r.URL.RawQuery)
+			}
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write(pdf)
+		default:
+			respond(w, 400, map[string]string{
+				"status":  "fail",
+				"message": "Unknown scheme.",
+			})
+		}
+	})
+	defer done()
+
+	var buf bytes.Buffer
+	err := c.Report("andrew", "news", "Speech", "2016-05-01",
+		ReportOptions{Style: "underline"}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), pdf) {
+		t.Errorf("unexpected report %q", buf.Bytes())
+	}
+
+	buf.Reset()
+	err = c.Export("andrew", "news", "summary.csv",
+		SummaryOptions{Scheme: "nope"}.Values(), &buf)
+	if _, ok := err.(*UserError); !ok {
+		t.Errorf("expected a UserError but got %#v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("wrote an error as the file: %q", buf.Bytes())
+	}
+}
+
+func TestScoreFilterValues(t *testing.T) {
+	q := ScoreFilter{Coders: []string{"a", "b"}}.Values()
+	if got := q.Encode(); got != "Coders=a&Coders=b" {
+		t.Errorf("unexpected query %q", got)
+	}
+}
+
+// Documents fetches every page, even when the server's pages are smaller
+// than asked for.
+func TestDocumentsPages(t *testing.T) {
+	names := []string{"A", "B", "C"}
+	c, done := testServer(func(w http.ResponseWriter, r *http.Request) {
+		page, _ := strconv.Atoi(r.URL.Query().Get("Page"))
+		if page < 1 {
+			t.Errorf("unexpected query %s", r.URL.RawQuery)
+			page = 1
+		}
+		docs := make([]map[string]string, 0)
+		for i := (page - 1) * 2; i < len(names) && i < page*2; i++ {
+			docs = append(docs, map[string]string{"Name": names[i]})
+		}
+		w.Header().Set("X-Page", strconv.Itoa(page))
+		w.Header().Set("X-Per-Page", "2")
+		w.Header().Set("X-Total-Count", strconv.Itoa(len(names)))
+		success(w, 200, docs)
+	})
+	defer done()
+
+	docs, err := c.Documents("andrew", "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 || docs[2].Name != "C" {
+		t.Errorf("unexpected documents %+v", docs)
+	}
+
+	_, page, err := c.ListDocuments("andrew", "news",
+		DocumentListOptions{Status: "complete", Page: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Page != 2 || page.Total != 3 || !page.Last() {
+		t.Errorf("unexpected page %+v", page)
+	}
+}
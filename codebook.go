@@ -0,0 +1,20 @@
+package main
+
+// codebook shows the guidelines and examples for every category in a
+// scoring scheme. It is laid out to be printed.
+func codebook(w *web) {
+	name := w.params["scheme"]
+	scheme, ok := conf.Scores[name]
+	if !ok {
+		panic(ue("Scoring scheme **%s** does not exist.", name))
+	}
+	w.html("codebook", m{
+		"Title": "Codebook for " + name,
+		"Nav": w.mkNav(
+			nav{"Projects", w.routes.URLFor("project-list")},
+			nav{"Codebook for " + name, ""},
+		),
+		"Name":   name,
+		"Scheme": scheme,
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// compareOptions selects the scores and the metadata key that groups of
+// documents are compared by.
+type compareOptions struct {
+	Scheme string
+	Coder  string
+
+	// Group is a metadata key. Documents without a value for it are left
+	// out of the comparison.
+	Group string
+}
+
+func (opts compareOptions) summary() summaryOptions {
+	return summaryOptions{Scheme: opts.Scheme, Coder: opts.Coder}
+}
+
+// groupStats describes the values of a measure in one group of documents.
+type groupStats struct {
+	Group    string
+	N        int
+	Mean, SD float64
+}
+
+// pairTest compares the values of a measure in two groups.
+type pairTest struct {
+	A, B        groupStats
+	Welch       welchResult
+	MannWhitney mannWhitneyResult
+}
+
+// measureComparison compares the groups on one per-document measure: the
+// abstraction index or the proportion of a category.
+type measureComparison struct {
+	Key   string
+	Label string
+
+	Groups []groupStats
+	Tests  []pairTest
+}
+
+// groupComparison is every measure compared across the groups of documents.
+type groupComparison struct {
+	Groups   []string
+	Measures []*measureComparison
+
+	// Excluded is the number of documents without a value for the key.
+	Excluded int
+}
+
+// compare computes group statistics for each measure and compares every
+// pair of groups. Only documents with at least one scored word contribute
+// values.
+func (proj *project) compare(opts compareOptions) *groupComparison {
+	scheme := opts.summary().scheme()
+	meta := proj.metadata()
+	cmp := &groupComparison{}
+
+	type measure struct {
+		key, label string
+		value      func(sum *documentSummary) (float64, bool)
+	}
+	measures := []measure{{
+		"abstraction", "Abstraction index",
+		func(sum *documentSummary) (float64, bool) {
+			return sum.Abstraction()
+		},
+	}}
+	for _, cat := range scheme.Ordered() {
+		cat := cat
+		measures = append(measures, measure{
+			"p_" + cat.Key, "Proportion " + cat.Name,
+			func(sum *documentSummary) (float64, bool) {
+				return sum.Proportion(cat.Key), sum.Scored() > 0
+			},
+		})
+	}
+
+	byGroup := make(map[string][]*documentSummary)
+	for _, sum := range proj.summaries(opts.summary()) {
+		group := meta.ByDocument[sum.Document.Key()][opts.Group]
+		if len(group) == 0 {
+			cmp.Excluded++
+			continue
+		}
+		if _, ok := byGroup[group]; !ok {
+			cmp.Groups = append(cmp.Groups, group)
+		}
+		byGroup[group] = append(byGroup[group], sum)
+	}
+	sort.Strings(cmp.Groups)
+
+	for _, m := range measures {
+		mc := &measureComparison{Key: m.key, Label: m.label}
+		values := make([][]float64, len(cmp.Groups))
+		for i, group := range cmp.Groups {
+			for _, sum := range byGroup[group] {
+				if v, ok := m.value(sum); ok {
+					values[i] = append(values[i], v)
+				}
+			}
+			mc.Groups = append(mc.Groups, groupStats{
+				Group: group,
+				N:     len(values[i]),
+				Mean:  mean(values[i]),
+				SD:    stddev(values[i]),
+			})
+		}
+		for i := range cmp.Groups {
+			for j := i + 1; j < len(cmp.Groups); j++ {
+				mc.Tests = append(mc.Tests, pairTest{
+					A:           mc.Groups[i],
+					B:           mc.Groups[j],
+					Welch:       welchTest(values[i], values[j]),
+					MannWhitney: mannWhitneyTest(values[i], values[j]),
+				})
+			}
+		}
+		cmp.Measures = append(cmp.Measures, mc)
+	}
+	return cmp
+}
+
+// comparePage shows group statistics and tests for a metadata key.
+func comparePage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts compareOptions
+	w.decodeQuery(&opts)
+	metaKeys := proj.metadata().Keys
+	if len(opts.Scheme) == 0 && len(conf.Scores) > 0 {
+		opts.Scheme = conf.Categories()[0]
+	}
+	if len(opts.Group) == 0 && len(metaKeys) > 0 {
+		opts.Group = metaKeys[0]
+	}
+
+	var cmp *groupComparison
+	if len(opts.Scheme) > 0 && len(opts.Group) > 0 {
+		cmp = proj.compare(opts)
+	}
+	w.html("compare", m{
+		"Title":      "Compare groups in " + proj.Display,
+		"Nav":        documentNav(w, proj, nil, "Compare groups"),
+		"P":          proj,
+		"Opts":       opts,
+		"Comparison": cmp,
+		"MetaKeys":   metaKeys,
+		"Coders":     proj.members(),
+		"Conf":       conf,
+	})
+}
+
+// exportCompareCSV writes one row for each measure and pair of groups with
+// the statistics of both groups and the results of the tests.
+func exportCompareCSV(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts compareOptions
+	w.decodeQuery(&opts)
+	if len(opts.Group) == 0 {
+		panic(ue("Choose a metadata key to compare documents by."))
+	}
+	scheme := opts.summary().scheme()
+	cmp := proj.compare(opts)
+
+	name := summaryFileName(proj, opts.summary(), "csv")
+	name = strings.Replace(name, "-summary-", "-compare-"+opts.Group+"-", 1)
+	w.attachment(name, "text/csv; charset=utf-8")
+	out := csv.NewWriter(w.w)
+	assert(out.Write([]string{
+		"scheme", "scheme_version", "layer", "group_by", "measure",
+		"group_a", "n_a", "mean_a", "sd_a",
+		"group_b", "n_b", "mean_b", "sd_b",
+		"welch_t", "welch_df", "welch_p", "cohens_d",
+		"mann_whitney_u", "mann_whitney_z", "mann_whitney_p", "rank_biserial",
+	}))
+	for _, mc := range cmp.Measures {
+		for _, test := range mc.Tests {
+			assert(out.Write([]string{
+				opts.Scheme, scheme.VersionString(), opts.summary().Layer(),
+				opts.Group, mc.Key,
+				test.A.Group, strconv.Itoa(test.A.N),
+				formatStat(test.A.Mean), formatStat(test.A.SD),
+				test.B.Group, strconv.Itoa(test.B.N),
+				formatStat(test.B.Mean), formatStat(test.B.SD),
+				formatStat(test.Welch.T), formatStat(test.Welch.DF),
+				formatStat(test.Welch.P), formatStat(test.Welch.D),
+				formatStat(test.MannWhitney.U), formatStat(test.MannWhitney.Z),
+				formatStat(test.MannWhitney.P), formatStat(test.MannWhitney.R),
+			}))
+		}
+	}
+	out.Flush()
+	assert(out.Error())
+}
@@ -1,8 +1,13 @@
 package main
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	html "html/template"
 	"log"
 	"path"
+	"regexp"
 	"sort"
 	"time"
 
@@ -11,13 +16,16 @@ import (
 )
 
 type config struct {
-	PgSQL     configPgsql
-	Email     configEmail
-	Options   configOptions
-	Security  configSecurity
-	Users     map[string]configUser
-	usersById map[string]configUser
-	Scores    map[string]configScoringScheme
+	PgSQL    configPgsql
+	Email    configEmail
+	Options  configOptions
+	Security configSecurity
+	Scores   map[string]configScoringScheme
+
+	// Users are only read the first time lcmweb runs with a `users` table,
+	// when they are imported into it. Users are managed by administrators
+	// from then on.
+	Users map[string]configUser
 }
 
 type configPgsql struct {
@@ -62,14 +70,86 @@ type configUser struct {
 }
 
 type configScoringScheme struct {
+	Version    string
 	Order      []string
 	Categories map[string]configScoreCategory
 }
 
+// Fingerprint identifies the definition of a scoring scheme: its categories,
+// their order and their values. Any change to the scheme that could affect
+// computed results changes its fingerprint.
+func (s configScoringScheme) Fingerprint() string {
+	h := sha1.New()
+	for _, cat := range s.Ordered() {
+		fmt.Fprintf(h, "%q %q %d\n", cat.Key, cat.Name, cat.Value)
+	}
+	return hex.EncodeToString(h.Sum(nil))[0:12]
+}
+
+// VersionString describes the version of a scoring scheme for exports. It is
+// the version given in the configuration (if any) along with the scheme's
+// fingerprint, since the configuration can be edited without changing the
+// version.
+func (s configScoringScheme) VersionString() string {
+	if len(s.Version) == 0 {
+		return s.Fingerprint()
+	}
+	return fmt.Sprintf("%s (%s)", s.Version, s.Fingerprint())
+}
+
+// schemeCategory is a category in a scoring scheme along with the key that
+// identifies it in scores.
+type schemeCategory struct {
+	Key string
+	configScoreCategory
+}
+
+// Ordered returns the categories of the scheme in the order given by the
+// configuration.
+func (s configScoringScheme) Ordered() []schemeCategory {
+	cats := make([]schemeCategory, len(s.Order))
+	for i, key := range s.Order {
+		cats[i] = schemeCategory{key, s.Categories[key]}
+	}
+	return cats
+}
+
+// configScoreCategory is a single category in a scoring scheme. Guidelines
+// and examples are written in Markdown and make up the scheme's codebook.
 type configScoreCategory struct {
-	Name     string
-	Value    int
-	Shortcut string
+	Name       string
+	Value      int
+	Shortcut   string
+	Color      string
+	Guidelines string
+	Examples   []string
+}
+
+var (
+	reColor = regexp.MustCompile("^#[0-9a-fA-F]{6}$")
+
+	// categoryPalette provides colors for categories that aren't given one
+	// in the configuration. Categories are assigned colors by their position
+	// in their scheme's order.
+	categoryPalette = []string{
+		"#8dd3c7", "#ffffb3", "#bebada", "#fb8072", "#80b1d3",
+		"#fdb462", "#b3de69", "#fccde5", "#d9d9d9", "#bc80bd",
+	}
+)
+
+// GuidelinesHTML returns the guidelines for the category rendered as HTML.
+// The configuration is trusted, so the Markdown is not escaped.
+func (c configScoreCategory) GuidelinesHTML() html.HTML {
+	return html.HTML(toMarkdown(c.Guidelines))
+}
+
+// ExamplesHTML returns each worked example for the category rendered as HTML.
+func (c configScoreCategory) ExamplesHTML() []html.HTML {
+	exs := make([]html.HTML, len(c.Examples))
+	for i, ex := range c.Examples {
+		exs[i] = html.HTML(toMarkdown(ex))
+	}
+	return exs
 }
 
 func newConfig() (conf config) {
@@ -93,50 +173,36 @@ func newConfig() (conf config) {
 		log.Fatalf("Session timeout must be at least 1 minute.")
 	}
 
-	// Set the ID of each user.
+	// Set the ID of each user. They are checked when they are imported.
 	for id, user := range conf.Users {
 		user.Id = id
 		conf.Users[id] = user
 	}
 
-	// Now make sure we support each user's time zone.
-	for k, user := range conf.Users {
-		user.timeZone, err = time.LoadLocation(user.TimeZone)
-		if err != nil {
-			log.Fatalf("Invalid time zone '%s' for user '%s': %s",
-				user.TimeZone, user.Id, err)
-		}
-		conf.Users[k] = user
-	}
-
-	// And now make sure each collaborator is a valid user.
-	for k := range conf.Users {
-		user := conf.Users[k]
-		user.Collaborators = make([]*lcmUser, len(user.Friends))
-		for i, friend := range user.Friends {
-			if friend == k {
-				log.Fatalf("A user '%s' cannot be friends with themself.", k)
-			}
-
-			if ufriend, ok := conf.Users[friend]; !ok {
-				log.Fatalf("Collaborator '%s' for '%s' is not a valid user.",
-					friend, k)
-			} else {
-				user.Collaborators[i] = newLcmUser(ufriend)
+	// Every category in the order of a scoring scheme must exist, and every
+	// category must be in the order.
+	for name, scheme := range conf.Scores {
+		for _, key := range scheme.Order {
+			if _, ok := scheme.Categories[key]; !ok {
+				log.Fatalf("Category '%s' in the order of scoring scheme "+
+					"'%s' does not exist.", key, name)
 			}
 		}
-		sort.Sort(usersAlphabetical(user.Collaborators))
-		conf.Users[k] = user
-	}
-
-	// For faster lookups.
-	conf.usersById = make(map[string]configUser, len(conf.Users))
-	for _, user := range conf.Users {
-		if dupe, ok := conf.usersById[user.Id]; ok {
-			log.Fatalf("Two users ('%s' and '%s') cannot have the same name.",
-				user.Id, dupe.Id)
+		if len(scheme.Order) != len(scheme.Categories) {
+			log.Fatalf("The order of scoring scheme '%s' must list each of "+
+				"its %d categories exactly once.",
+				name, len(scheme.Categories))
+		}
+		for i, key := range scheme.Order {
+			cat := scheme.Categories[key]
+			if len(cat.Color) == 0 {
+				cat.Color = categoryPalette[i%len(categoryPalette)]
+			} else if !reColor.MatchString(cat.Color) {
+				log.Fatalf("Color '%s' of category '%s' in scoring scheme "+
+					"'%s' must have the form '#rrggbb'.", cat.Color, key, name)
+			}
+			scheme.Categories[key] = cat
 		}
-		conf.usersById[user.Id] = user
 	}
 
 	return
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// dashboardRecent is the number of recently changed documents shown on a
+// project's dashboard.
+const dashboardRecent = 10
+
+// projectDashboard is an overview of a project and the progress of its
+// coders.
+//
+// Progress is measured in units: a unit is one word of a document in one of
+// the scoring schemes the document is scored with. A document with 100 words
+// and two schemes has 200 units, and is completely coded once each of its
+// words has a score in both schemes.
+type projectDashboard struct {
+	Documents    int
+	Tokens       int
+	Units        int
+	Scored       int
+	LastActivity time.Time
+	Coders       []*coderProgress
+	Recent       []*recentDocument
+}
+
+// Percent is the percentage of units in the project that are scored.
+func (dash *projectDashboard) Percent() float64 {
+	return percent(dash.Scored, dash.Units)
+}
+
+// coderProgress is how many units a member of a project has scored. Since a
+// word has only one score in each scheme, this is the number of scores last
+// set by the coder.
+type coderProgress struct {
+	User      *lcmUser
+	Scored    int
+	Documents int
+	Last      time.Time
+	units     int
+}
+
+func (cp *coderProgress) Percent() float64 {
+	return percent(cp.Scored, cp.units)
+}
+
+// recentDocument is a document along with how much of it is scored.
+type recentDocument struct {
+	Document *document
+	Tokens   int
+	Units    int
+	Scored   int
+	Last     time.Time
+}
+
+func (rd *recentDocument) Percent() float64 {
+	return percent(rd.Scored, rd.Units)
+}
+
+func percent(n, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(total)
+}
+
+// dashboard computes the overview of a project. It takes three queries no
+// matter how many documents or coders the project has.
+func (proj *project) dashboard() *projectDashboard {
+	dash := &projectDashboard{}
+	err := db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM document
+			 WHERE project_owner = $1 AND project_name = $2),
+			(SELECT COUNT(*) FROM token
+			 WHERE project_owner = $1 AND project_name = $2),
+			(SELECT COUNT(*)
+			 FROM token t
+			 JOIN document_category dc
+				ON dc.project_owner = t.project_owner
+				AND dc.project_name = t.project_name
+				AND dc.document_name = t.document_name
+				AND dc.document_recorded = t.document_recorded
+			 WHERE t.project_owner = $1 AND t.project_name = $2),
+			(SELECT COUNT(*)
+			 FROM score s
+			 JOIN document_category dc
+				ON dc.project_owner = s.project_owner
+				AND dc.project_name = s.project_name
+				AND dc.document_name = s.document_name
+				AND dc.document_recorded = s.document_recorded
+				AND dc.category = s.category
+			 WHERE s.project_owner = $1 AND s.project_name = $2),
+			GREATEST(
+				p.created,
+				(SELECT MAX(modified) FROM document
+				 WHERE project_owner = $1 AND project_name = $2),
+				(SELECT MAX(created) FROM score
+				 WHERE project_owner = $1 AND project_name = $2))
+		FROM
+			project p
+		WHERE
+			p.owner = $1 AND p.name = $2
+		`, proj.Owner.Id, proj.Name).Scan(&dash.Documents, &dash.Tokens,
+		&dash.Units, &dash.Scored, &dash.LastActivity)
+	assert(err)
+
+	byCoder := make(map[string]*coderProgress)
+	for _, user := range proj.members() {
+		cp := &coderProgress{User: user, units: dash.Units}
+		byCoder[user.Id] = cp
+		dash.Coders = append(dash.Coders, cp)
+	}
+	rows := csql.Query(db, `
+		SELECT
+			s.created_by, COUNT(*),
+			COUNT(DISTINCT (s.document_name, s.document_recorded)),
+			MAX(s.created)
+		FROM
+			score s
+		JOIN
+			document_category dc
+			ON dc.project_owner = s.project_owner
+			AND dc.project_name = s.project_name
+			AND dc.document_name = s.document_name
+			AND dc.document_recorded = s.document_recorded
+			AND dc.category = s.category
+		WHERE
+			s.project_owner = $1 AND s.project_name = $2
+		GROUP BY
+			s.created_by
+		`, proj.Owner.Id, proj.Name)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var coder string
+		var scored, docs int
+		var last time.Time
+		csql.Scan(row, &coder, &scored, &docs, &last)
+
+		// Scores may have been set by someone who is no longer a
+		// collaborator on the project.
+		cp := byCoder[coder]
+		if cp == nil {
+			user := findUserByNo(coder)
+			if user == nil {
+				return
+			}
+			cp = &coderProgress{User: user, units: dash.Units}
+			byCoder[coder] = cp
+			dash.Coders = append(dash.Coders, cp)
+		}
+		cp.Scored, cp.Documents, cp.Last = scored, docs, last
+	})
+
+	rows = csql.Query(db, `
+		SELECT
+			d.name, d.recorded, d.created_by, d.created, d.modified,
+			COALESCE(t.n, 0), COALESCE(t.n, 0) * COALESCE(c.n, 0),
+			COALESCE(s.n, 0), GREATEST(d.modified, s.last)
+		FROM
+			document d
+		LEFT JOIN (
+			SELECT document_name, document_recorded, COUNT(*) AS n
+			FROM token
+			WHERE project_owner = $1 AND project_name = $2
+			GROUP BY document_name, document_recorded
+		) t ON t.document_name = d.name AND t.document_recorded = d.recorded
+		LEFT JOIN (
+			SELECT document_name, document_recorded, COUNT(*) AS n
+			FROM document_category
+			WHERE project_owner = $1 AND project_name = $2
+			GROUP BY document_name, document_recorded
+		) c ON c.document_name = d.name AND c.document_recorded = d.recorded
+		LEFT JOIN (
+			SELECT
+				s.document_name, s.document_recorded,
+				COUNT(*) AS n, MAX(s.created) AS last
+			FROM score s
+			JOIN document_category dc
+				ON dc.project_owner = s.project_owner
+				AND dc.project_name = s.project_name
+				AND dc.document_name = s.document_name
+				AND dc.document_recorded = s.document_recorded
+				AND dc.category = s.category
+			WHERE s.project_owner = $1 AND s.project_name = $2
+			GROUP BY s.document_name, s.document_recorded
+		) s ON s.document_name = d.name AND s.document_recorded = d.recorded
+		WHERE
+			d.project_owner = $1 AND d.project_name = $2
+		ORDER BY
+			GREATEST(d.modified, s.last) DESC, d.name ASC
+		LIMIT $3
+		`, proj.Owner.Id, proj.Name, dashboardRecent)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := &document{Project: proj}
+		rd := &recentDocument{Document: d}
+		var createdBy string
+		csql.Scan(row, &d.Name, &d.Recorded, &createdBy, &d.Created,
+			&d.Modified, &rd.Tokens, &rd.Units, &rd.Scored, &rd.Last)
+		d.Display = nameToDisplay(d.Name)
+		d.CreatedBy = findUserByNo(createdBy)
+		dash.Recent = append(dash.Recent, rd)
+	})
+	return dash
+}
+
+// dashboardPage shows an overview of a project: how much of it is coded,
+// the progress of each coder and the documents changed most recently.
+func dashboardPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	w.html("project-dashboard", m{
+		"Title":     "Dashboard for " + proj.Display,
+		"Nav":       documentNav(w, proj, nil, "Dashboard"),
+		"P":         proj,
+		"Dashboard": proj.dashboard(),
+	})
+}
@@ -5,6 +5,10 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 
 	_ "github.com/lib/pq"
 
@@ -83,6 +87,372 @@ var schemaMigrations = []migration.Migrator{
 			`)
 		return err
 	},
+	// The categories of a document were stored in a TEXT column without any
+	// defined format. They are moved to their own table, which accepts any
+	// of the formats that could have been written: `{a,b}`, `[a b]` or a
+	// plain comma or space separated list.
+	func(tx migration.LimitedTx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE document_category (
+				project_owner TEXT NOT NULL,
+				project_name TEXT NOT NULL,
+				document_name TEXT NOT NULL,
+				document_recorded DATE NOT NULL,
+				category TEXT NOT NULL,
+				PRIMARY KEY
+					(project_owner, project_name,
+					 document_name, document_recorded,
+					 category),
+				FOREIGN KEY (project_owner, project_name)
+					REFERENCES project (owner, name)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE,
+				FOREIGN KEY (document_name, document_recorded)
+					REFERENCES document (name, recorded)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE
+			);
+			`)
+		if err != nil {
+			return err
+		}
+
+		type oldDocument struct {
+			owner, project, name string
+			recorded             time.Time
+			categories           string
+		}
+		olds := make([]oldDocument, 0)
+		rows, err := tx.Query(`
+			SELECT project_owner, project_name, name, recorded, categories
+			FROM document
+			`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var d oldDocument
+			err := rows.Scan(
+				&d.owner, &d.project, &d.name, &d.recorded, &d.categories)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			olds = append(olds, d)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, d := range olds {
+			for _, cat := range parseOldCategories(d.categories) {
+				_, err := tx.Exec(`
+					INSERT INTO document_category (
+						project_owner, project_name,
+						document_name, document_recorded, category
+					) VALUES ($1, $2, $3, $4, $5)
+					`, d.owner, d.project, d.name, d.recorded, cat)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		_, err = tx.Exec(`ALTER TABLE document DROP COLUMN categories`)
+		return err
+	},
+	func(tx migration.LimitedTx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE document_metadata (
+				project_owner TEXT NOT NULL,
+				project_name TEXT NOT NULL,
+				document_name TEXT NOT NULL,
+				document_recorded DATE NOT NULL,
+				key TEXT NOT NULL,
+				value TEXT NOT NULL,
+				PRIMARY KEY
+					(project_owner, project_name,
+					 document_name, document_recorded,
+					 key),
+				FOREIGN KEY (project_owner, project_name)
+					REFERENCES project (owner, name)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE,
+				FOREIGN KEY (document_name, document_recorded)
+					REFERENCES document (name, recorded)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE
+			);
+			`)
+		return err
+	},
+	// Tokens are stored so that words can be searched across a project
+	// without tokenizing every document. Existing documents are tokenized
+	// here.
+	func(tx migration.LimitedTx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE token (
+				project_owner TEXT NOT NULL,
+				project_name TEXT NOT NULL,
+				document_name TEXT NOT NULL,
+				document_recorded DATE NOT NULL,
+				word INTEGER NOT NULL,
+				surface TEXT NOT NULL,
+				lower TEXT NOT NULL,
+				lemma TEXT NOT NULL,
+				PRIMARY KEY
+					(project_owner, project_name,
+					 document_name, document_recorded,
+					 word),
+				FOREIGN KEY (project_owner, project_name)
+					REFERENCES project (owner, name)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE,
+				FOREIGN KEY (document_name, document_recorded)
+					REFERENCES document (name, recorded)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE
+			);
+			CREATE INDEX token_lower
+				ON token (project_owner, project_name, lower);
+			CREATE INDEX token_lemma
+				ON token (project_owner, project_name, lemma);
+			`)
+		if err != nil {
+			return err
+		}
+
+		type oldDocument struct {
+			owner, project, name string
+			recorded             time.Time
+			content              string
+		}
+		olds := make([]oldDocument, 0)
+		rows, err := tx.Query(`
+			SELECT project_owner, project_name, name, recorded, content
+			FROM document
+			`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var d oldDocument
+			err := rows.Scan(
+				&d.owner, &d.project, &d.name, &d.recorded, &d.content)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			olds = append(olds, d)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		rows.Close()
+
+		for _, d := range olds {
+			err := insertTokens(tx, d.owner, d.project, d.name, d.recorded,
+				tokenize(d.content), lemmatizeV1)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	// API tokens are only stored as hashes. A NULL expiry means the token
+	// is valid until it is revoked.
+	func(tx migration.LimitedTx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE api_token (
+				id SERIAL PRIMARY KEY,
+				userid TEXT NOT NULL,
+				name TEXT NOT NULL,
+				hash TEXT NOT NULL UNIQUE,
+				scope TEXT NOT NULL,
+				created utctime NOT NULL,
+				expires utctime,
+				last_used utctime
+			);
+			CREATE INDEX api_token_userid ON api_token (userid);
+			`)
+		return err
+	},
+	// Deliveries to webhooks are queued so that they survive restarts. A
+	// NULL next_attempt means the delivery is finished: it either succeeded
+	// (delivered is set) or ran out of attempts.
+	func(tx migration.LimitedTx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE webhook (
+				id SERIAL PRIMARY KEY,
+				project_owner TEXT NOT NULL,
+				project_name TEXT NOT NULL,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				events TEXT NOT NULL,
+				created_by TEXT NOT NULL,
+				created utctime NOT NULL,
+				FOREIGN KEY (project_owner, project_name)
+					REFERENCES project (owner, name)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE
+			);
+			CREATE TABLE webhook_delivery (
+				id SERIAL PRIMARY KEY,
+				webhook INTEGER NOT NULL
+					REFERENCES webhook (id) ON DELETE CASCADE,
+				event TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				created utctime NOT NULL,
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt utctime,
+				last_attempt utctime,
+				delivered utctime,
+				status_code INTEGER NOT NULL DEFAULT 0,
+				response TEXT NOT NULL DEFAULT '',
+				error TEXT NOT NULL DEFAULT ''
+			);
+			CREATE INDEX webhook_delivery_next_attempt
+				ON webhook_delivery (next_attempt);
+			CREATE INDEX webhook_delivery_webhook
+				ON webhook_delivery (webhook, created);
+			`)
+		return err
+	},
+	// Users were configured in config.toml. They are imported once, and
+	// the configuration is ignored from then on.
+	func(tx migration.LimitedTx) error {
+		if err := checkConfigUsers(conf.Users); err != nil {
+			return fmt.Errorf("cannot import users from config.toml: %s", err)
+		}
+		_, err := tx.Exec(`
+			CREATE TABLE users (
+				id TEXT PRIMARY KEY,
+				name TEXT NOT NULL,
+				email TEXT NOT NULL UNIQUE,
+				admin BOOLEAN NOT NULL DEFAULT FALSE,
+				time_zone TEXT NOT NULL,
+				date_fmt TEXT NOT NULL,
+				time_fmt TEXT NOT NULL
+			);
+			CREATE TABLE user_friend (
+				userid TEXT NOT NULL
+					REFERENCES users (id) ON DELETE CASCADE,
+				friend TEXT NOT NULL
+					REFERENCES users (id) ON DELETE CASCADE,
+				PRIMARY KEY (userid, friend)
+			);
+			`)
+		if err != nil {
+			return err
+		}
+		for _, user := range conf.Users {
+			_, err := tx.Exec(`
+				INSERT INTO users (
+					id, name, email, admin, time_zone, date_fmt, time_fmt
+				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+				`, user.Id, user.Name, user.Email, user.Admin, user.TimeZone,
+				user.DateFmt, user.TimeFmt)
+			if err != nil {
+				return err
+			}
+		}
+		for _, user := range conf.Users {
+			for _, friend := range uniqueFriends(user.Friends) {
+				_, err := tx.Exec(`
+					INSERT INTO user_friend (userid, friend) VALUES ($1, $2)
+					`, user.Id, friend)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	},
+	// The owner of a project adjudicates a document once its scores are
+	// settled.
+	func(tx migration.LimitedTx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE document_adjudication (
+				project_owner TEXT NOT NULL,
+				project_name TEXT NOT NULL,
+				document_name TEXT NOT NULL,
+				document_recorded DATE NOT NULL,
+				adjudicated_by TEXT NOT NULL,
+				adjudicated utctime NOT NULL,
+				PRIMARY KEY
+					(project_owner, project_name,
+					 document_name, document_recorded),
+				FOREIGN KEY (project_owner, project_name)
+					REFERENCES project (owner, name)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE,
+				FOREIGN KEY (document_name, document_recorded)
+					REFERENCES document (name, recorded)
+					ON DELETE CASCADE
+					ON UPDATE CASCADE
+			);
+			`)
+		return err
+	},
+}
+
+// checkConfigUsers makes sure the users in config.toml can be imported.
+// Email addresses were not checked before users were stored in the
+// database, where each user needs an address of their own.
+func checkConfigUsers(users map[string]configUser) error {
+	ids := make([]string, 0, len(users))
+	for id := range users {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	emails := make(map[string]string)
+	for _, id := range ids {
+		user := users[id]
+		if len(strings.TrimSpace(user.Email)) == 0 {
+			return fmt.Errorf("user '%s' has no email address", id)
+		}
+		if other, ok := emails[user.Email]; ok {
+			return fmt.Errorf("users '%s' and '%s' have the same email "+
+				"address '%s'", other, id, user.Email)
+		}
+		emails[user.Email] = id
+		if _, err := time.LoadLocation(user.TimeZone); err != nil {
+			return fmt.Errorf("invalid time zone '%s' for user '%s': %s",
+				user.TimeZone, id, err)
+		}
+		for _, friend := range user.Friends {
+			if friend == id {
+				return fmt.Errorf(
+					"user '%s' cannot be friends with themself", id)
+			}
+			if _, ok := users[friend]; !ok {
+				return fmt.Errorf(
+					"collaborator '%s' for '%s' is not a valid user",
+					friend, id)
+			}
+		}
+	}
+	return nil
+}
+
+// parseOldCategories splits the categories of a document as they were
+// stored before they had their own table. Duplicates are removed.
+func parseOldCategories(s string) []string {
+	s = strings.Trim(strings.TrimSpace(s), "{}[]")
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	cats := make([]string, 0, len(fields))
+	seen := make(map[string]bool)
+	for _, f := range fields {
+		f = strings.Trim(f, `"'`)
+		if len(f) > 0 && !seen[f] {
+			seen[f] = true
+			cats = append(cats, f)
+		}
+	}
+	return cats
 }
 
 type lcmDB struct {
@@ -1,16 +1,26 @@
 package main
 
 import (
-	"github.com/BurntSushi/csql"
-
+	"database/sql"
+	"fmt"
+	html "html/template"
+	"net/http"
+	"net/url"
 	"regexp"
+	"strings"
 	"time"
+
+	"github.com/BurntSushi/csql"
 )
 
 var (
 	reDocumentName = regexp.MustCompile("^[-a-zA-Z0-9 ]+$")
 )
 
+// recordedFmt is the format of a document's recorded date when it is used
+// to identify the document (e.g., in URLs).
+const recordedFmt = "2006-01-02"
+
 func documentNav(w *web, proj *project, d *document, label string) []string {
 	doclist := w.routes.URLFor("document-list", proj.Owner.Id, proj.Name)
 	navs := []nav{
@@ -19,7 +29,7 @@ func documentNav(w *web, proj *project, d *document, label string) []string {
 	}
 	if d != nil {
 		docmain := w.routes.URLFor(
-			"document", proj.Owner.Id, proj.Name, d.Name, d.Recorded)
+			"document", proj.Owner.Id, proj.Name, d.Name, d.RecordedKey())
 		navs = append(navs, nav{d.Name, docmain})
 	}
 	if len(label) > 0 {
@@ -30,15 +40,48 @@ func documentNav(w *web, proj *project, d *document, label string) []string {
 
 func documents(w *web) {
 	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts documentListOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+	docs, page := proj.listDocuments(opts)
 	w.html("document-list", m{
-		"Nav": documentNav(w, proj, nil, ""),
-		"P":   proj,
+		"Nav":       documentNav(w, proj, nil, ""),
+		"P":         proj,
+		"Documents": docs,
+		"Options":   opts,
+		"Statuses":  documentStatuses,
+		"Page":      page,
+		"Coders":    proj.members(),
+		"Conf":      conf,
+	})
+}
+
+func viewDocument(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+
+	// The event id must be read before the scores so that any change made
+	// while the page is loading is replayed to it.
+	lastEventId := docEvents.lastId(d)
+	w.html("document", m{
+		"js":           []string{"document"},
+		"Title":        d.Display,
+		"Nav":          documentNav(w, proj, d, ""),
+		"P":            proj,
+		"D":            d,
+		"Scores":       d.ScoreEvents(),
+		"LastEventId":  lastEventId,
+		"Schemes":      d.Categories,
+		"Coders":       proj.members(),
+		"Conf":         conf,
+		"Adjudication": d.Adjudication(),
+		"Completed":    d.completed(),
 	})
 }
 
 func addDocument(w *web) {
 	proj := getProject(w.user, w.params["owner"], w.params["project"])
-	show := func(msg string) {
+	show := func(msg html.HTML) {
 		w.html("document-add", m{
 			"js":      []string{"document-upload"},
 			"Nav":     documentNav(w, proj, nil, "Add Document"),
@@ -50,6 +93,29 @@ func addDocument(w *web) {
 	if w.r.Method == "GET" {
 		show("")
 	} else if w.r.Method == "POST" {
+		var form struct {
+			Display    string
+			Recorded   string
+			Categories []string
+			Content    string
+		}
+		w.decode(&form)
+
+		recorded, err := time.Parse(recordedFmt, form.Recorded)
+		if err != nil {
+			show(formatMessage(fmt.Sprintf(
+				"Could not parse date **%s**. Please use the format "+
+					"YYYY-MM-DD.", form.Recorded)))
+			return
+		}
+		d, err := insertDocument(w.user, proj, form.Display, recorded,
+			form.Categories, form.Content)
+		if err != nil {
+			show(formatMessage(err.Error()))
+			return
+		}
+		http.Redirect(w.w, w.r, w.routes.URLFor("document",
+			proj.Owner.Id, proj.Name, d.Name, d.RecordedKey()), 302)
 	} else {
 		panic(ef("Unrecognized request method: %s", w.r.Method))
 	}
@@ -65,6 +131,8 @@ type document struct {
 	CreatedBy  *lcmUser
 	Created    time.Time
 	Modified   time.Time
+	Metadata   map[string]string
+	tokens     []token
 }
 
 func insertDocument(
@@ -74,13 +142,35 @@ func insertDocument(
 	recorded time.Time,
 	categories []string,
 	content string,
+) (*document, error) {
+	d, err := newDocument(creator, proj, display, recorded, categories,
+		content)
+	if err != nil {
+		return nil, err
+	}
+	csql.Tx(db, func(tx *sql.Tx) {
+		d.insert(tx)
+	})
+	proj.triggerHooks(hookDocumentAdded, creator, d)
+	return d, nil
+}
+
+// newDocument builds a document that hasn't been added yet and checks that
+// it can be.
+func newDocument(
+	creator *lcmUser,
+	proj *project,
+	display string,
+	recorded time.Time,
+	categories []string,
+	content string,
 ) (*document, error) {
 	d := &document{
 		Project:    proj,
 		Display:    display,
 		Name:       displayToName(display),
 		Recorded:   recorded,
-		Categories: categories,
+		Categories: cleanCategories(categories),
 		Content:    content,
 		CreatedBy:  creator,
 		Created:    time.Now().UTC(),
@@ -89,15 +179,24 @@ func insertDocument(
 	if err := d.validate(); err != nil {
 		return nil, err
 	}
-	csql.Exec(db, `
+	return d, nil
+}
+
+// insert adds a document from newDocument, along with its categories and
+// tokens, inside the given transaction. The document_added hook is left to
+// the caller, since it must wait until the transaction commits.
+func (d *document) insert(tx *sql.Tx) {
+	csql.Exec(tx, `
 		INSERT INTO document (
-			project_owner, project_name, name, recorded, categories,
+			project_owner, project_name, name, recorded,
 			content, created_by, created, modified
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		`,
 		d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
-		d.Categories, d.Content, d.CreatedBy.Id, d.Created, d.Modified)
-	return d, nil
+		d.Content, d.CreatedBy.Id, d.Created, d.Modified)
+	d.insertCategories(tx)
+	assert(insertTokens(tx, d.Project.Owner.Id, d.Project.Name, d.Name,
+		d.Recorded, d.Tokens(), lemmatize))
 }
 
 // validate will check to make sure a document is valid and can be inserted
@@ -113,6 +212,9 @@ func (d *document) validate() error {
 		return ue("Document names can only contain letters, numbers, " +
 			"spaces and dashes.")
 	}
+	if err := validateCategories(d.Categories); err != nil {
+		return err
+	}
 	if d.isDuplicate() {
 		return ue("A document named **%s** and recorded on **%s** "+
 			"already exists.", d.Display, thDate(d.CreatedBy, d.Recorded))
@@ -120,6 +222,314 @@ func (d *document) validate() error {
 	return nil
 }
 
+// getDocument finds a document in a project given its name and recorded
+// date (formatted as `recordedFmt`). Access to the project should already
+// have been checked with getProject.
+func getDocument(proj *project, name, recorded string) *document {
+	rec, err := time.Parse(recordedFmt, recorded)
+	if err != nil {
+		panic(ue("Could not parse recorded date **%s**: %s", recorded, err))
+	}
+	d := findDocument(proj, name, rec)
+	if d == nil {
+		panic(ue("Could not find any document named **%s** recorded on "+
+			"**%s** in project **%s**.", name, recorded, proj.Display))
+	}
+	return d
+}
+
+// findDocument is like getDocument, but returns nil if the document doesn't
+// exist.
+func findDocument(proj *project, name string, recorded time.Time) *document {
+	d := &document{Project: proj, Name: name, Recorded: recorded}
+
+	var createdBy string
+	err := db.QueryRow(`
+		SELECT
+			content, created_by, created, modified
+		FROM
+			document
+		WHERE
+			project_owner = $1 AND project_name = $2
+			AND name = $3 AND recorded = $4
+	`, proj.Owner.Id, proj.Name, d.Name, d.Recorded).Scan(
+		&d.Content, &createdBy, &d.Created, &d.Modified)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	assert(err)
+	d.Display = nameToDisplay(d.Name)
+	d.CreatedBy = findUserByNo(createdBy)
+	d.Categories = d.loadCategories()
+	d.Metadata = d.loadMetadata()
+	return d
+}
+
+// Key uniquely identifies the document within its project. It is used
+// to refer to documents in forms and query strings.
+func (d *document) Key() string {
+	return d.Name + "/" + d.RecordedKey()
+}
+
+// parseDocumentKey splits a key returned by document.Key into a document
+// name and its recorded date.
+func parseDocumentKey(key string) (name string, recorded time.Time, err error) {
+	pieces := strings.SplitN(key, "/", 2)
+	if len(pieces) != 2 {
+		return "", time.Time{}, ue("Invalid document **%s**.", key)
+	}
+	recorded, err = time.Parse(recordedFmt, pieces[1])
+	if err != nil {
+		return "", time.Time{}, ue("Invalid document **%s**: %s", key, err)
+	}
+	return pieces[0], recorded, nil
+}
+
+// documents returns every document in the project, without their content,
+// ordered by name and recorded date.
+func (proj *project) documents() []*document {
+	docs := make([]*document, 0)
+	rows := csql.Query(db, `
+		SELECT
+			name, recorded, created_by, created, modified
+		FROM
+			document
+		WHERE
+			project_owner = $1 AND project_name = $2
+		ORDER BY
+			name ASC, recorded ASC
+	`, proj.Owner.Id, proj.Name)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := &document{Project: proj}
+		var createdBy string
+		csql.Scan(row, &d.Name, &d.Recorded, &createdBy, &d.Created,
+			&d.Modified)
+		d.Display = nameToDisplay(d.Name)
+		d.CreatedBy = findUserByNo(createdBy)
+		docs = append(docs, d)
+	})
+	return docs
+}
+
+// Statuses of documents, by how much of them is scored. See
+// projectDashboard for how progress is measured.
+const (
+	statusUnscored = "unscored"
+	statusPartial  = "partial"
+	statusComplete = "complete"
+)
+
+var documentStatuses = []string{statusUnscored, statusPartial, statusComplete}
+
+// documentListOptions selects, sorts and paginates the documents of a
+// project.
+type documentListOptions struct {
+	// Sort is "name", "recorded", "progress" or "modified", prefixed by "-"
+	// to sort in descending order.
+	Sort string
+
+	// Status is one of documentStatuses, or empty for every document.
+	Status string
+
+	// Metadata are filters of the form KEY=VALUE, which may also be
+	// separated by commas. Only documents with every one of them are listed.
+	// Values are compared ignoring case.
+	Metadata []string
+
+	Page    int
+	PerPage int
+}
+
+func (opts *documentListOptions) defaults() {
+	if len(opts.Sort) == 0 {
+		opts.Sort = "name"
+	}
+	filters := make([]string, 0, len(opts.Metadata))
+	for _, f := range opts.Metadata {
+		for _, filter := range strings.Split(f, ",") {
+			if filter = strings.TrimSpace(filter); len(filter) > 0 {
+				filters = append(filters, filter)
+			}
+		}
+	}
+	opts.Metadata = filters
+}
+
+// values returns the query string of the options, without the page.
+func (opts documentListOptions) values() url.Values {
+	q := url.Values{"Sort": {opts.Sort}}
+	if len(opts.Status) > 0 {
+		q.Set("Status", opts.Status)
+	}
+	if len(opts.Metadata) > 0 {
+		q["Metadata"] = opts.Metadata
+	}
+	return q
+}
+
+// SortURL returns the query string of the same list sorted by `by`.
+func (opts documentListOptions) SortURL(by string) string {
+	q := opts.values()
+	q.Set("Sort", toggleSort(opts.Sort, by))
+	return "?" + q.Encode()
+}
+
+// MetadataFilter is the metadata filters joined for the filter form.
+func (opts documentListOptions) MetadataFilter() string {
+	return strings.Join(opts.Metadata, ", ")
+}
+
+var documentSorts = map[string]string{
+	"name":     "p.name",
+	"recorded": "p.recorded",
+	"progress": "CASE WHEN p.units = 0 THEN 0 " +
+		"ELSE p.scored::float / p.units END",
+	"modified": "p.modified",
+}
+
+var documentStatusSQL = map[string]string{
+	statusUnscored: "p.scored = 0",
+	statusPartial:  "p.scored > 0 AND p.scored < p.units",
+	statusComplete: "p.units > 0 AND p.scored >= p.units",
+}
+
+// listedDocument is a document in a list along with its progress.
+type listedDocument struct {
+	*document
+	Units  int
+	Scored int
+}
+
+func (ld *listedDocument) Percent() float64 {
+	return percent(ld.Scored, ld.Units)
+}
+
+// Status is one of documentStatuses.
+func (ld *listedDocument) Status() string {
+	if ld.Scored == 0 {
+		return statusUnscored
+	} else if ld.Scored < ld.Units {
+		return statusPartial
+	}
+	return statusComplete
+}
+
+// listDocuments returns a page of the documents in the project that match
+// the options. The filtering, sorting and paging is done in the database,
+// so it takes two queries no matter how many documents there are.
+func (proj *project) listDocuments(
+	opts documentListOptions,
+) ([]*listedDocument, listPage) {
+	page := newListPage(opts.Page, opts.PerPage, opts.values())
+	args := []interface{}{proj.Owner.Id, proj.Name}
+	docConds := make([]string, 0)
+	for _, filter := range opts.Metadata {
+		pieces := strings.SplitN(filter, "=", 2)
+		if len(pieces) != 2 || len(strings.TrimSpace(pieces[0])) == 0 {
+			panic(ue("Metadata filters must look like **KEY=VALUE**, but "+
+				"got **%s**.", filter))
+		}
+		args = append(args, strings.TrimSpace(pieces[0]),
+			strings.TrimSpace(pieces[1]))
+		docConds = append(docConds, fmt.Sprintf(`
+			AND EXISTS (
+				SELECT 1 FROM document_metadata dm
+				WHERE dm.project_owner = d.project_owner
+					AND dm.project_name = d.project_name
+					AND dm.document_name = d.name
+					AND dm.document_recorded = d.recorded
+					AND dm.key = $%d AND LOWER(dm.value) = LOWER($%d)
+			)`, len(args)-1, len(args)))
+	}
+	statusCond := "TRUE"
+	if len(opts.Status) > 0 {
+		cond, ok := documentStatusSQL[opts.Status]
+		if !ok {
+			panic(ue("**%s** is not a document status.", opts.Status))
+		}
+		statusCond = cond
+	}
+	order := sortSQL(opts.Sort, documentSorts, "p.name ASC, p.recorded ASC")
+
+	from := fmt.Sprintf(`
+		FROM (
+			SELECT
+				d.name, d.recorded, d.created_by, d.created, d.modified,
+				(SELECT COUNT(*) FROM token t
+				 WHERE t.project_owner = d.project_owner
+					AND t.project_name = d.project_name
+					AND t.document_name = d.name
+					AND t.document_recorded = d.recorded)
+				* (SELECT COUNT(*) FROM document_category dc
+				 WHERE dc.project_owner = d.project_owner
+					AND dc.project_name = d.project_name
+					AND dc.document_name = d.name
+					AND dc.document_recorded = d.recorded) AS units,
+				(SELECT COUNT(*)
+				 FROM score s
+				 JOIN document_category dc
+					ON dc.project_owner = s.project_owner
+					AND dc.project_name = s.project_name
+					AND dc.document_name = s.document_name
+					AND dc.document_recorded = s.document_recorded
+					AND dc.category = s.category
+				 WHERE s.project_owner = d.project_owner
+					AND s.project_name = d.project_name
+					AND s.document_name = d.name
+					AND s.document_recorded = d.recorded) AS scored
+			FROM
+				document d
+			WHERE
+				d.project_owner = $1 AND d.project_name = $2
+				%s
+		) p
+		WHERE
+			%s
+		`, strings.Join(docConds, ""), statusCond)
+	page.Total = csql.Count(db, "SELECT COUNT(*) "+from, args...)
+
+	limit, args := page.limitSQL(args)
+	docs := make([]*listedDocument, 0, page.PerPage)
+	rows := csql.Query(db, `
+		SELECT
+			p.name, p.recorded, p.created_by, p.created, p.modified,
+			p.units, p.scored
+		`+from+`
+		ORDER BY
+			`+order+`
+		`+limit, args...)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		ld := &listedDocument{document: &document{Project: proj}}
+		var createdBy string
+		csql.Scan(row, &ld.Name, &ld.Recorded, &createdBy, &ld.Created,
+			&ld.Modified, &ld.Units, &ld.Scored)
+		ld.Display = nameToDisplay(ld.Name)
+		ld.CreatedBy = findUserByNo(createdBy)
+		docs = append(docs, ld)
+	})
+	return docs, page
+}
+
+// RecordedKey returns the recorded date of the document as it is used to
+// identify the document.
+func (d *document) RecordedKey() string {
+	return d.Recorded.Format(recordedFmt)
+}
+
+// Tokens returns the scoreable words of the document.
+func (d *document) Tokens() []token {
+	if d.tokens == nil {
+		d.tokens = tokenize(d.Content)
+	}
+	return d.tokens
+}
+
+// eventKey uniquely identifies the document among all projects.
+func (d *document) eventKey() string {
+	return fmt.Sprintf("%s/%s/%s/%s",
+		d.Project.Owner.Id, d.Project.Name, d.Name, d.RecordedKey())
+}
+
 func (d *document) isDuplicate() bool {
 	n := csql.Count(db, `
 		SELECT COUNT(*)
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// editDocumentCategories lets the owner of a project change which scoring
+// schemes are used for a document.
+//
+// Removing a scheme from a document that already has scores in it requires
+// confirmation. Those scores are not deleted, but they are ignored for as
+// long as the scheme isn't used by the document. Adding the scheme back
+// restores them.
+func editDocumentCategories(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can change the scoring " +
+			"categories of documents."))
+	}
+	show := func(cats []string, orphans []orphanedScores, msg string) {
+		w.html("document-categories", m{
+			"Nav":        documentNav(w, proj, d, "Scoring categories"),
+			"P":          proj,
+			"D":          d,
+			"Conf":       conf,
+			"Categories": cats,
+			"Orphans":    orphans,
+			"Message":    msg,
+		})
+	}
+	if w.r.Method == "GET" {
+		show(d.Categories, nil, "")
+	} else if w.r.Method == "POST" {
+		var form struct {
+			Categories []string
+			Confirm    bool
+		}
+		w.decode(&form)
+
+		cats := cleanCategories(form.Categories)
+		if err := validateCategories(cats); err != nil {
+			show(cats, nil, err.Error())
+			return
+		}
+		orphans := d.orphanedScores(cats)
+		if len(orphans) > 0 && !form.Confirm {
+			show(cats, orphans, "")
+			return
+		}
+		d.updateCategories(cats)
+		http.Redirect(w.w, w.r, w.routes.URLFor("document",
+			proj.Owner.Id, proj.Name, d.Name, d.RecordedKey()), 302)
+	} else {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+}
+
+// orphanedScores is the number of scores in a category of a document.
+type orphanedScores struct {
+	Category string
+	Count    int
+}
+
+// cleanCategories sorts categories and removes empty and duplicate entries.
+// (Unchecked boxes in a form leave empty entries.)
+func cleanCategories(cats []string) []string {
+	cleaned := make([]string, 0, len(cats))
+	seen := make(map[string]bool)
+	for _, cat := range cats {
+		if len(cat) > 0 && !seen[cat] {
+			seen[cat] = true
+			cleaned = append(cleaned, cat)
+		}
+	}
+	sort.Strings(cleaned)
+	return cleaned
+}
+
+// validateCategories checks that a document uses at least one scoring scheme
+// and that each one exists.
+func validateCategories(cats []string) error {
+	if len(cats) == 0 {
+		return ue("Documents must have at least one scoring category.")
+	}
+	for _, cat := range cats {
+		if _, ok := conf.Scores[cat]; !ok {
+			return ue("Scoring category **%s** does not exist.", cat)
+		}
+	}
+	return nil
+}
+
+// HasCategory returns true if the document is scored with the given scheme.
+func (d *document) HasCategory(cat string) bool {
+	for _, c := range d.Categories {
+		if c == cat {
+			return true
+		}
+	}
+	return false
+}
+
+// documentCategories maps the key of every document in the project (see
+// document.Key) to its categories, with a single query.
+func (proj *project) documentCategories() map[string][]string {
+	cats := make(map[string][]string)
+	rows := csql.Query(db, `
+		SELECT
+			document_name, document_recorded, category
+		FROM
+			document_category
+		WHERE
+			project_owner = $1 AND project_name = $2
+		ORDER BY
+			category ASC
+	`, proj.Owner.Id, proj.Name)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := &document{Project: proj}
+		var cat string
+		csql.Scan(row, &d.Name, &d.Recorded, &cat)
+		cats[d.Key()] = append(cats[d.Key()], cat)
+	})
+	return cats
+}
+
+func (d *document) loadCategories() []string {
+	cats := make([]string, 0)
+	rows := csql.Query(db, `
+		SELECT
+			category
+		FROM
+			document_category
+		WHERE
+			project_owner = $1 AND project_name = $2
+			AND document_name = $3 AND document_recorded = $4
+		ORDER BY
+			category ASC
+	`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var cat string
+		csql.Scan(row, &cat)
+		cats = append(cats, cat)
+	})
+	return cats
+}
+
+func (d *document) insertCategories(tx *sql.Tx) {
+	for _, cat := range d.Categories {
+		csql.Exec(tx, `
+			INSERT INTO document_category (
+				project_owner, project_name,
+				document_name, document_recorded, category
+			) VALUES ($1, $2, $3, $4, $5)
+			`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded, cat)
+	}
+}
+
+// orphanedScores returns the number of scores in each category of the
+// document that is not in `cats`. Categories without scores are omitted.
+func (d *document) orphanedScores(cats []string) []orphanedScores {
+	keep := make(map[string]bool)
+	for _, cat := range cats {
+		keep[cat] = true
+	}
+	orphans := make([]orphanedScores, 0)
+	rows := csql.Query(db, `
+		SELECT
+			category, COUNT(*)
+		FROM
+			score
+		WHERE
+			project_owner = $1 AND project_name = $2
+			AND document_name = $3 AND document_recorded = $4
+		GROUP BY
+			category
+		ORDER BY
+			category ASC
+	`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var o orphanedScores
+		csql.Scan(row, &o.Category, &o.Count)
+		if !keep[o.Category] && d.HasCategory(o.Category) {
+			orphans = append(orphans, o)
+		}
+	})
+	return orphans
+}
+
+// updateCategories replaces the scoring schemes used by the document. Every
+// page viewing the document is told to reload.
+func (d *document) updateCategories(cats []string) {
+	d.lock()
+	defer d.unlock()
+
+	d.Categories = cats
+	d.Modified = time.Now().UTC()
+	csql.Tx(db, func(tx *sql.Tx) {
+		csql.Exec(tx, `
+			DELETE FROM document_category
+			WHERE project_owner = $1 AND project_name = $2
+				AND document_name = $3 AND document_recorded = $4
+			`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+		d.insertCategories(tx)
+		csql.Exec(tx, `
+			UPDATE document
+			SET modified = $5
+			WHERE project_owner = $1 AND project_name = $2
+				AND name = $3 AND recorded = $4
+			`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+			d.Modified)
+	})
+	docEvents.publish(d, eventReload, nil)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/csql"
+)
+
+var (
+	// Metadata keys become column and variable names in exports, so they
+	// are restricted to names that every statistics package accepts.
+	reMetadataKey = regexp.MustCompile("^[a-zA-Z][a-zA-Z0-9_]*$")
+)
+
+// editDocumentMetadata lets anyone with access to a project describe a
+// document with `key: value` pairs, one per line.
+func editDocumentMetadata(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	show := func(text string, msg string) {
+		w.html("document-metadata", m{
+			"Nav":      documentNav(w, proj, d, "Metadata"),
+			"P":        proj,
+			"D":        d,
+			"Metadata": text,
+			"Message":  formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(formatMetadata(d.Metadata), "")
+	} else if w.r.Method == "POST" {
+		var form struct {
+			Metadata string
+		}
+		w.decode(&form)
+
+		meta, err := parseMetadata(form.Metadata)
+		if err != nil {
+			show(form.Metadata, err.Error())
+			return
+		}
+		d.updateMetadata(meta)
+		http.Redirect(w.w, w.r, w.routes.URLFor("document",
+			proj.Owner.Id, proj.Name, d.Name, d.RecordedKey()), 302)
+	} else {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+}
+
+// parseMetadata reads `key: value` pairs, one per line. Blank lines are
+// ignored.
+func parseMetadata(text string) (map[string]string, error) {
+	meta := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		pieces := strings.SplitN(line, ":", 2)
+		if len(pieces) != 2 {
+			return nil, ue("Line %d must have the form `key: value`.", lineno)
+		}
+		key, value := strings.TrimSpace(pieces[0]), strings.TrimSpace(pieces[1])
+		if !reMetadataKey.MatchString(key) {
+			return nil, ue("Metadata key **%s** on line %d must start with "+
+				"a letter and contain only letters, numbers and underscores.",
+				key, lineno)
+		}
+		if _, ok := meta[key]; ok {
+			return nil, ue("Metadata key **%s** is given more than once.", key)
+		}
+		meta[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
+
+func formatMetadata(meta map[string]string) string {
+	lines := make([]string, 0, len(meta))
+	for _, key := range sortedKeys(meta) {
+		lines = append(lines, fmt.Sprintf("%s: %s", key, meta[key]))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sortedKeys(meta map[string]string) []string {
+	keys := make([]string, 0, len(meta))
+	for key := range meta {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *document) loadMetadata() map[string]string {
+	meta := make(map[string]string)
+	rows := csql.Query(db, `
+		SELECT
+			key, value
+		FROM
+			document_metadata
+		WHERE
+			project_owner = $1 AND project_name = $2
+			AND document_name = $3 AND document_recorded = $4
+	`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var key, value string
+		csql.Scan(row, &key, &value)
+		meta[key] = value
+	})
+	return meta
+}
+
+// updateMetadata replaces all of the metadata of the document.
+func (d *document) updateMetadata(meta map[string]string) {
+	csql.Tx(db, func(tx *sql.Tx) {
+		d.saveMetadata(tx, meta)
+	})
+}
+
+// saveMetadata replaces all of the metadata of the document inside the
+// given transaction.
+func (d *document) saveMetadata(tx *sql.Tx, meta map[string]string) {
+	d.Metadata = meta
+	csql.Exec(tx, `
+		DELETE FROM document_metadata
+		WHERE project_owner = $1 AND project_name = $2
+			AND document_name = $3 AND document_recorded = $4
+		`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	for key, value := range meta {
+		csql.Exec(tx, `
+			INSERT INTO document_metadata (
+				project_owner, project_name,
+				document_name, document_recorded, key, value
+			) VALUES ($1, $2, $3, $4, $5, $6)
+			`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+			key, value)
+	}
+}
+
+// projectMetadata is the metadata of every document in a project. It is
+// loaded with a single query for use in exports and reports.
+type projectMetadata struct {
+	// Keys is every metadata key used by a document in the project, sorted.
+	Keys []string
+
+	// ByDocument maps a document's key (see document.Key) to its metadata.
+	ByDocument map[string]map[string]string
+}
+
+func (proj *project) metadata() *projectMetadata {
+	pm := &projectMetadata{
+		Keys:       make([]string, 0),
+		ByDocument: make(map[string]map[string]string),
+	}
+	keys := make(map[string]bool)
+	rows := csql.Query(db, `
+		SELECT
+			document_name, document_recorded, key, value
+		FROM
+			document_metadata
+		WHERE
+			project_owner = $1 AND project_name = $2
+	`, proj.Owner.Id, proj.Name)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := &document{Project: proj}
+		var key, value string
+		csql.Scan(row, &d.Name, &d.Recorded, &key, &value)
+		if pm.ByDocument[d.Key()] == nil {
+			pm.ByDocument[d.Key()] = make(map[string]string)
+		}
+		pm.ByDocument[d.Key()][key] = value
+		keys[key] = true
+	})
+	for key := range keys {
+		pm.Keys = append(pm.Keys, key)
+	}
+	sort.Strings(pm.Keys)
+	return pm
+}
+
+// values returns the metadata of a document in the order of Keys. Missing
+// values are empty.
+func (pm *projectMetadata) values(docKey string) []string {
+	vals := make([]string, len(pm.Keys))
+	for i, key := range pm.Keys {
+		vals[i] = pm.ByDocument[docKey][key]
+	}
+	return vals
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The kinds of events that can be sent to pages viewing a document.
+const (
+	eventScore = "score"
+	eventNote  = "note"
+	eventLease = "lease"
+
+	// eventReload tells a client that it missed events that can no longer
+	// be replayed, so it must reload the page to get a consistent view.
+	eventReload = "reload"
+)
+
+const (
+	// eventBacklog is the number of recent events kept for each document so
+	// that reconnecting clients can catch up.
+	eventBacklog = 500
+
+	// eventKeepAlive is how often a comment is written to an idle stream so
+	// that proxies don't close the connection.
+	eventKeepAlive = 30 * time.Second
+
+	// eventRetry is the reconnection delay (in milliseconds) suggested to
+	// clients.
+	eventRetry = 3000
+
+	// eventIdle is how long a stream is kept after its last subscriber
+	// leaves. Clients that reconnect after it is gone are told to reload.
+	eventIdle = 10 * time.Minute
+)
+
+// eventEpoch distinguishes event ids from different runs of the server.
+// Event ids are only kept in memory, so an id from a previous run can never
+// be caught up from.
+var eventEpoch = time.Now().UTC().Unix()
+
+var docEvents = &eventHub{streams: make(map[string]*eventStream)}
+
+// docEvent is a single change to a document that is pushed to every page
+// viewing it.
+type docEvent struct {
+	Seq  int64
+	Kind string
+	Data interface{}
+}
+
+func (ev docEvent) id() string {
+	return fmt.Sprintf("%d-%d", eventEpoch, ev.Seq)
+}
+
+// write sends the event to the client in the `text/event-stream` format.
+func (ev docEvent) write(w http.ResponseWriter) error {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n",
+		ev.id(), ev.Kind, data)
+	return err
+}
+
+// eventHub keeps an event stream for each document that is being viewed
+// or has changed recently.
+type eventHub struct {
+	sync.Mutex
+	streams map[string]*eventStream
+
+	// swept is when idle streams were last evicted.
+	swept time.Time
+}
+
+type eventStream struct {
+	sync.Mutex
+	seq     int64
+	backlog []docEvent
+	subs    map[chan docEvent]bool
+
+	// touched is the last time the stream was used.
+	touched time.Time
+}
+
+func (hub *eventHub) stream(d *document) *eventStream {
+	hub.Lock()
+	defer hub.Unlock()
+
+	now := time.Now()
+	if now.Sub(hub.swept) > eventIdle {
+		hub.evict(now)
+		hub.swept = now
+	}
+	key := d.eventKey()
+	s, ok := hub.streams[key]
+	if !ok {
+		s = &eventStream{subs: make(map[chan docEvent]bool)}
+		hub.streams[key] = s
+	}
+	s.Lock()
+	s.touched = now
+	s.Unlock()
+	return s
+}
+
+// evict forgets streams that have had no subscribers and haven't been used
+// for eventIdle, so that the backlog of every document ever viewed isn't
+// kept until the server restarts. The hub must be locked.
+func (hub *eventHub) evict(now time.Time) {
+	for key, s := range hub.streams {
+		s.Lock()
+		idle := len(s.subs) == 0 && now.Sub(s.touched) > eventIdle
+		s.Unlock()
+		if idle {
+			delete(hub.streams, key)
+		}
+	}
+}
+
+// publish sends an event to every subscriber of the document's stream and
+// records it in the stream's backlog.
+//
+// Slow subscribers never block a publisher. If a subscriber's buffer is
+// full, then it is dropped and its client will reconnect and catch up from
+// the backlog.
+func (hub *eventHub) publish(d *document, kind string, data interface{}) {
+	s := hub.stream(d)
+	s.Lock()
+	defer s.Unlock()
+
+	s.seq++
+	ev := docEvent{Seq: s.seq, Kind: kind, Data: data}
+	s.backlog = append(s.backlog, ev)
+	if len(s.backlog) > eventBacklog {
+		s.backlog = s.backlog[len(s.backlog)-eventBacklog:]
+	}
+	for sub := range s.subs {
+		select {
+		case sub <- ev:
+		default:
+			delete(s.subs, sub)
+			close(sub)
+		}
+	}
+}
+
+// subscribe returns a channel of new events for the document along with
+// every event in the backlog after `lastId`. If the events after `lastId`
+// are no longer available, then the returned backlog consists of a single
+// reload event.
+//
+// The caller must call unsubscribe with the returned channel when done.
+func (hub *eventHub) subscribe(
+	d *document,
+	lastId string,
+) ([]docEvent, chan docEvent) {
+	s := hub.stream(d)
+	s.Lock()
+	defer s.Unlock()
+
+	sub := make(chan docEvent, 64)
+	s.subs[sub] = true
+
+	if len(lastId) == 0 {
+		return nil, sub
+	}
+	reload := []docEvent{{Seq: s.seq, Kind: eventReload, Data: nil}}
+	epoch, seq, ok := parseEventId(lastId)
+	if !ok || epoch != eventEpoch || seq > s.seq {
+		return reload, sub
+	}
+	if seq == s.seq {
+		return nil, sub
+	}
+	if len(s.backlog) == 0 || s.backlog[0].Seq > seq+1 {
+		return reload, sub
+	}
+	missed := make([]docEvent, 0, s.seq-seq)
+	for _, ev := range s.backlog {
+		if ev.Seq > seq {
+			missed = append(missed, ev)
+		}
+	}
+	return missed, sub
+}
+
+// lastId returns the id of the most recent event for the document. Pages
+// should fetch this before loading the document's state and pass it when
+// they first connect, so that no changes in between are missed.
+func (hub *eventHub) lastId(d *document) string {
+	s := hub.stream(d)
+	s.Lock()
+	defer s.Unlock()
+	return docEvent{Seq: s.seq}.id()
+}
+
+func (hub *eventHub) unsubscribe(d *document, sub chan docEvent) {
+	s := hub.stream(d)
+	s.Lock()
+	defer s.Unlock()
+
+	if s.subs[sub] {
+		delete(s.subs, sub)
+		close(sub)
+	}
+}
+
+func parseEventId(id string) (epoch, seq int64, ok bool) {
+	pieces := strings.SplitN(id, "-", 2)
+	if len(pieces) != 2 {
+		return 0, 0, false
+	}
+	var err1, err2 error
+	epoch, err1 = strconv.ParseInt(pieces[0], 10, 64)
+	seq, err2 = strconv.ParseInt(pieces[1], 10, 64)
+	return epoch, seq, err1 == nil && err2 == nil
+}
+
+// documentEvents streams changes to a document as Server-Sent Events.
+//
+// Browsers reconnect automatically and send the id of the last event they
+// saw in the `Last-Event-ID` header, which is used to replay anything that
+// was missed.
+func documentEvents(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+
+	flusher, ok := w.w.(http.Flusher)
+	if !ok {
+		panic(ef("Streaming responses are not supported."))
+	}
+	lastId := w.r.Header.Get("Last-Event-ID")
+	if len(lastId) == 0 {
+		lastId = w.r.URL.Query().Get("lastEventId")
+	}
+	missed, sub := docEvents.subscribe(d, lastId)
+	defer docEvents.unsubscribe(d, sub)
+
+	w.w.Header().Set("Content-Type", "text/event-stream")
+	w.w.Header().Set("Cache-Control", "no-cache")
+	w.w.Header().Set("X-Accel-Buffering", "no")
+	w.w.WriteHeader(200)
+	fmt.Fprintf(w.w, "retry: %d\n\n", eventRetry)
+	for _, ev := range missed {
+		if ev.write(w.w) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	var closed <-chan bool
+	if cn, ok := w.w.(http.CloseNotifier); ok {
+		closed = cn.CloseNotify()
+	}
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-sub:
+			if !ok {
+				// We were dropped for being too slow. The client will
+				// reconnect and catch up.
+				return
+			}
+			if ev.write(w.w) != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w.w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+		flusher.Flush()
+	}
+}
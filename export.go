@@ -0,0 +1,273 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+	"github.com/gorilla/schema"
+)
+
+var queryDecoder = newQueryDecoder()
+
+func newQueryDecoder() *schema.Decoder {
+	dec := schema.NewDecoder()
+	dec.IgnoreUnknownKeys(true)
+	return dec
+}
+
+// decodeQuery decodes the query string of a GET request into `v`.
+func (w *web) decodeQuery(v interface{}) {
+	if err := queryDecoder.Decode(v, w.r.URL.Query()); err != nil {
+		panic(ue("Could not read the request: %s", err))
+	}
+}
+
+// exportPage shows the options for exporting the data in a project.
+func exportPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	w.html("export", m{
+		"Title":     "Export " + proj.Display,
+		"Nav":       documentNav(w, proj, nil, "Export"),
+		"P":         proj,
+		"Documents": proj.documents(),
+		"Coders":    proj.members(),
+		"Conf":      conf,
+	})
+}
+
+// exportFilter restricts the scores included in an export. An empty list
+// means no restriction.
+type exportFilter struct {
+	// Documents are document keys as returned by document.Key.
+	Documents []string
+
+	// Coders are the ids of the users who created the scores.
+	Coders []string
+
+	// Schemes are names of scoring schemes.
+	Schemes []string
+
+	// Categories are keys of categories in any scoring scheme.
+	Categories []string
+}
+
+// where returns the conditions for the filter on the `score` table (aliased
+// to `s`). Placeholders are numbered starting after `args`, and the new
+// arguments are appended to it.
+func (f exportFilter) where(args []interface{}) (string, []interface{}) {
+	conds := make([]string, 0)
+	placeholder := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	in := func(column string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		phs := make([]string, len(values))
+		for i, v := range values {
+			phs[i] = placeholder(v)
+		}
+		conds = append(conds,
+			fmt.Sprintf("%s IN (%s)", column, strings.Join(phs, ", ")))
+	}
+	docs, args := f.documentsWhere(
+		"s.document_name", "s.document_recorded", args)
+	conds = append(conds, docs)
+	in("s.created_by", f.Coders)
+	in("s.category", f.Schemes)
+	in("s.name", f.Categories)
+	return strings.Join(conds, " AND "), args
+}
+
+// documentsWhere returns the condition for the filter's documents on the
+// given name and recorded date columns. Like where, it appends its
+// arguments to `args`.
+func (f exportFilter) documentsWhere(
+	nameColumn, recordedColumn string,
+	args []interface{},
+) (string, []interface{}) {
+	if len(f.Documents) == 0 {
+		return "TRUE", args
+	}
+	pairs := make([]string, len(f.Documents))
+	for i, key := range f.Documents {
+		name, recorded, err := parseDocumentKey(key)
+		assert(err)
+		args = append(args, name, recorded)
+		pairs[i] = fmt.Sprintf("($%d, $%d::date)", len(args)-1, len(args))
+	}
+	return fmt.Sprintf("(%s, %s) IN (%s)",
+		nameColumn, recordedColumn, strings.Join(pairs, ", ")), args
+}
+
+// scoreRow is a single scored word as it appears in exports.
+type scoreRow struct {
+	Document *document
+	Word     int
+	Surface  string
+	Scheme   string
+	Category string
+	Coder    string
+	Created  time.Time
+}
+
+// Value returns the value of the row's category in its scoring scheme as a
+// string. It is empty if the category is no longer in the configuration.
+func (row scoreRow) Value() string {
+	cat, ok := conf.Scores[row.Scheme].Categories[row.Category]
+	if !ok {
+		return ""
+	}
+	return strconv.Itoa(cat.Value)
+}
+
+// eachScore calls `do` for every score in the project that passes the
+// filter, ordered by document, word and scheme. Scores in a scheme that
+// was removed from their document are skipped.
+//
+// Rows are read from the database as they are needed, so that exports of
+// large projects can be streamed.
+func (proj *project) eachScore(f exportFilter, do func(scoreRow)) {
+	proj.eachExportedDocument(f, func(d *document, rows []scoreRow) {
+		for _, row := range rows {
+			do(row)
+		}
+	})
+}
+
+// eachExportedDocument calls `do` for every document that passes the
+// filter, with its content, categories and metadata, along with its scores
+// that pass the filter. Documents are ordered by name and recorded date.
+//
+// Documents and scores are each read with a single query, and are streamed
+// side by side a document at a time.
+func (proj *project) eachExportedDocument(
+	f exportFilter,
+	do func(d *document, rows []scoreRow),
+) {
+	categories := proj.documentCategories()
+	meta := proj.metadata()
+	scores := proj.scoreCursor(f)
+	defer scores.close()
+
+	where, args := f.documentsWhere("name", "recorded",
+		[]interface{}{proj.Owner.Id, proj.Name})
+	rows := csql.Query(db, `
+		SELECT
+			name, recorded, content, created_by, created, modified
+		FROM
+			document
+		WHERE
+			project_owner = $1 AND project_name = $2
+			AND `+where+`
+		ORDER BY
+			name ASC, recorded ASC
+	`, args...)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := &document{Project: proj}
+		var createdBy string
+		csql.Scan(row, &d.Name, &d.Recorded, &d.Content, &createdBy,
+			&d.Created, &d.Modified)
+		d.Display = nameToDisplay(d.Name)
+		d.CreatedBy = findUserByNo(createdBy)
+		d.Categories = categories[d.Key()]
+		if d.Categories == nil {
+			d.Categories = make([]string, 0)
+		}
+		d.Metadata = meta.ByDocument[d.Key()]
+		if d.Metadata == nil {
+			d.Metadata = make(map[string]string)
+		}
+		do(d, scores.take(d))
+	})
+}
+
+// scoreCursor reads the scores that pass an export filter in the same order
+// as documents are listed, so that the scores of each document can be
+// taken in turn.
+type scoreCursor struct {
+	rows *sql.Rows
+
+	// next is the score that was read last but not yet taken, along with
+	// the name and recorded date of its document. It is nil once every
+	// score has been read.
+	next     *scoreRow
+	name     string
+	recorded time.Time
+}
+
+func (proj *project) scoreCursor(f exportFilter) *scoreCursor {
+	where, args := f.where([]interface{}{proj.Owner.Id, proj.Name})
+	c := &scoreCursor{rows: csql.Query(db, `
+		SELECT
+			s.document_name, s.document_recorded, s.word,
+			s.category, s.name, s.created_by, s.created
+		FROM
+			score s
+		JOIN
+			document_category dc
+			ON s.project_owner = dc.project_owner
+			AND s.project_name = dc.project_name
+			AND s.document_name = dc.document_name
+			AND s.document_recorded = dc.document_recorded
+			AND s.category = dc.category
+		WHERE
+			s.project_owner = $1 AND s.project_name = $2
+			AND `+where+`
+		ORDER BY
+			s.document_name ASC, s.document_recorded ASC,
+			s.word ASC, s.category ASC
+	`, args...)}
+	c.read()
+	return c
+}
+
+func (c *scoreCursor) read() {
+	if !c.rows.Next() {
+		assert(c.rows.Err())
+		c.next = nil
+		return
+	}
+	c.next = &scoreRow{}
+	csql.Scan(c.rows, &c.name, &c.recorded, &c.next.Word,
+		&c.next.Scheme, &c.next.Category, &c.next.Coder, &c.next.Created)
+}
+
+// take returns the scores of `d`. Documents must be taken in order of name
+// and recorded date.
+func (c *scoreCursor) take(d *document) []scoreRow {
+	rows := make([]scoreRow, 0)
+	toks := d.Tokens()
+	for c.next != nil && c.name == d.Name && c.recorded.Equal(d.Recorded) {
+		row := *c.next
+		row.Document = d
+		if row.Word < len(toks) {
+			row.Surface = toks[row.Word].Text
+		}
+		rows = append(rows, row)
+		c.read()
+	}
+	return rows
+}
+
+func (c *scoreCursor) close() {
+	assert(c.rows.Close())
+}
+
+// members returns the owner of the project followed by its collaborators.
+func (proj *project) members() []*lcmUser {
+	return append([]*lcmUser{proj.Owner}, proj.Collaborators()...)
+}
+
+// attachment sets the headers for a download with the given file name and
+// content type.
+func (w *web) attachment(name, contentType string) {
+	w.w.Header().Set("Content-Type", contentType)
+	w.w.Header().Set("Content-Disposition",
+		fmt.Sprintf("attachment; filename=\"%s\"", name))
+}
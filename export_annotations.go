@@ -0,0 +1,482 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The formats for sharing scores with other annotation tools are written
+// from the same filter as the other exports of scores. Every document that
+// passes the filter is included, even if none of its scores do, so that
+// the data can be rebuilt. The definitions of the scoring schemes are
+// included too.
+
+// exportCoNLLU downloads a zip archive of the project's documents as a
+// single CoNLL-U file, along with `schemes.json`. See writeCoNLLU.
+func exportCoNLLU(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+
+	w.attachment(proj.Name+"-conllu.zip", "application/zip")
+	zw := zip.NewWriter(w.w)
+	out := zipCreate(zw, proj.Name+".conllu")
+	schemes := make(map[string]bool)
+	proj.eachExportedDocument(filter, func(d *document, rows []scoreRow) {
+		addSchemes(schemes, filter, d)
+		writeCoNLLU(out, d, rows)
+	})
+	writeSchemesJSON(zipCreate(zw, "schemes.json"), schemes)
+	assert(zw.Close())
+}
+
+// exportBrat downloads a zip archive of the project's documents in BRAT
+// standoff format, with a text file and an annotation file for each. Each
+// score is a text-bound annotation whose type is its category, with its
+// scheme as an attribute and its coder in a note. The archive includes the
+// `annotation.conf` and `visual.conf` that BRAT needs to show the
+// annotations, and `schemes.json`.
+func exportBrat(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+
+	w.attachment(proj.Name+"-brat.zip", "application/zip")
+	zw := zip.NewWriter(w.w)
+	schemes := make(map[string]bool)
+	proj.eachExportedDocument(filter, func(d *document, rows []scoreRow) {
+		addSchemes(schemes, filter, d)
+		base := d.Name + "_" + d.RecordedKey()
+		_, err := io.WriteString(zipCreate(zw, base+".txt"), d.Content)
+		assert(err)
+		writeBratAnnotations(zipCreate(zw, base+".ann"), d, rows)
+	})
+	annConf, visualConf := bratConf(schemes)
+	_, err := io.WriteString(zipCreate(zw, "annotation.conf"), annConf)
+	assert(err)
+	_, err = io.WriteString(zipCreate(zw, "visual.conf"), visualConf)
+	assert(err)
+	writeSchemesJSON(zipCreate(zw, "schemes.json"), schemes)
+	assert(zw.Close())
+}
+
+// exportWebAnnotations downloads the scores of the project as a W3C Web
+// Annotation collection in JSON-LD. Each score is an annotation that
+// classifies a word of a document (selected by its position and text) with
+// a category. Scoring schemes are described as SKOS concept schemes, with
+// a concept for each category, and the documents are included with their
+// text.
+//
+// The collection is streamed: documents are written in a first pass over
+// the project and annotations in a second, so that neither is held in
+// memory. The schemes and the number of annotations come last, since they
+// are only known at the end.
+func exportWebAnnotations(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+
+	w.attachment(proj.Name+"-annotations.jsonld", "application/ld+json")
+	out := bufio.NewWriter(w.w)
+	write := func(s string) {
+		_, err := out.WriteString(s)
+		assert(err)
+	}
+	// item writes one member of an object or array, indented by `indent`
+	// levels and preceded by a comma unless it is the first.
+	item := func(first bool, indent int, key string, v interface{}) {
+		prefix := strings.Repeat("  ", indent)
+		bs, err := json.MarshalIndent(v, prefix, "  ")
+		assert(err)
+		if !first {
+			write(",")
+		}
+		write("\n" + prefix)
+		if len(key) > 0 {
+			write(fmt.Sprintf("%q: ", key))
+		}
+		write(string(bs))
+	}
+	docIRI := func(d *document) string {
+		return w.absoluteURL(w.url("document",
+			proj.Owner.Id, proj.Name, d.Name, d.RecordedKey()))
+	}
+
+	write("{")
+	item(true, 1, "@context", []interface{}{
+		"http://www.w3.org/ns/anno.jsonld",
+		m{
+			"lcm":       w.absoluteURL("/ns#"),
+			"schemes":   "lcm:schemes",
+			"documents": "lcm:documents",
+		},
+	})
+	item(false, 1, "type", "AnnotationCollection")
+	item(false, 1, "label", "Scores of "+proj.Display)
+	item(false, 1, "generated", time.Now().UTC().Format(time.RFC3339))
+	item(false, 1, "generator", m{"type": "Software", "name": "lcmweb"})
+
+	write(",\n  \"documents\": [")
+	schemes := make(map[string]bool)
+	first := true
+	proj.eachExportedDocument(filter, func(d *document, rows []scoreRow) {
+		addSchemes(schemes, filter, d)
+		item(first, 2, "", m{
+			"id":              docIRI(d),
+			"type":            "Text",
+			"format":          "text/plain",
+			"label":           d.Display,
+			"dcterms:created": d.RecordedKey(),
+			"value":           d.Content,
+		})
+		first = false
+	})
+	write("\n  ]")
+
+	write(",\n  \"first\": {")
+	item(true, 2, "type", "AnnotationPage")
+	item(false, 2, "startIndex", 0)
+	write(",\n    \"items\": [")
+	total := 0
+	var chars []int
+	var charsOf *document
+	proj.eachScore(filter, func(row scoreRow) {
+		if charsOf != row.Document {
+			chars, charsOf = charIndexes(row.Document.Content), row.Document
+		}
+		iri := docIRI(row.Document)
+		tok := row.Document.Tokens()[row.Word]
+		item(total == 0, 3, "", m{
+			"id":         fmt.Sprintf("%s#%s-%d", iri, row.Scheme, row.Word),
+			"type":       "Annotation",
+			"motivation": "classifying",
+			"created":    row.Created.UTC().Format(time.RFC3339),
+			"creator":    webAnnotationCreator(row.Coder),
+			"body":       w.conceptIRI(row.Scheme, row.Category),
+			"target": m{
+				"source": iri,
+				"selector": []m{
+					{
+						"type":  "TextPositionSelector",
+						"start": chars[tok.Start],
+						"end":   chars[tok.End],
+					},
+					{"type": "TextQuoteSelector", "exact": tok.Text},
+				},
+			},
+		})
+		total++
+	})
+	write("\n    ]\n  }")
+	item(false, 1, "total", total)
+
+	conceptSchemes := make([]m, 0)
+	for _, def := range schemeDefinitions(schemes) {
+		concepts := make([]m, len(def.Categories))
+		for i, cat := range def.Categories {
+			concepts[i] = m{
+				"id":              w.conceptIRI(def.Name, cat.Key),
+				"type":            "skos:Concept",
+				"skos:notation":   cat.Key,
+				"skos:prefLabel":  cat.Name,
+				"rdf:value":       cat.Value,
+				"skos:definition": cat.Guidelines,
+				"skos:example":    cat.Examples,
+			}
+		}
+		conceptSchemes = append(conceptSchemes, m{
+			"id":                 w.absoluteURL(w.url("codebook", def.Name)),
+			"type":               "skos:ConceptScheme",
+			"skos:prefLabel":     def.Name,
+			"owl:versionInfo":    def.Version,
+			"skos:hasTopConcept": concepts,
+		})
+	}
+	item(false, 1, "schemes", conceptSchemes)
+	write("\n}\n")
+	assert(out.Flush())
+}
+
+// absoluteURL returns the absolute URL of a path on this server, for
+// identifiers in exports.
+func (w *web) absoluteURL(path string) string {
+	scheme := "http"
+	if w.r.TLS != nil || w.r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + w.r.Host + path
+}
+
+// conceptIRI identifies a category of a scoring scheme by its entry in the
+// scheme's codebook.
+func (w *web) conceptIRI(scheme, category string) string {
+	return w.absoluteURL(w.url("codebook", scheme)) + "#" + category
+}
+
+// webAnnotationCreator describes the coder of a score.
+func webAnnotationCreator(coder string) m {
+	name := coder
+	if u := findUserByNo(coder); u != nil {
+		name = u.Name
+	}
+	return m{"type": "Person", "nickname": coder, "name": name}
+}
+
+// addSchemes adds the schemes a document is scored with to `schemes`,
+// unless the filter leaves them out.
+func addSchemes(schemes map[string]bool, f exportFilter, d *document) {
+	for _, name := range d.Categories {
+		if len(f.Schemes) == 0 || thContains(f.Schemes, name) {
+			schemes[name] = true
+		}
+	}
+}
+
+// schemeDefinition describes a scoring scheme in exports, so that the
+// categories of scores can be understood without lcmweb.
+type schemeDefinition struct {
+	Name       string
+	Version    string
+	Categories []categoryDefinition
+}
+
+// categoryDefinition is a category of a scheme in a schemeDefinition.
+type categoryDefinition struct {
+	Key        string
+	Name       string
+	Value      int
+	Color      string
+	Guidelines string
+	Examples   []string
+}
+
+// schemeDefinitions describes the named scoring schemes, ordered by name.
+func schemeDefinitions(names map[string]bool) []schemeDefinition {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	defs := make([]schemeDefinition, 0, len(sorted))
+	for _, name := range sorted {
+		scheme := conf.Scores[name]
+		def := schemeDefinition{
+			Name:       name,
+			Version:    scheme.VersionString(),
+			Categories: make([]categoryDefinition, 0),
+		}
+		for _, cat := range scheme.Ordered() {
+			def.Categories = append(def.Categories, categoryDefinition{
+				Key:        cat.Key,
+				Name:       cat.Name,
+				Value:      cat.Value,
+				Color:      cat.Color,
+				Guidelines: cat.Guidelines,
+				Examples:   cat.Examples,
+			})
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// writeSchemesJSON writes the definitions of the named schemes as JSON.
+func writeSchemesJSON(out io.Writer, names map[string]bool) {
+	bs, err := json.MarshalIndent(schemeDefinitions(names), "", "  ")
+	assert(err)
+	_, err = out.Write(append(bs, '\n'))
+	assert(err)
+}
+
+// zipCreate adds a file to a zip archive.
+func zipCreate(zw *zip.Writer, name string) io.Writer {
+	f, err := zw.Create(name)
+	assert(err)
+	return f
+}
+
+// charIndexes maps the byte offset of every character in `content`, and of
+// its end, to the number of code points before it. Other tools count
+// characters rather than bytes.
+func charIndexes(content string) []int {
+	idx := make([]int, len(content)+1)
+	n := 0
+	for i := range content {
+		idx[i] = n
+		n++
+	}
+	idx[len(content)] = n
+	return idx
+}
+
+// writeCoNLLU writes a document in CoNLL-U format. Its words are the
+// tokenizer's words, and the punctuation between them is split into tokens
+// of its own with the PUNCT tag. A sentence ends after punctuation with a
+// full stop, question mark or exclamation mark.
+//
+// The score of a word in each scheme is in the MISC column as an attribute
+// named after the scheme, such as `sentiment=positive`, and lemmas are
+// those used for concordances. The document starts with a `newdoc`
+// comment, followed by its recorded date and metadata.
+func writeCoNLLU(out io.Writer, d *document, rows []scoreRow) {
+	sentences := conlluSentences(d)
+	if len(sentences) == 0 {
+		return
+	}
+	scores := make(map[int][]scoreRow)
+	for _, row := range rows {
+		scores[row.Word] = append(scores[row.Word], row)
+	}
+
+	pr := func(format string, v ...interface{}) {
+		_, err := fmt.Fprintf(out, format, v...)
+		assert(err)
+	}
+	id := d.Name + "_" + d.RecordedKey()
+	pr("# newdoc id = %s\n", id)
+	pr("# recorded = %s\n", d.RecordedKey())
+	keys := make([]string, 0, len(d.Metadata))
+	for key := range d.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pr("# meta_%s = %s\n", key, singleLine(d.Metadata[key]))
+	}
+	for i, sent := range sentences {
+		text := d.Content[sent[0].Start:sent[len(sent)-1].End]
+		pr("# sent_id = %s-%d\n", id, i+1)
+		pr("# text = %s\n", singleLine(text))
+		for j, tok := range sent {
+			form := d.Content[tok.Start:tok.End]
+			lemma, upos := "_", "PUNCT"
+			misc := make([]string, 0)
+			if tok.Index >= 0 {
+				lemma, upos = lemmatize(form), "_"
+				for _, row := range scores[tok.Index] {
+					misc = append(misc, row.Scheme+"="+row.Category)
+				}
+			}
+			if j+1 < len(sent) && sent[j+1].Start == tok.End {
+				misc = append(misc, "SpaceAfter=No")
+			}
+			if len(misc) == 0 {
+				misc = append(misc, "_")
+			}
+			pr("%d\t%s\t%s\t%s\t_\t_\t_\t_\t_\t%s\n", j+1, form, lemma, upos,
+				strings.Join(misc, "|"))
+		}
+		pr("\n")
+	}
+}
+
+// singleLine puts text on a single line for a CoNLL-U comment.
+func singleLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+// conlluSentences splits a document into sentences of tokens. Words are
+// the tokenizer's words, and punctuation between them has an index of -1.
+func conlluSentences(d *document) [][]token {
+	all := make([]token, 0)
+	punct := func(start, end int) {
+		// The punctuation between two words is split at white space.
+		field := -1
+		for i, r := range d.Content[start:end] {
+			if unicode.IsSpace(r) {
+				if field > -1 {
+					all = append(all, token{-1, "", start + field, start + i})
+				}
+				field = -1
+			} else if field == -1 {
+				field = i
+			}
+		}
+		if field > -1 {
+			all = append(all, token{-1, "", start + field, end})
+		}
+	}
+	cursor := 0
+	for _, tok := range d.Tokens() {
+		punct(cursor, tok.Start)
+		all = append(all, tok)
+		cursor = tok.End
+	}
+	punct(cursor, len(d.Content))
+
+	sentences := make([][]token, 0)
+	start := 0
+	for i, tok := range all {
+		if tok.Index == -1 &&
+			strings.ContainsAny(d.Content[tok.Start:tok.End], ".?!") {
+			sentences = append(sentences, all[start:i+1])
+			start = i + 1
+		}
+	}
+	if start < len(all) {
+		sentences = append(sentences, all[start:])
+	}
+	return sentences
+}
+
+// writeBratAnnotations writes the scores of a document as BRAT text-bound
+// annotations.
+func writeBratAnnotations(out io.Writer, d *document, rows []scoreRow) {
+	pr := func(format string, v ...interface{}) {
+		_, err := fmt.Fprintf(out, format, v...)
+		assert(err)
+	}
+	toks := d.Tokens()
+	chars := charIndexes(d.Content)
+	for i, row := range rows {
+		tok := toks[row.Word]
+		n := i + 1
+		pr("T%d\t%s %d %d\t%s\n", n, row.Category,
+			chars[tok.Start], chars[tok.End], tok.Text)
+		pr("A%d\tScheme T%d %s\n", n, n, row.Scheme)
+		pr("#%d\tAnnotatorNotes T%d\tcoder %s\n", n, n, row.Coder)
+	}
+}
+
+// bratConf returns the BRAT annotation and visual configuration for the
+// categories of the named schemes. Categories are entity types named by
+// their keys, so a key used by more than one scheme is a single type.
+func bratConf(names map[string]bool) (annConf, visualConf string) {
+	var ann, visual strings.Builder
+	ann.WriteString("[entities]\n")
+	visual.WriteString("[labels]\n")
+	var drawing strings.Builder
+	seen := make(map[string]bool)
+	defs := schemeDefinitions(names)
+	schemes := make([]string, len(defs))
+	for i, def := range defs {
+		schemes[i] = def.Name
+		for _, cat := range def.Categories {
+			if seen[cat.Key] {
+				continue
+			}
+			seen[cat.Key] = true
+			fmt.Fprintf(&ann, "%s\n", cat.Key)
+			fmt.Fprintf(&visual, "%s | %s\n", cat.Key, cat.Name)
+			if len(cat.Color) > 0 {
+				fmt.Fprintf(&drawing, "%s\tbgColor:%s\n", cat.Key, cat.Color)
+			}
+		}
+	}
+	ann.WriteString("\n[relations]\n\n[events]\n\n[attributes]\n")
+	if len(schemes) > 0 {
+		fmt.Fprintf(&ann, "Scheme\tArg:<ENTITY>, Value:%s\n",
+			strings.Join(schemes, "|"))
+	}
+	visual.WriteString("\n[drawing]\n")
+	visual.WriteString(drawing.String())
+	return ann.String(), visual.String()
+}
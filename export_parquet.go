@@ -0,0 +1,134 @@
+package main
+
+// exportSummaryParquet writes the same per-document summary as exportSummary
+// as a Parquet file. The scheme, its version and the layer are also stored
+// in the file's key/value metadata.
+func exportSummaryParquet(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts summaryOptions
+	w.decodeQuery(&opts)
+	scheme := opts.scheme()
+	meta := proj.metadata()
+	sums := proj.summaries(opts)
+
+	cols := []parquetColumn{
+		parquetString("scheme", false, true),
+		parquetString("scheme_version", false, true),
+		parquetString("layer", false, true),
+		parquetString("document", false, false),
+		parquetDateColumn("recorded"),
+		parquetInt("tokens", false),
+		parquetInt("scored", false),
+	}
+	for _, cat := range scheme.Ordered() {
+		cols = append(cols, parquetInt("n_"+cat.Key, false))
+	}
+	for _, cat := range scheme.Ordered() {
+		cols = append(cols, parquetFloat("p_"+cat.Key, false))
+	}
+	cols = append(cols, parquetFloat("abstraction", true))
+	cols = append(cols, parquetMetadataColumns(meta)...)
+
+	w.attachment(summaryFileName(proj, opts, "parquet"),
+		"application/vnd.apache.parquet")
+	pq := newParquetWriter(w.w, cols, map[string]string{
+		"lcmweb.project":        proj.Owner.Id + "/" + proj.Name,
+		"lcmweb.scheme":         opts.Scheme,
+		"lcmweb.scheme_version": scheme.VersionString(),
+		"lcmweb.layer":          opts.Layer(),
+	})
+	for _, sum := range sums {
+		values := []interface{}{
+			opts.Scheme, scheme.VersionString(), opts.Layer(),
+			sum.Document.Display, sum.Document.Recorded,
+			sum.Tokens, sum.Scored(),
+		}
+		for _, cat := range scheme.Ordered() {
+			values = append(values, sum.Counts[cat.Key])
+		}
+		for _, cat := range scheme.Ordered() {
+			values = append(values, sum.Proportion(cat.Key))
+		}
+		if abs, ok := sum.Abstraction(); ok {
+			values = append(values, abs)
+		} else {
+			values = append(values, nil)
+		}
+		values = append(values,
+			parquetMetadataValues(meta, sum.Document.Key())...)
+		assert(pq.writeRow(values...))
+	}
+	assert(pq.close())
+}
+
+// exportScoresParquet writes the same long-format scores as exportScoresCSV
+// as a Parquet file. Columns with few distinct values (documents, schemes,
+// categories, coders and metadata) are dictionary encoded.
+func exportScoresParquet(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+	meta := proj.metadata()
+
+	cols := []parquetColumn{
+		parquetString("document", false, true),
+		parquetDateColumn("recorded"),
+		parquetInt("word", false),
+		parquetString("surface", false, false),
+		parquetString("scheme", false, true),
+		parquetString("scheme_version", false, true),
+		parquetString("category", false, true),
+		parquetInt("value", true),
+		parquetString("coder", false, true),
+		parquetTimestamp("timestamp"),
+	}
+	cols = append(cols, parquetMetadataColumns(meta)...)
+
+	w.attachment(proj.Name+"-scores.parquet", "application/vnd.apache.parquet")
+	pq := newParquetWriter(w.w, cols, map[string]string{
+		"lcmweb.project": proj.Owner.Id + "/" + proj.Name,
+	})
+	proj.eachScore(filter, func(row scoreRow) {
+		values := []interface{}{
+			row.Document.Display,
+			row.Document.Recorded,
+			row.Word,
+			row.Surface,
+			row.Scheme,
+			conf.Scores[row.Scheme].VersionString(),
+			row.Category,
+		}
+		if cat, ok := conf.Scores[row.Scheme].Categories[row.Category]; ok {
+			values = append(values, cat.Value)
+		} else {
+			values = append(values, nil)
+		}
+		values = append(values, row.Coder, row.Created)
+		values = append(values,
+			parquetMetadataValues(meta, row.Document.Key())...)
+		assert(pq.writeRow(values...))
+	})
+	assert(pq.close())
+}
+
+// parquetMetadataColumns returns an optional, dictionary encoded column for
+// each metadata key in the project.
+func parquetMetadataColumns(meta *projectMetadata) []parquetColumn {
+	cols := make([]parquetColumn, len(meta.Keys))
+	for i, key := range meta.Keys {
+		cols[i] = parquetString("meta_"+key, true, true)
+	}
+	return cols
+}
+
+// parquetMetadataValues returns a document's metadata in the same order as
+// parquetMetadataColumns. Missing values are nil.
+func parquetMetadataValues(meta *projectMetadata, docKey string) []interface{} {
+	vals := make([]interface{}, len(meta.Keys))
+	for i, val := range meta.values(docKey) {
+		if len(val) > 0 {
+			vals[i] = val
+		}
+	}
+	return vals
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/csv"
+	"strconv"
+	"time"
+)
+
+// exportScoresCSV streams the scores of a project in long format: one row
+// per scored word per scoring scheme. Each row names the version of its
+// scheme and the coder of its score, and ends with the metadata of its
+// document.
+func exportScoresCSV(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+	meta := proj.metadata()
+
+	w.attachment(proj.Name+"-scores.csv", "text/csv; charset=utf-8")
+	out := csv.NewWriter(w.w)
+	header := []string{
+		"document", "recorded", "word", "surface", "scheme",
+		"scheme_version", "category", "value", "coder", "timestamp",
+	}
+	for _, key := range meta.Keys {
+		header = append(header, "meta_"+key)
+	}
+	assert(out.Write(header))
+
+	n := 0
+	proj.eachScore(filter, func(row scoreRow) {
+		record := []string{
+			row.Document.Display,
+			row.Document.RecordedKey(),
+			strconv.Itoa(row.Word),
+			row.Surface,
+			row.Scheme,
+			conf.Scores[row.Scheme].VersionString(),
+			row.Category,
+			row.Value(),
+			row.Coder,
+			row.Created.UTC().Format(time.RFC3339),
+		}
+		record = append(record, meta.values(row.Document.Key())...)
+		assert(out.Write(record))
+
+		// Send rows to the client as we go instead of buffering the whole
+		// export.
+		if n++; n%1000 == 0 {
+			out.Flush()
+			assert(out.Error())
+		}
+	})
+	out.Flush()
+	assert(out.Error())
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+)
+
+// exportSummarySPSS writes the same per-document summary as exportSummary
+// as an SPSS system file.
+func exportSummarySPSS(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts summaryOptions
+	w.decodeQuery(&opts)
+	scheme := opts.scheme()
+	meta := proj.metadata()
+	enums := spssMetadataEnums(meta)
+	sums := proj.summaries(opts)
+
+	vars := []spssVar{
+		spssString("scheme", "Scoring scheme", 64),
+		spssString("scheme_version", "Version of the scoring scheme", 64),
+		spssString("layer", "Scores the summary was computed from", 64),
+		spssString("document", "Document name", 100),
+		spssDate("recorded", "Date the document was recorded"),
+		spssNumeric("tokens", "Number of words in the document", 8, 0),
+		spssNumeric("scored", "Number of scored words", 8, 0),
+	}
+	for _, cat := range scheme.Ordered() {
+		vars = append(vars, spssNumeric(spssName("n_"+cat.Key),
+			"Number of words scored "+cat.Name, 8, 0))
+	}
+	for _, cat := range scheme.Ordered() {
+		vars = append(vars, spssNumeric(spssName("p_"+cat.Key),
+			"Proportion of scored words that are "+cat.Name, 8, 4))
+	}
+	vars = append(vars, spssNumeric("abstraction", "Abstraction index", 8, 4))
+	vars = append(vars, enums.vars()...)
+
+	w.attachment(summaryFileName(proj, opts, "sav"),
+		"application/x-spss-sav")
+	label := fmt.Sprintf("%s: %s %s, %s", proj.Display,
+		opts.Scheme, scheme.VersionString(), opts.Layer())
+	sav, err := newSPSSWriter(w.w, label, vars)
+	assert(err)
+	for _, sum := range sums {
+		values := []interface{}{
+			opts.Scheme, scheme.VersionString(), opts.Layer(),
+			sum.Document.Display, spssTime(sum.Document.Recorded),
+			sum.Tokens, sum.Scored(),
+		}
+		for _, cat := range scheme.Ordered() {
+			values = append(values, sum.Counts[cat.Key])
+		}
+		for _, cat := range scheme.Ordered() {
+			values = append(values, sum.Proportion(cat.Key))
+		}
+		if abs, ok := sum.Abstraction(); ok {
+			values = append(values, abs)
+		} else {
+			values = append(values, nil)
+		}
+		values = append(values, enums.values(sum.Document.Key())...)
+		assert(sav.writeCase(values...))
+	}
+	assert(sav.flush())
+}
+
+// exportScoresSPSS writes the same long-format scores as exportScoresCSV as
+// an SPSS system file. Categories are coded by their position in their
+// scheme's order (across all schemes) and labeled with their names.
+func exportScoresSPSS(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var filter exportFilter
+	w.decodeQuery(&filter)
+	meta := proj.metadata()
+	enums := spssMetadataEnums(meta)
+
+	// Every scheme is coded in the same variable, so a category's code
+	// must be unique among all schemes. Labels include the scheme when
+	// there's more than one.
+	catCodes := make(map[string]map[string]float64)
+	catLabels := make([]spssValueLabel, 0)
+	for _, name := range conf.Categories() {
+		catCodes[name] = make(map[string]float64)
+		for _, cat := range conf.Scores[name].Ordered() {
+			code := float64(len(catLabels) + 1)
+			label := cat.Name
+			if len(conf.Scores) > 1 {
+				label = fmt.Sprintf("%s: %s", name, cat.Name)
+			}
+			catCodes[name][cat.Key] = code
+			catLabels = append(catLabels, spssValueLabel{code, label})
+		}
+	}
+	category := spssNumeric("category", "Category", 8, 0)
+	category.ValueLabels = catLabels
+
+	vars := []spssVar{
+		spssString("document", "Document name", 100),
+		spssDate("recorded", "Date the document was recorded"),
+		spssNumeric("word", "Index of the word in the document", 8, 0),
+		spssString("surface", "Word as it appears in the document", 64),
+		spssString("scheme", "Scoring scheme", 64),
+		spssString("scheme_version", "Version of the scoring scheme", 64),
+		category,
+		spssNumeric("value", "Value of the category", 8, 0),
+		spssString("coder", "User who scored the word", 64),
+		spssDateTime("timestamp", "Time the word was scored (UTC)"),
+	}
+	vars = append(vars, enums.vars()...)
+
+	w.attachment(proj.Name+"-scores.sav", "application/x-spss-sav")
+	sav, err := newSPSSWriter(w.w, proj.Display+": scores", vars)
+	assert(err)
+	proj.eachScore(filter, func(row scoreRow) {
+		values := []interface{}{
+			row.Document.Display,
+			spssTime(row.Document.Recorded),
+			row.Word,
+			row.Surface,
+			row.Scheme,
+			conf.Scores[row.Scheme].VersionString(),
+		}
+		if code, ok := catCodes[row.Scheme][row.Category]; ok {
+			values = append(values, code)
+		} else {
+			values = append(values, nil)
+		}
+		if cat, ok := conf.Scores[row.Scheme].Categories[row.Category]; ok {
+			values = append(values, cat.Value)
+		} else {
+			values = append(values, nil)
+		}
+		values = append(values, row.Coder, spssTime(row.Created))
+		values = append(values, enums.values(row.Document.Key())...)
+		assert(sav.writeCase(values...))
+	})
+	assert(sav.flush())
+}
+
+// spssEnums codes the values of each metadata key as numbers with value
+// labels, so that they can be used as factors in SPSS.
+type spssEnums struct {
+	meta      *projectMetadata
+	codes     []map[string]float64
+	variables []spssVar
+}
+
+func spssMetadataEnums(meta *projectMetadata) *spssEnums {
+	enums := &spssEnums{meta: meta}
+	for _, key := range meta.Keys {
+		distinct := make(map[string]bool)
+		for _, docMeta := range meta.ByDocument {
+			if val, ok := docMeta[key]; ok && len(val) > 0 {
+				distinct[val] = true
+			}
+		}
+		vals := make([]string, 0, len(distinct))
+		for val := range distinct {
+			vals = append(vals, val)
+		}
+		sort.Strings(vals)
+
+		codes := make(map[string]float64, len(vals))
+		v := spssNumeric(spssName("meta_"+key), "Metadata: "+key, 8, 0)
+		for i, val := range vals {
+			codes[val] = float64(i + 1)
+			v.ValueLabels = append(v.ValueLabels,
+				spssValueLabel{float64(i + 1), val})
+		}
+		enums.codes = append(enums.codes, codes)
+		enums.variables = append(enums.variables, v)
+	}
+	return enums
+}
+
+func (enums *spssEnums) vars() []spssVar {
+	return enums.variables
+}
+
+// values returns the codes of a document's metadata in the same order as
+// vars. Missing values are nil.
+func (enums *spssEnums) values(docKey string) []interface{} {
+	vals := make([]interface{}, len(enums.meta.Keys))
+	for i, val := range enums.meta.values(docKey) {
+		if code, ok := enums.codes[i][val]; ok {
+			vals[i] = code
+		}
+	}
+	return vals
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"strconv"
+)
+
+func exportSummaryCSV(w *web) {
+	exportSummary(w, ',', "csv", "text/csv; charset=utf-8")
+}
+
+func exportSummaryTSV(w *web) {
+	exportSummary(w, '\t', "tsv", "text/tab-separated-values; charset=utf-8")
+}
+
+// exportSummary writes one row per document with the number and proportion
+// of words in each category of a scoring scheme, the abstraction index and
+// the document's metadata.
+//
+// Every row says which scheme (and version of it) and which layer of scores
+// it was computed from, so that the rows still make sense once they've been
+// combined with other exports.
+func exportSummary(w *web, sep rune, ext, contentType string) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts summaryOptions
+	w.decodeQuery(&opts)
+	scheme := opts.scheme()
+	meta := proj.metadata()
+	sums := proj.summaries(opts)
+
+	w.attachment(summaryFileName(proj, opts, ext), contentType)
+	out := csv.NewWriter(w.w)
+	out.Comma = sep
+	assert(out.Write(summaryHeader(scheme, meta)))
+	for _, sum := range sums {
+		assert(out.Write(summaryRecord(opts, sum, meta)))
+	}
+	out.Flush()
+	assert(out.Error())
+}
+
+// summaryHeader returns the column names of a per-document summary.
+func summaryHeader(scheme configScoringScheme, meta *projectMetadata) []string {
+	header := []string{
+		"scheme", "scheme_version", "layer",
+		"document", "recorded", "tokens", "scored",
+	}
+	for _, cat := range scheme.Ordered() {
+		header = append(header, "n_"+cat.Key)
+	}
+	for _, cat := range scheme.Ordered() {
+		header = append(header, "p_"+cat.Key)
+	}
+	header = append(header, "abstraction")
+	for _, key := range meta.Keys {
+		header = append(header, "meta_"+key)
+	}
+	return header
+}
+
+// summaryValues returns the values of a per-document summary in the same
+// order as summaryHeader. Counts are ints and proportions and the
+// abstraction index are float64s. An undefined abstraction index is nil.
+func summaryValues(
+	opts summaryOptions,
+	sum *documentSummary,
+	meta *projectMetadata,
+) []interface{} {
+	values := []interface{}{
+		opts.Scheme, sum.Scheme.VersionString(), opts.Layer(),
+		sum.Document.Display, sum.Document.RecordedKey(),
+		sum.Tokens, sum.Scored(),
+	}
+	for _, cat := range sum.Scheme.Ordered() {
+		values = append(values, sum.Counts[cat.Key])
+	}
+	for _, cat := range sum.Scheme.Ordered() {
+		values = append(values, sum.Proportion(cat.Key))
+	}
+	if abs, ok := sum.Abstraction(); ok {
+		values = append(values, abs)
+	} else {
+		values = append(values, nil)
+	}
+	for _, v := range meta.values(sum.Document.Key()) {
+		values = append(values, v)
+	}
+	return values
+}
+
+// summaryRecord returns the values of a per-document summary as text, in
+// the same order as summaryHeader. An undefined abstraction index is empty.
+func summaryRecord(
+	opts summaryOptions,
+	sum *documentSummary,
+	meta *projectMetadata,
+) []string {
+	values := summaryValues(opts, sum, meta)
+	record := make([]string, len(values))
+	for i, v := range values {
+		switch v := v.(type) {
+		case int:
+			record[i] = strconv.Itoa(v)
+		case float64:
+			record[i] = formatFloat(v)
+		case string:
+			record[i] = v
+		}
+	}
+	return record
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// exportXLSX writes a workbook for a scoring scheme and layer of scores. It
+// has a sheet with the per-document summary, a sheet with the scheme's
+// codebook and a sheet for each document listing its words next to their
+// categories. Categories are colored the same way on every sheet.
+func exportXLSX(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts summaryOptions
+	w.decodeQuery(&opts)
+	scheme := opts.scheme()
+	meta := proj.metadata()
+	sums := proj.summaries(opts)
+
+	ordered := scheme.Ordered()
+	fills := make([]string, len(ordered))
+	styles := make(map[string]int, len(ordered))
+	for i, cat := range ordered {
+		fills[i] = cat.Color
+		styles[cat.Key] = xlsxStyleFill + i
+	}
+
+	w.attachment(summaryFileName(proj, opts, "xlsx"),
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+	xw := newXLSXWriter(w.w, fills)
+
+	xw.sheet("Summary")
+	xw.stringRow(xlsxStyleBold, summaryHeader(scheme, meta)...)
+	for _, sum := range sums {
+		values := summaryValues(opts, sum, meta)
+		cells := make([]xlsxCell, len(values))
+		for i, v := range values {
+			cells[i] = xlsxCell{v, xlsxStyleNormal}
+		}
+		xw.writeRow(cells...)
+	}
+
+	xw.sheet("Codebook")
+	xw.stringRow(xlsxStyleBold, "scheme", opts.Scheme)
+	xw.stringRow(xlsxStyleBold, "scheme_version", scheme.VersionString())
+	xw.stringRow(xlsxStyleBold, "layer", opts.Layer())
+	xw.writeRow()
+	xw.stringRow(xlsxStyleBold,
+		"key", "name", "value", "shortcut", "guidelines", "examples")
+	for _, cat := range ordered {
+		xw.writeRow(
+			xlsxCell{cat.Key, styles[cat.Key]},
+			xlsxCell{cat.Name, styles[cat.Key]},
+			xlsxCell{cat.Value, xlsxStyleNormal},
+			xlsxCell{cat.Shortcut, xlsxStyleNormal},
+			xlsxCell{cat.Guidelines, xlsxStyleNormal},
+			xlsxCell{strings.Join(cat.Examples, "\n\n"), xlsxStyleNormal},
+		)
+	}
+
+	// The sheets of the documents are written as their words and scores
+	// are read.
+	if len(sums) > 0 {
+		filter := exportFilter{Schemes: []string{opts.Scheme}}
+		for _, sum := range sums {
+			filter.Documents = append(filter.Documents, sum.Document.Key())
+		}
+		if len(opts.Coder) > 0 {
+			filter.Coders = []string{opts.Coder}
+		}
+		proj.eachExportedDocument(filter,
+			func(d *document, rows []scoreRow) {
+				names := make(map[int]string)
+				for _, row := range rows {
+					names[row.Word] = row.Category
+				}
+				xlsxDocumentSheet(xw, scheme, styles, d, names)
+			})
+	}
+	assert(xw.close())
+}
+
+// xlsxDocumentSheet adds a sheet listing the words of a document next to
+// the categories in `names`, which maps words to category keys.
+func xlsxDocumentSheet(
+	xw *xlsxWriter,
+	scheme configScoringScheme,
+	styles map[string]int,
+	d *document,
+	names map[int]string,
+) {
+	xw.sheet(fmt.Sprintf("%s %s", d.Display, d.RecordedKey()))
+	xw.stringRow(xlsxStyleBold, "word", "surface", "category", "name")
+	for _, tok := range d.Tokens() {
+		key, ok := names[tok.Index]
+		if !ok {
+			xw.writeRow(
+				xlsxCell{tok.Index, xlsxStyleNormal},
+				xlsxCell{tok.Text, xlsxStyleNormal})
+			continue
+		}
+		xw.writeRow(
+			xlsxCell{tok.Index, xlsxStyleNormal},
+			xlsxCell{tok.Text, styles[key]},
+			xlsxCell{key, styles[key]},
+			xlsxCell{scheme.Categories[key].Name, styles[key]})
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/csql"
+)
+
+// frequencyOptions selects the scores counted in a frequency table, how
+// words are grouped and how the table is sorted.
+type frequencyOptions struct {
+	Scheme string
+	Coder  string
+
+	// By is "lemma" to group every form of a word together or "surface" to
+	// count each form (ignoring case) separately.
+	By string
+
+	// Sort is "word", "total", "scored", "consistency" or the key of a
+	// category in the scheme.
+	Sort string
+}
+
+func (opts frequencyOptions) summary() summaryOptions {
+	return summaryOptions{Scheme: opts.Scheme, Coder: opts.Coder}
+}
+
+// SortURL returns the query string of the same table sorted by `by`.
+func (opts frequencyOptions) SortURL(by string) string {
+	return "?" + url.Values{
+		"Scheme": {opts.Scheme},
+		"Coder":  {opts.Coder},
+		"By":     {opts.By},
+		"Sort":   {by},
+	}.Encode()
+}
+
+func (opts *frequencyOptions) defaults() {
+	if len(opts.Scheme) == 0 && len(conf.Scores) > 0 {
+		opts.Scheme = conf.Categories()[0]
+	}
+	if opts.By != "surface" {
+		opts.By = "lemma"
+	}
+	if len(opts.Sort) == 0 {
+		opts.Sort = "scored"
+	}
+}
+
+// wordFrequency counts the occurrences of a word in documents scored with a
+// scheme, and how many of them were assigned to each category.
+type wordFrequency struct {
+	Word   string
+	Total  int
+	Counts map[string]int
+}
+
+// Scored is the number of occurrences with a score.
+func (wf *wordFrequency) Scored() int {
+	n := 0
+	for _, count := range wf.Counts {
+		n += count
+	}
+	return n
+}
+
+// Percent is the percentage of all occurrences assigned to a category.
+func (wf *wordFrequency) Percent(cat string) float64 {
+	return 100 * float64(wf.Counts[cat]) / float64(wf.Total)
+}
+
+// Consistency is the percentage of scored occurrences assigned to the most
+// common category. A word that is always coded the same way is 100%
+// consistent.
+func (wf *wordFrequency) Consistency() float64 {
+	most := 0
+	for _, count := range wf.Counts {
+		if count > most {
+			most = count
+		}
+	}
+	return 100 * float64(most) / float64(wf.Scored())
+}
+
+// frequencies counts every word in the project that was scored at least
+// once in the selected scheme, in one query.
+func (proj *project) frequencies(opts frequencyOptions) []*wordFrequency {
+	opts.summary().scheme()
+	column := "t.lemma"
+	if opts.By == "surface" {
+		column = "t.lower"
+	}
+	args := []interface{}{proj.Owner.Id, proj.Name, opts.Scheme}
+	coderCond := ""
+	if len(opts.Coder) > 0 {
+		coderCond = "AND s.created_by = $4"
+		args = append(args, opts.Coder)
+	}
+	rows := csql.Query(db, `
+		SELECT
+			`+column+`, s.name, COUNT(*)
+		FROM
+			token t
+		JOIN
+			document_category dc
+			ON dc.project_owner = t.project_owner
+			AND dc.project_name = t.project_name
+			AND dc.document_name = t.document_name
+			AND dc.document_recorded = t.document_recorded
+			AND dc.category = $3
+		LEFT JOIN
+			score s
+			ON s.project_owner = t.project_owner
+			AND s.project_name = t.project_name
+			AND s.document_name = t.document_name
+			AND s.document_recorded = t.document_recorded
+			AND s.word = t.word
+			AND s.category = $3
+			`+coderCond+`
+		WHERE
+			t.project_owner = $1 AND t.project_name = $2
+		GROUP BY
+			`+column+`, s.name
+		`, args...)
+
+	byWord := make(map[string]*wordFrequency)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var word string
+		var cat sql.NullString
+		var count int
+		csql.Scan(row, &word, &cat, &count)
+		wf := byWord[word]
+		if wf == nil {
+			wf = &wordFrequency{Word: word, Counts: make(map[string]int)}
+			byWord[word] = wf
+		}
+		wf.Total += count
+		if cat.Valid {
+			wf.Counts[cat.String] += count
+		}
+	})
+
+	freqs := make([]*wordFrequency, 0)
+	for _, wf := range byWord {
+		if wf.Scored() > 0 {
+			freqs = append(freqs, wf)
+		}
+	}
+	sortFrequencies(freqs, opts.Sort)
+	return freqs
+}
+
+// sortFrequencies sorts words alphabetically, by consistency (least
+// consistent first) or by a count (largest first). Ties are broken by the
+// word.
+func sortFrequencies(freqs []*wordFrequency, by string) {
+	key := func(wf *wordFrequency) float64 {
+		switch by {
+		case "word":
+			return 0
+		case "total":
+			return -float64(wf.Total)
+		case "scored":
+			return -float64(wf.Scored())
+		case "consistency":
+			return wf.Consistency()
+		}
+		return -float64(wf.Counts[by])
+	}
+	sort.Slice(freqs, func(i, j int) bool {
+		ki, kj := key(freqs[i]), key(freqs[j])
+		if ki != kj {
+			return ki < kj
+		}
+		return freqs[i].Word < freqs[j].Word
+	})
+}
+
+// frequencyPage shows which categories the words in a project were
+// assigned to.
+func frequencyPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts frequencyOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+
+	var freqs []*wordFrequency
+	var cats []schemeCategory
+	if len(opts.Scheme) > 0 {
+		freqs = proj.frequencies(opts)
+		cats = opts.summary().scheme().Ordered()
+	}
+	w.html("frequency", m{
+		"Title":       "Word frequencies in " + proj.Display,
+		"Nav":         documentNav(w, proj, nil, "Word frequencies"),
+		"P":           proj,
+		"Opts":        opts,
+		"Frequencies": freqs,
+		"Categories":  cats,
+		"Coders":      proj.members(),
+		"Conf":        conf,
+	})
+}
+
+// exportFrequencyCSV writes the frequency table shown by frequencyPage.
+func exportFrequencyCSV(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts frequencyOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+	scheme := opts.summary().scheme()
+	freqs := proj.frequencies(opts)
+
+	name := summaryFileName(proj, opts.summary(), "csv")
+	name = strings.Replace(name, "-summary-", "-frequency-"+opts.By+"-", 1)
+	w.attachment(name, "text/csv; charset=utf-8")
+	out := csv.NewWriter(w.w)
+	header := []string{
+		"scheme", "scheme_version", "layer", opts.By,
+		"total", "scored", "unscored",
+	}
+	for _, cat := range scheme.Ordered() {
+		header = append(header, "n_"+cat.Key)
+	}
+	for _, cat := range scheme.Ordered() {
+		header = append(header, "pct_"+cat.Key)
+	}
+	header = append(header, "consistency")
+	assert(out.Write(header))
+
+	for _, wf := range freqs {
+		record := []string{
+			opts.Scheme, scheme.VersionString(), opts.summary().Layer(),
+			wf.Word, strconv.Itoa(wf.Total), strconv.Itoa(wf.Scored()),
+			strconv.Itoa(wf.Total - wf.Scored()),
+		}
+		for _, cat := range scheme.Ordered() {
+			record = append(record, strconv.Itoa(wf.Counts[cat.Key]))
+		}
+		for _, cat := range scheme.Ordered() {
+			record = append(record, formatFloat(wf.Percent(cat.Key)))
+		}
+		record = append(record, formatFloat(wf.Consistency()))
+		assert(out.Write(record))
+	}
+	out.Flush()
+	assert(out.Error())
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// kwicLimit is the most occurrences shown in a concordance.
+const kwicLimit = 1000
+
+// kwicOptions is a search for a word in every document of a project.
+type kwicOptions struct {
+	Query string
+
+	// By is "surface" to match the word as written (ignoring case) or
+	// "lemma" to match any form of it.
+	By string
+
+	// Scheme restricts the scores shown to one scoring scheme. When empty,
+	// scores in every scheme are shown.
+	Scheme string
+
+	// Context is the number of words shown on either side.
+	Context int
+}
+
+// kwicLine is a single occurrence of a word with its context and scores.
+type kwicLine struct {
+	Document *document
+	Word     int
+	Surface  string
+	Lemma    string
+	Left     string
+	Right    string
+	Scores   []kwicScore
+}
+
+type kwicScore struct {
+	Scheme   string
+	Category string
+	Coder    string
+}
+
+// concordance finds every occurrence of a word in a project (up to
+// kwicLimit) along with the total number of occurrences. This takes three
+// queries no matter how many occurrences there are.
+func (proj *project) concordance(opts kwicOptions) ([]*kwicLine, int) {
+	column, term := "lower", strings.ToLower(opts.Query)
+	if opts.By == "lemma" {
+		column, term = "lemma", lemmatize(opts.Query)
+	}
+	total := csql.Count(db, `
+		SELECT COUNT(*) FROM token
+		WHERE project_owner = $1 AND project_name = $2 AND `+column+` = $3
+		`, proj.Owner.Id, proj.Name, term)
+
+	// Refers to $1 to $4. Every query below selects from it as `t`.
+	matches := `(
+		SELECT document_name, document_recorded, word, surface, lemma
+		FROM token
+		WHERE project_owner = $1 AND project_name = $2 AND ` + column + ` = $3
+		ORDER BY document_name ASC, document_recorded ASC, word ASC
+		LIMIT $4
+	) t`
+
+	type lineKey struct {
+		name     string
+		recorded time.Time
+		word     int
+	}
+	lines := make([]*kwicLine, 0)
+	byKey := make(map[lineKey]*kwicLine)
+	left := make(map[lineKey][]string)
+	right := make(map[lineKey][]string)
+	rows := csql.Query(db, `
+		SELECT
+			t.document_name, t.document_recorded, t.word, t.surface, t.lemma,
+			c.word, c.surface
+		FROM `+matches+`
+		JOIN token c
+			ON c.project_owner = $1 AND c.project_name = $2
+			AND c.document_name = t.document_name
+			AND c.document_recorded = t.document_recorded
+			AND c.word BETWEEN t.word - $5 AND t.word + $5
+		ORDER BY
+			t.document_name ASC, t.document_recorded ASC, t.word ASC,
+			c.word ASC
+		`, proj.Owner.Id, proj.Name, term, kwicLimit, opts.Context)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var key lineKey
+		var surface, lemma, ctxSurface string
+		var ctxWord int
+		csql.Scan(row, &key.name, &key.recorded, &key.word, &surface, &lemma,
+			&ctxWord, &ctxSurface)
+		line := byKey[key]
+		if line == nil {
+			d := &document{
+				Project:  proj,
+				Name:     key.name,
+				Display:  nameToDisplay(key.name),
+				Recorded: key.recorded,
+			}
+			line = &kwicLine{
+				Document: d,
+				Word:     key.word,
+				Surface:  surface,
+				Lemma:    lemma,
+			}
+			byKey[key] = line
+			lines = append(lines, line)
+		}
+		switch {
+		case ctxWord < key.word:
+			left[key] = append(left[key], ctxSurface)
+		case ctxWord > key.word:
+			right[key] = append(right[key], ctxSurface)
+		}
+	})
+	for key, line := range byKey {
+		line.Left = strings.Join(left[key], " ")
+		line.Right = strings.Join(right[key], " ")
+	}
+
+	args := []interface{}{proj.Owner.Id, proj.Name, term, kwicLimit}
+	schemeCond := "TRUE"
+	if len(opts.Scheme) > 0 {
+		schemeCond = "s.category = $5"
+		args = append(args, opts.Scheme)
+	}
+	rows = csql.Query(db, `
+		SELECT
+			t.document_name, t.document_recorded, t.word,
+			s.category, s.name, s.created_by
+		FROM `+matches+`
+		JOIN score s
+			ON s.project_owner = $1 AND s.project_name = $2
+			AND s.document_name = t.document_name
+			AND s.document_recorded = t.document_recorded
+			AND s.word = t.word
+		JOIN document_category dc
+			ON dc.project_owner = $1 AND dc.project_name = $2
+			AND dc.document_name = s.document_name
+			AND dc.document_recorded = s.document_recorded
+			AND dc.category = s.category
+		WHERE `+schemeCond+`
+		ORDER BY s.category ASC
+		`, args...)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var key lineKey
+		var scheme, name, coder string
+		csql.Scan(row, &key.name, &key.recorded, &key.word,
+			&scheme, &name, &coder)
+		if line := byKey[key]; line != nil {
+			line.Scores = append(line.Scores, kwicScore{
+				Scheme:   scheme,
+				Category: categoryName(scheme, name),
+				Coder:    userName(coder),
+			})
+		}
+	})
+	return lines, total
+}
+
+// categoryName returns the display name of a category in a scheme, or its
+// key if the category isn't in the configuration anymore.
+func categoryName(scheme, key string) string {
+	if cat, ok := conf.Scores[scheme].Categories[key]; ok {
+		return cat.Name
+	}
+	return key
+}
+
+// userName returns the name of the user with the given id, or the id if
+// there is no such user.
+func userName(id string) string {
+	if user := findUserByNo(id); user != nil {
+		return user.Name
+	}
+	return id
+}
+
+// concordancePage shows every occurrence of a word in a project in context.
+func concordancePage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts kwicOptions
+	w.decodeQuery(&opts)
+	if opts.By != "lemma" {
+		opts.By = "surface"
+	}
+	if opts.Context <= 0 {
+		opts.Context = 6
+	} else if opts.Context > 20 {
+		opts.Context = 20
+	}
+
+	var lines []*kwicLine
+	total := 0
+	opts.Query = strings.TrimSpace(opts.Query)
+	if len(opts.Query) > 0 {
+		lines, total = proj.concordance(opts)
+	}
+	w.html("concordance", m{
+		"Title":   "Concordance for " + proj.Display,
+		"Nav":     documentNav(w, proj, nil, "Concordance"),
+		"P":       proj,
+		"Opts":    opts,
+		"Lines":   lines,
+		"Total":   total,
+		"Limit":   kwicLimit,
+		"Lemma":   lemmatize(opts.Query),
+		"Schemes": conf.Categories(),
+	})
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/BurntSushi/lcmweb/client"
+)
+
+const recordedFmt = "2006-01-02"
+
+var flagServer string
+
+func flagsLogin(flags *flag.FlagSet) {
+	flags.StringVar(&flagServer, "server", "",
+		"The URL of the lcmweb server. Defaults to the server logged in to "+
+			"before.")
+}
+
+// cmdLogin checks that the token works before saving it.
+func cmdLogin(flags *flag.FlagSet, args []string) {
+	conf := readConfig()
+	if len(flagServer) > 0 {
+		conf.Server = flagServer
+	}
+	if len(conf.Server) == 0 {
+		fatalf("Please give the URL of the server with -server.")
+	}
+	if len(args) > 0 {
+		conf.Token = args[0]
+	} else {
+		fmt.Fprint(os.Stderr, "API token: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			fatalf("Could not read the token: %s", err)
+		}
+		conf.Token = strings.TrimSpace(line)
+	}
+	if len(conf.Token) == 0 {
+		fatalf("No token given.")
+	}
+
+	c := client.New(conf.Server, conf.Token)
+	projs, err := c.Projects()
+	if err != nil {
+		fatalf("%s", err)
+	}
+	writeConfig(conf)
+	fmt.Printf("Logged in to %s. You own %d projects.\n", c.BaseURL,
+		len(projs))
+}
+
+func cmdSchemes(flags *flag.FlagSet, args []string) {
+	schemes, err := newClient().Schemes()
+	if err != nil {
+		fatalf("%s", err)
+	}
+	for _, s := range schemes {
+		fmt.Printf("%s (version %s)\n", s.Name, s.Version)
+		for _, cat := range s.Categories {
+			fmt.Printf("\t%s\t%s\n", cat.Key, cat.Name)
+		}
+	}
+}
+
+func cmdProjects(flags *flag.FlagSet, args []string) {
+	projs, err := newClient().Projects()
+	if err != nil {
+		fatalf("%s", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROJECT\tNAME\tDOCUMENTS\tADDED")
+	for _, p := range projs {
+		fmt.Fprintf(tw, "%s/%s\t%s\t%d\t%s\n", p.Owner, p.Name, p.Display,
+			p.Documents, p.Added.Format(recordedFmt))
+	}
+	tw.Flush()
+}
+
+var (
+	flagSort   string
+	flagStatus string
+	flagMeta   listFlag
+)
+
+func flagsDocuments(flags *flag.FlagSet) {
+	flags.StringVar(&flagSort, "sort", "name",
+		"Sort by name, recorded, progress or modified. Prefix with - to "+
+			"sort in descending order.")
+	flags.StringVar(&flagStatus, "status", "",
+		"Only list documents that are unscored, partial or complete.")
+	flags.Var(&flagMeta, "meta",
+		"Comma separated metadata filters, each as KEY=VALUE.")
+}
+
+func cmdDocuments(flags *flag.FlagSet, args []string) {
+	needArgs(flags, args, 1)
+	owner, project := splitProject(args[0])
+	c := newClient()
+	opts := client.DocumentListOptions{
+		Sort:     flagSort,
+		Status:   flagStatus,
+		Metadata: flagMeta,
+		PerPage:  client.MaxPerPage,
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DOCUMENT\tRECORDED\tNAME\tSTATUS\tCODED\tMODIFIED")
+	for opts.Page = 1; ; opts.Page++ {
+		docs, page, err := c.ListDocuments(owner, project, opts)
+		if err != nil {
+			fatalf("%s", err)
+		}
+		for _, d := range docs {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d/%d\t%s\n", d.Name,
+				d.Recorded, d.Display, d.Status, d.Scored, d.Units,
+				d.Modified.Format(recordedFmt))
+		}
+		if page.Last() || len(docs) == 0 {
+			break
+		}
+	}
+	tw.Flush()
+}
+
+// listFlag is a comma separated list given as an option.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			*l = append(*l, v)
+		}
+	}
+	return nil
+}
+
+// metadataFlag collects KEY=VALUE options.
+type metadataFlag map[string]string
+
+func (md metadataFlag) String() string {
+	pairs := make([]string, 0, len(md))
+	for k, v := range md {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func (md metadataFlag) Set(s string) error {
+	pieces := strings.SplitN(s, "=", 2)
+	if len(pieces) != 2 || len(pieces[0]) == 0 {
+		return fmt.Errorf("metadata must be given as KEY=VALUE")
+	}
+	md[pieces[0]] = pieces[1]
+	return nil
+}
+
+var (
+	flagSchemes  listFlag
+	flagRecorded string
+	flagExt      string
+	flagMetadata = metadataFlag{}
+)
+
+func flagsUpload(flags *flag.FlagSet) {
+	flags.Var(&flagSchemes, "schemes",
+		"Comma separated names of the scoring schemes the documents are "+
+			"scored with. Run `lcm schemes` to list them.")
+	flags.StringVar(&flagRecorded, "recorded", "",
+		"The date the documents were recorded, as YYYY-MM-DD. Defaults to "+
+			"the date each file was last modified.")
+	flags.StringVar(&flagExt, "ext", ".txt",
+		"The extension of the files uploaded from directories.")
+	flags.Var(flagMetadata, "meta",
+		"Metadata of the documents as KEY=VALUE. May be repeated.")
+}
+
+// cmdUpload adds each file as a document named after the file (without its
+// extension). A failure doesn't stop the rest of the files from being
+// uploaded.
+func cmdUpload(flags *flag.FlagSet, args []string) {
+	needArgs(flags, args, 2)
+	owner, project := splitProject(args[0])
+	c := newClient()
+
+	files := make([]string, 0)
+	for _, arg := range args[1:] {
+		files = append(files, uploadFiles(arg)...)
+	}
+	if len(files) == 0 {
+		fatalf("No files with extension %s found.", flagExt)
+	}
+
+	failed := 0
+	for _, file := range files {
+		doc, err := uploadFile(c, owner, project, file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", file, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: added %s/%s\n", file, doc.Name, doc.Recorded)
+	}
+	if failed > 0 {
+		fatalf("%d of %d files could not be uploaded.", failed, len(files))
+	}
+}
+
+// uploadFiles returns `path` if it is a file, or the files in it (and its
+// subdirectories) with the extension -ext if it is a directory.
+func uploadFiles(path string) []string {
+	info, err := os.Stat(path)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	if !info.IsDir() {
+		return []string{path}
+	}
+	files := make([]string, 0)
+	walk := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		ext := filepath.Ext(p)
+		if info.Mode().IsRegular() && strings.EqualFold(ext, flagExt) {
+			files = append(files, p)
+		}
+		return nil
+	}
+	err = filepath.Walk(path, walk)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	return files
+}
+
+func uploadFile(
+	c *client.Client,
+	owner, project, file string,
+) (*client.Document, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	recorded := flagRecorded
+	if len(recorded) == 0 {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		recorded = info.ModTime().Format(recordedFmt)
+	}
+	base := filepath.Base(file)
+	return c.AddDocument(owner, project, client.NewDocument{
+		Display:    strings.TrimSuffix(base, filepath.Ext(base)),
+		Recorded:   recorded,
+		Categories: flagSchemes,
+		Content:    string(content),
+		Metadata:   flagMetadata,
+	})
+}
+
+var (
+	flagOutput     string
+	flagScheme     string
+	flagCoder      string
+	flagStyle      string
+	flagDocuments  listFlag
+	flagCoders     listFlag
+	flagCategories listFlag
+)
+
+// exportFiles are the files `lcm export` can download: those of the web
+// site and the JSON exports of the API.
+func exportFiles() []string {
+	return append(append([]string{}, client.ExportFiles...),
+		"scores.json", "summary.json")
+}
+
+func flagsExport(flags *flag.FlagSet) {
+	flags.StringVar(&flagOutput, "o", "",
+		"Where to write the export. Defaults to FILE in the current "+
+			"directory. Use - for stdout.")
+	flags.StringVar(&flagScheme, "scheme", "",
+		"Summaries: the scoring scheme to summarize.")
+	flags.StringVar(&flagCoder, "coder", "",
+		"Summaries: only use the scores of the user with this id.")
+	flags.Var(&flagDocuments, "documents",
+		"Scores: comma separated documents, each as NAME/RECORDED.")
+	flags.Var(&flagCoders, "coders",
+		"Scores: comma separated ids of the users who created the scores.")
+	flags.Var(&flagSchemes, "schemes",
+		"Scores: comma separated names of scoring schemes.")
+	flags.Var(&flagCategories, "categories",
+		"Scores: comma separated keys of categories.")
+}
+
+func cmdExport(flags *flag.FlagSet, args []string) {
+	needArgs(flags, args, 2)
+	owner, project := splitProject(args[0])
+	file := args[1]
+	known := false
+	for _, f := range exportFiles() {
+		known = known || f == file
+	}
+	if !known {
+		fatalf("Unknown export %q. Use one of %s.", file,
+			strings.Join(exportFiles(), ", "))
+	}
+
+	c := newClient()
+	filter := client.ScoreFilter{
+		Documents:  flagDocuments,
+		Coders:     flagCoders,
+		Schemes:    flagSchemes,
+		Categories: flagCategories,
+	}
+	opts := client.SummaryOptions{Scheme: flagScheme, Coder: flagCoder}
+	output(file, func(w io.Writer) error {
+		switch file {
+		case "scores.json":
+			scores, err := c.ExportScores(owner, project, filter)
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, scores)
+		case "summary.json":
+			sums, err := c.Summaries(owner, project, opts)
+			if err != nil {
+				return err
+			}
+			return writeJSON(w, sums)
+		}
+		// Each export ignores the options it doesn't use.
+		query := filter.Values()
+		for k, vs := range opts.Values() {
+			query[k] = vs
+		}
+		return c.Export(owner, project, file, query, w)
+	})
+}
+
+func flagsReport(flags *flag.FlagSet) {
+	flags.StringVar(&flagOutput, "o", "",
+		"Where to write the report. Defaults to DOCUMENT-RECORDED.pdf in "+
+			"the current directory. Use - for stdout.")
+	flags.StringVar(&flagScheme, "scheme", "",
+		"The scoring scheme to show. Defaults to the first one of the "+
+			"document.")
+	flags.StringVar(&flagCoder, "coder", "",
+		"Only show the scores of the user with this id.")
+	flags.StringVar(&flagStyle, "style", "color",
+		"How scored words are marked: color or underline.")
+}
+
+func cmdReport(flags *flag.FlagSet, args []string) {
+	needArgs(flags, args, 3)
+	owner, project := splitProject(args[0])
+	name, recorded := args[1], args[2]
+	c := newClient()
+	opts := client.ReportOptions{
+		Scheme: flagScheme,
+		Coder:  flagCoder,
+		Style:  flagStyle,
+	}
+	output(name+"-"+recorded+".pdf", func(w io.Writer) error {
+		return c.Report(owner, project, name, recorded, opts, w)
+	})
+}
+
+// output calls `write` with the file given by -o (or `def`). The file is
+// removed if `write` fails, so that an error isn't mistaken for an export.
+func output(def string, write func(w io.Writer) error) {
+	path := flagOutput
+	if len(path) == 0 {
+		path = def
+	}
+	if path == "-" {
+		if err := write(os.Stdout); err != nil {
+			fatalf("%s", err)
+		}
+		return
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+		fatalf("%s", err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", path)
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
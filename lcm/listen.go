@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+)
+
+var (
+	flagAddr   string
+	flagSecret string
+	flagFail   bool
+)
+
+func flagsListen(flags *flag.FlagSet) {
+	flags.StringVar(&flagAddr, "addr", "localhost:8090",
+		"The address to listen on.")
+	flags.StringVar(&flagSecret, "secret", "",
+		"The secret of the webhook, shown on its project's webhooks page. "+
+			"When given, deliveries with a wrong signature are rejected.")
+	flags.BoolVar(&flagFail, "fail", false,
+		"Respond to every delivery with an error, to test retries.")
+}
+
+// cmdListen receives webhook deliveries and prints them, so that webhooks
+// can be tried out without a real receiver.
+func cmdListen(flags *flag.FlagSet, args []string) {
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		sig := r.Header.Get("X-LCM-Signature")
+		fmt.Printf("%s delivery %s (%s %s)\n", r.Header.Get("X-LCM-Event"),
+			r.Header.Get("X-LCM-Delivery"), r.Method, r.URL.Path)
+		if len(flagSecret) > 0 && !validSignature(flagSecret, sig, body) {
+			fmt.Printf("  rejected: bad signature %q\n\n", sig)
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+
+		var pretty bytes.Buffer
+		if json.Indent(&pretty, body, "  ", "  ") == nil {
+			fmt.Printf("  %s\n\n", pretty.String())
+		} else {
+			fmt.Printf("  %s\n\n", body)
+		}
+		if flagFail {
+			http.Error(w, "failing as asked by -fail",
+				http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	fmt.Fprintf(os.Stderr, "Listening on http://%s/\n", flagAddr)
+	log.Fatal(http.ListenAndServe(flagAddr, nil))
+}
+
+// validSignature checks the X-LCM-Signature header of a delivery.
+func validSignature(secret, sig string, body []byte) bool {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(sig), []byte(want))
+}
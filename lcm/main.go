@@ -0,0 +1,197 @@
+// Command lcm uses the API of an lcmweb server to manage projects from the
+// command line. Log in once with an API token (created on the "API tokens"
+// page of lcmweb) and then run the other commands:
+//
+//	lcm login -server https://lcm.example.com
+//	lcm projects
+//	lcm upload -schemes verbs andrew/speeches transcripts/
+//	lcm export andrew/speeches summary.sav
+//	lcm report andrew/speeches Speech_1 2016-05-01
+//
+// Run `lcm help` for the list of commands, or `lcm COMMAND -h` for the
+// options of one of them.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/BurntSushi/lcmweb/client"
+)
+
+// command is a subcommand of lcm. Its options are defined on the flag set
+// by `flags` (which may be nil), and `run` is given the remaining
+// arguments.
+type command struct {
+	name  string
+	args  string
+	help  string
+	run   func(flags *flag.FlagSet, args []string)
+	flags func(flags *flag.FlagSet)
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{"login", "[TOKEN]",
+			"Save the server and token used by the other commands. When " +
+				"TOKEN is omitted, it is read from stdin.",
+			cmdLogin, flagsLogin},
+		{"schemes", "",
+			"List the scoring schemes and their categories.",
+			cmdSchemes, nil},
+		{"projects", "",
+			"List the projects you own.",
+			cmdProjects, nil},
+		{"documents", "OWNER/PROJECT",
+			"List the documents in a project with how much of each is " +
+				"coded.",
+			cmdDocuments, flagsDocuments},
+		{"upload", "OWNER/PROJECT FILE|DIRECTORY ...",
+			"Add text files to a project as documents. Directories are " +
+				"searched for files with the extension given by -ext.",
+			cmdUpload, flagsUpload},
+		{"export", "OWNER/PROJECT FILE",
+			"Download an export of a project. FILE is one of " +
+				strings.Join(exportFiles(), ", ") + ".",
+			cmdExport, flagsExport},
+		{"report", "OWNER/PROJECT DOCUMENT RECORDED",
+			"Download the PDF report of a document.",
+			cmdReport, flagsReport},
+	}
+}
+
+// config is saved by `lcm login`. The environment variables LCM_SERVER and
+// LCM_TOKEN take precedence over it.
+type config struct {
+	Server string
+	Token  string
+}
+
+func configPath() string {
+	if p := os.Getenv("LCM_CONFIG"); len(p) > 0 {
+		return p
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		fatalf("Could not find a directory for the configuration: %s", err)
+	}
+	return filepath.Join(dir, "lcm", "config.toml")
+}
+
+func readConfig() config {
+	var conf config
+	_, err := toml.DecodeFile(configPath(), &conf)
+	if err != nil && !os.IsNotExist(err) {
+		fatalf("Could not read %s: %s", configPath(), err)
+	}
+	if s := os.Getenv("LCM_SERVER"); len(s) > 0 {
+		conf.Server = s
+	}
+	if t := os.Getenv("LCM_TOKEN"); len(t) > 0 {
+		conf.Token = t
+	}
+	return conf
+}
+
+// writeConfig saves the configuration where only the user can read it,
+// since it contains the token.
+func writeConfig(conf config) {
+	p := configPath()
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		fatalf("%s", err)
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fatalf("%s", err)
+	}
+	if err := toml.NewEncoder(f).Encode(conf); err != nil {
+		fatalf("Could not write %s: %s", p, err)
+	}
+	if err := f.Close(); err != nil {
+		fatalf("Could not write %s: %s", p, err)
+	}
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage()
+		return
+	}
+	var cmd *command
+	for _, c := range commands {
+		if c.name == name {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "lcm: unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("lcm "+cmd.name, flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: lcm %s [options] %s\n\n%s\n",
+			cmd.name, cmd.args, cmd.help)
+		fmt.Fprintln(os.Stderr)
+		flags.PrintDefaults()
+	}
+	if cmd.flags != nil {
+		cmd.flags(flags)
+	}
+	flags.Parse(os.Args[2:])
+	cmd.run(flags, flags.Args())
+}
+
+// newClient returns a client for the server the user logged in to.
+func newClient() *client.Client {
+	conf := readConfig()
+	if len(conf.Server) == 0 || len(conf.Token) == 0 {
+		fatalf("Not logged in. Run `lcm login` first.")
+	}
+	return client.New(conf.Server, conf.Token)
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: lcm COMMAND [options] [arguments]\n\n")
+	fmt.Fprintf(os.Stderr, "Commands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.args)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun `lcm COMMAND -h` for help with a command.\n")
+}
+
+func fatalf(format string, v ...interface{}) {
+	fmt.Fprintf(os.Stderr, "lcm: "+format+"\n", v...)
+	os.Exit(1)
+}
+
+// splitProject splits an argument of the form OWNER/PROJECT.
+func splitProject(arg string) (string, string) {
+	pieces := strings.SplitN(arg, "/", 2)
+	if len(pieces) != 2 || len(pieces[0]) == 0 || len(pieces[1]) == 0 {
+		fatalf("Projects are given as OWNER/PROJECT, but got %q.", arg)
+	}
+	return pieces[0], pieces[1]
+}
+
+// needArgs exits with the usage of a command unless it was given at least
+// `n` arguments.
+func needArgs(flags *flag.FlagSet, args []string, n int) {
+	if len(args) < n {
+		flags.Usage()
+		os.Exit(2)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLemmatize(t *testing.T) {
+	tests := []struct {
+		word, lemma string
+	}{
+		// Inflections.
+		{"runs", "run"},
+		{"running", "run"},
+		{"ran", "run"},
+		{"stopped", "stop"},
+		{"hoping", "hope"},
+		{"loved", "love"},
+		{"handled", "handle"},
+		{"studies", "study"},
+		{"boxes", "box"},
+		{"Walked", "walk"},
+		{"didn't", "do"},
+		{"she's", "she"},
+		{"agreed", "agree"},
+
+		// Words that only look inflected.
+		{"string", "string"},
+		{"during", "during"},
+		{"morning", "morning"},
+		{"nothing", "nothing"},
+		{"something", "something"},
+		{"evening", "evening"},
+		{"hundred", "hundred"},
+		{"naked", "naked"},
+		{"thing", "thing"},
+		{"class", "class"},
+		{"bus", "bus"},
+
+		// Not words.
+		{"42", "42"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := lemmatize(test.word); got != test.lemma {
+			t.Errorf("lemmatize(%q) = %q, want %q", test.word, got, test.lemma)
+		}
+	}
+}
+
+// The frozen copy used by the token table migration must not drift from
+// the words it was written for.
+func TestLemmatizeV1(t *testing.T) {
+	tests := map[string]string{
+		"running": "run", "string": "string", "morning": "morning",
+		"during": "during", "handled": "handle", "wasn't": "be",
+	}
+	for word, lemma := range tests {
+		if got := lemmatizeV1(word); got != lemma {
+			t.Errorf("lemmatizeV1(%q) = %q, want %q", word, got, lemma)
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"strings"
+)
+
+// lemmatizeV1 is a frozen copy of lemmatize as it was when the token table
+// was created. The migration that creates the table uses it, so that it
+// stores the same lemmas no matter how lemmatize changes later. Do not
+// change this file.
+func lemmatizeV1(word string) string {
+	w := strings.ToLower(word)
+	w = strings.Replace(w, "’", "'", -1)
+
+	// Drop cliticsV1: "didn't" is "did" and "she's" is "she".
+	for _, clitic := range cliticsV1 {
+		if strings.HasSuffix(w, clitic) && len(w) > len(clitic) {
+			w = w[:len(w)-len(clitic)]
+			break
+		}
+	}
+	if lemma, ok := irregularLemmasV1[w]; ok {
+		return lemma
+	}
+	if notInflectedV1[w] {
+		return w
+	}
+	if len(w) <= 3 || !isLetters(w) {
+		return w
+	}
+
+	switch {
+	case strings.HasSuffix(w, "ies") && len(w) > 4:
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "ied") && len(w) > 4:
+		return w[:len(w)-3] + "y"
+	case strings.HasSuffix(w, "sses"), strings.HasSuffix(w, "shes"),
+		strings.HasSuffix(w, "ches"), strings.HasSuffix(w, "xes"),
+		strings.HasSuffix(w, "zzes"), strings.HasSuffix(w, "oes"):
+		return w[:len(w)-2]
+	case strings.HasSuffix(w, "ss"), strings.HasSuffix(w, "us"),
+		strings.HasSuffix(w, "is"), strings.HasSuffix(w, "eed"):
+		return w
+	case strings.HasSuffix(w, "s"):
+		return w[:len(w)-1]
+	case strings.HasSuffix(w, "ing") && len(w) > 5:
+		return restoreStemV1(w[:len(w)-3])
+	case strings.HasSuffix(w, "ed") && len(w) > 4:
+		return restoreStemV1(w[:len(w)-2])
+	}
+	return w
+}
+
+var cliticsV1 = []string{"n't", "'s", "'re", "'ve", "'d", "'ll", "'m"}
+
+// restoreStemV1 turns what is left after removing `-ing` or `-ed` back into a
+// word: `stopp` becomes `stop` and `hop` stays `hop`, but `lov` becomes
+// `love` and `handl` becomes `handle`.
+func restoreStemV1(stem string) string {
+	n := len(stem)
+	last, prev := stem[n-1], stem[n-2]
+	switch {
+	case last == prev && !strings.ContainsRune("lsz", rune(last)) &&
+		!isVowel(last):
+		return stem[:n-1]
+	case strings.ContainsRune("vzc", rune(last)),
+		last == 's' && isVowel(prev),
+		strings.HasSuffix(stem, "dg"),
+		last == 'l' && !isVowel(prev) &&
+			!strings.ContainsRune("lrw", rune(prev)),
+		last == 't' && prev == 'a' && n > 4 && !isVowel(stem[n-3]),
+		n <= 3 && isCVCV1(stem):
+		return stem + "e"
+	}
+	return stem
+}
+
+// isCVCV1 reports whether a word ends with a consonant, a vowel and then a
+// consonant other than w, x or y, as in `hop`.
+func isCVCV1(w string) bool {
+	n := len(w)
+	if n < 3 {
+		return false
+	}
+	return !isVowel(w[n-3]) && isVowel(w[n-2]) && !isVowel(w[n-1]) &&
+		!strings.ContainsRune("wxy", rune(w[n-1]))
+}
+
+// irregularLemmasV1 maps irregular forms (mostly of verbs, since those are
+// what the linguistic category model is about) to their lemmas. It also
+// lists regular forms that the suffix rules get wrong.
+var irregularLemmasV1 = map[string]string{
+	"am": "be", "is": "be", "are": "be", "was": "be", "were": "be",
+	"been": "be", "being": "be",
+	"has": "have", "had": "have", "having": "have",
+	"does": "do", "did": "do", "done": "do", "doing": "do",
+	"goes": "go", "went": "go", "gone": "go",
+	"ate": "eat", "eaten": "eat",
+	"became": "become", "began": "begin", "begun": "begin",
+	"bit": "bite", "bitten": "bite", "bled": "bleed", "blew": "blow",
+	"blown": "blow", "broke": "break", "broken": "break",
+	"bred": "breed", "brought": "bring", "built": "build",
+	"bought": "buy", "came": "come", "caught": "catch",
+	"chose": "choose", "chosen": "choose", "clung": "cling",
+	"dealt": "deal", "drew": "draw", "drawn": "draw", "drank": "drink",
+	"drunk": "drink", "drove": "drive", "driven": "drive", "dug": "dig",
+	"fed": "feed", "fell": "fall", "fallen": "fall", "felt": "feel",
+	"fled": "flee", "flew": "fly", "flown": "fly", "fought": "fight",
+	"forbade": "forbid", "forgot": "forget", "forgotten": "forget",
+	"forgave": "forgive", "forgiven": "forgive", "found": "find",
+	"froze": "freeze", "frozen": "freeze", "gave": "give",
+	"given": "give", "got": "get", "gotten": "get", "grew": "grow",
+	"grown": "grow", "heard": "hear", "held": "hold", "hid": "hide",
+	"hidden": "hide", "hung": "hang", "kept": "keep", "knew": "know",
+	"known": "know", "laid": "lay", "lain": "lie", "led": "lead",
+	"left": "leave", "lent": "lend", "lost": "lose", "made": "make",
+	"meant": "mean", "met": "meet", "paid": "pay", "ran": "run",
+	"rang": "ring", "rode": "ride", "ridden": "ride", "rose": "rise",
+	"risen": "rise", "said": "say", "sang": "sing", "sung": "sing",
+	"sat": "sit", "saw": "see", "seen": "see", "sent": "send",
+	"shook": "shake", "shaken": "shake", "shot": "shoot",
+	"shone": "shine", "slept": "sleep", "slid": "slide",
+	"sold": "sell", "sought": "seek", "spoke": "speak",
+	"spoken": "speak", "spent": "spend", "spun": "spin",
+	"stood": "stand", "stole": "steal", "stolen": "steal",
+	"struck": "strike", "stuck": "stick", "stung": "sting",
+	"swore": "swear", "sworn": "swear", "swam": "swim", "swum": "swim",
+	"swung": "swing", "taught": "teach", "took": "take",
+	"taken": "take", "tore": "tear", "torn": "tear", "told": "tell",
+	"thought": "think", "threw": "throw", "thrown": "throw",
+	"understood": "understand", "woke": "wake", "woken": "wake",
+	"wore": "wear", "worn": "wear", "wept": "weep", "won": "win",
+	"wrote": "write", "written": "write",
+	"used": "use", "writing": "write",
+	"created": "create", "creating": "create",
+	"agreed": "agree", "disagreed": "disagree", "freed": "free",
+	"guaranteed": "guarantee", "changed": "change", "changing": "change",
+	"arranged": "arrange", "arranging": "arrange",
+	"men": "man", "women": "woman", "children": "child",
+	"feet": "foot", "teeth": "tooth", "mice": "mouse", "geese": "goose",
+	"ca": "can", "wo": "will", "sha": "shall",
+	"always": "always", "perhaps": "perhaps", "news": "news",
+}
+
+// notInflectedV1 lists words ending in `-ing` or `-ed` that aren't forms of a
+// verb, so the suffix rules mustn't remove their endings.
+var notInflectedV1 = map[string]bool{
+	"anything": true, "awning": true, "ceiling": true, "clothing": true,
+	"darling": true, "during": true, "earring": true, "evening": true,
+	"everything": true, "herring": true, "lightning": true,
+	"morning": true, "nothing": true, "offspring": true, "pudding": true,
+	"shilling": true, "sibling": true, "something": true, "spring": true,
+	"string": true, "wedding": true,
+	"beloved": true, "crooked": true, "embed": true, "hatred": true,
+	"hundred": true, "kindred": true, "naked": true, "ragged": true,
+	"rugged": true, "sacred": true, "shred": true, "wicked": true,
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// listPerPage is the number of items on a page of a list, unless
+	// another number is asked for.
+	listPerPage = 50
+
+	// listMaxPerPage is the largest number of items on a page.
+	listMaxPerPage = 500
+)
+
+// listPage is one page of a list of projects or documents. Pages are
+// numbered from 1.
+type listPage struct {
+	Page    int
+	PerPage int
+	Total   int
+
+	// query is the query string of the list, without the page number.
+	query url.Values
+}
+
+func newListPage(page, perPage int, query url.Values) listPage {
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = listPerPage
+	} else if perPage > listMaxPerPage {
+		perPage = listMaxPerPage
+	}
+	return listPage{Page: page, PerPage: perPage, query: query}
+}
+
+func (p listPage) offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// limitSQL returns the LIMIT and OFFSET of the page, numbering their
+// placeholders after `args`.
+func (p listPage) limitSQL(args []interface{}) (string, []interface{}) {
+	sql := fmt.Sprintf("LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	return sql, append(args, p.PerPage, p.offset())
+}
+
+// Pages is the number of pages in the list. An empty list has one page.
+func (p listPage) Pages() int {
+	if p.Total == 0 {
+		return 1
+	}
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// First and Last are the positions (counting from 1) of the first and last
+// items on the page.
+func (p listPage) First() int {
+	return p.offset() + 1
+}
+
+func (p listPage) Last() int {
+	if last := p.offset() + p.PerPage; last < p.Total {
+		return last
+	}
+	return p.Total
+}
+
+// Prev and Next are the numbers of the previous and next pages.
+func (p listPage) Prev() int {
+	return p.Page - 1
+}
+
+func (p listPage) Next() int {
+	return p.Page + 1
+}
+
+func (p listPage) HasPrev() bool {
+	return p.Page > 1
+}
+
+func (p listPage) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// URL returns the query string of another page of the same list.
+func (p listPage) URL(page int) string {
+	q := url.Values{}
+	for k, vs := range p.query {
+		q[k] = vs
+	}
+	q.Set("Page", strconv.Itoa(page))
+	if p.PerPage != listPerPage {
+		q.Set("PerPage", strconv.Itoa(p.PerPage))
+	}
+	return "?" + q.Encode()
+}
+
+// setHeaders describes the page in the headers of an API response, since
+// the content of the response is the list itself. The Link header has the
+// URLs of the next and previous pages.
+func (p listPage) setHeaders(h http.Header) {
+	h.Set("X-Total-Count", strconv.Itoa(p.Total))
+	h.Set("X-Page", strconv.Itoa(p.Page))
+	h.Set("X-Per-Page", strconv.Itoa(p.PerPage))
+	links := make([]string, 0, 2)
+	if p.HasPrev() {
+		links = append(links,
+			fmt.Sprintf(`<%s>; rel="prev"`, p.URL(p.Prev())))
+	}
+	if p.HasNext() {
+		links = append(links,
+			fmt.Sprintf(`<%s>; rel="next"`, p.URL(p.Next())))
+	}
+	if len(links) > 0 {
+		h.Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// sortSQL returns the ORDER BY expression for `sort`, which is one of the
+// keys of `columns` optionally prefixed by "-" for descending order. Ties
+// are broken by `tiebreak`.
+func sortSQL(sort string, columns map[string]string, tiebreak string) string {
+	dir := "ASC"
+	if strings.HasPrefix(sort, "-") {
+		sort, dir = sort[1:], "DESC"
+	}
+	col, ok := columns[sort]
+	if !ok {
+		panic(ue("Cannot sort by **%s**.", sort))
+	}
+	return fmt.Sprintf("%s %s, %s", col, dir, tiebreak)
+}
+
+// toggleSort returns the sort order to link to from the header of a column:
+// the reverse of the current order when the list is already sorted by the
+// column.
+func toggleSort(current, by string) string {
+	if current == by {
+		return "-" + by
+	}
+	return by
+}
@@ -69,12 +69,3 @@ func postLogin(w *web, routes martini.Routes) {
 	assert(w.s.Save(w.r, w.w))
 	http.Redirect(w.w, w.r, form.BackTo, 302)
 }
-
-func findUserByEmail(email string) *lcmUser {
-	for _, user := range conf.Users {
-		if email == user.Email {
-			return newLcmUser(user)
-		}
-	}
-	return nil
-}
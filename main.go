@@ -28,6 +28,7 @@ func main() {
 
 	conf = newConfig()
 	db = connect(conf.PgSQL)
+	users.load()
 	store = newStore(db, conf.Security)
 	if uauth, err = sqlauth.Open(db.DB); err != nil {
 		log.Fatalf("Could not open authenticator: %s", err)
@@ -41,6 +42,10 @@ func main() {
 		}
 	}()
 
+	// Send queued deliveries to webhooks, including any left over from
+	// before a restart.
+	go deliverHooks()
+
 	m := martini.Classic()
 	m.Use(martini.Static("static", martini.StaticOptions{
 		Prefix:      "/static",
@@ -74,9 +79,98 @@ func main() {
 	m.Get("/project/collab/list/:user/:project", webAuth, bitCollaborators).
 		Name("project-bit-collab")
 
+	m.Get("/admin/users", webAuth, adminUsers).Name("admin-users")
+	m.Post("/admin/users", webAuth, adminUsers)
+	m.Get("/admin/users/:id", webAuth, adminUser).Name("admin-user")
+	m.Post("/admin/users/:id", webAuth, adminUser)
+	m.Get("/settings/tokens", webAuth, apiTokenSettings).
+		Name("settings-tokens")
+	m.Post("/settings/tokens", webAuth, apiTokenSettings)
+	m.Post("/settings/tokens/:id/revoke", webAuth, revokeAPIToken).
+		Name("settings-token-revoke")
+
+	m.Get("/codebook/:scheme", webAuth, codebook).Name("codebook")
+
+	m.Group(apiPrefix, apiRoutes, apiResp)
+
 	m.Get("/:owner/:project", webAuth, documents).Name("document-list")
+	m.Get("/:owner/:project/dashboard", webAuth, dashboardPage).
+		Name("project-dashboard")
 	m.Get("/:owner/:project/add", webAuth, addDocument).Name("document-add")
+	m.Post("/:owner/:project/add", webAuth, addDocument)
+	m.Get("/:owner/:project/export", webAuth, exportPage).Name("export")
+	m.Get("/:owner/:project/export/scores.csv", webAuth, exportScoresCSV).
+		Name("export-scores-csv")
+	m.Get("/:owner/:project/export/summary.csv", webAuth, exportSummaryCSV).
+		Name("export-summary-csv")
+	m.Get("/:owner/:project/export/summary.tsv", webAuth, exportSummaryTSV).
+		Name("export-summary-tsv")
+	m.Get("/:owner/:project/export/summary.sav", webAuth, exportSummarySPSS).
+		Name("export-summary-sav")
+	m.Get("/:owner/:project/export/workbook.xlsx", webAuth, exportXLSX).
+		Name("export-xlsx")
+	m.Get("/:owner/:project/export/scores.sav", webAuth, exportScoresSPSS).
+		Name("export-scores-sav")
+	m.Get("/:owner/:project/export/scores.parquet", webAuth,
+		exportScoresParquet).Name("export-scores-parquet")
+	m.Get("/:owner/:project/export/summary.parquet", webAuth,
+		exportSummaryParquet).Name("export-summary-parquet")
+	m.Get("/:owner/:project/export/conllu.zip", webAuth, exportCoNLLU).
+		Name("export-conllu")
+	m.Get("/:owner/:project/export/brat.zip", webAuth, exportBrat).
+		Name("export-brat")
+	m.Get("/:owner/:project/export/annotations.jsonld", webAuth,
+		exportWebAnnotations).Name("export-jsonld")
+	m.Get("/:owner/:project/timeseries", webAuth, timeseriesPage).
+		Name("timeseries")
+	m.Get("/:owner/:project/timeseries/series.csv", webAuth,
+		exportTimeseriesCSV).Name("timeseries-csv")
+	m.Get("/:owner/:project/compare", webAuth, comparePage).Name("compare")
+	m.Get("/:owner/:project/compare/comparison.csv", webAuth,
+		exportCompareCSV).Name("compare-csv")
+	m.Get("/:owner/:project/concordance", webAuth, concordancePage).
+		Name("concordance")
+	m.Get("/:owner/:project/frequency", webAuth, frequencyPage).
+		Name("frequency")
+	m.Get("/:owner/:project/frequency/frequency.csv", webAuth,
+		exportFrequencyCSV).Name("frequency-csv")
+	m.Get("/:owner/:project/webhooks", webAuth, webhooksPage).
+		Name("webhooks")
+	m.Post("/:owner/:project/webhooks", webAuth, webhooksPage)
+	m.Post("/:owner/:project/webhooks/:id/delete", webAuth, deleteWebhook).
+		Name("webhook-delete")
+	m.Post("/:owner/:project/webhooks/:id/ping", webAuth, pingWebhook).
+		Name("webhook-ping")
+	m.Post("/:owner/:project/webhooks/deliveries/:id/retry", webAuth,
+		retryDelivery).Name("webhook-retry")
+	m.Get("/:owner/:project/import", webAuth, importScoresPage).
+		Name("score-import")
+	m.Post("/:owner/:project/import", webAuth, importScoresPage)
+	m.Post("/:owner/:project/import/errors.csv", webAuth,
+		importScoresErrors).Name("score-import-errors")
+	m.Get("/:owner/:project/import/annotations", webAuth,
+		importAnnotationsPage).Name("annotation-import")
+	m.Post("/:owner/:project/import/annotations", webAuth,
+		importAnnotationsPage)
 	m.Post("/document/upload", webAuth, uploadDocument).Name("document-upload")
+	m.Post("/document/score", jsonResp, webAuth, saveScore).
+		Name("document-score")
+	m.Get("/:owner/:project/:document/:recorded", webAuth, viewDocument).
+		Name("document")
+	m.Get("/:owner/:project/:document/:recorded/report.pdf", webAuth,
+		documentReport).Name("document-report")
+	m.Get("/:owner/:project/:document/:recorded/events", webAuth,
+		documentEvents).Name("document-events")
+	m.Get("/:owner/:project/:document/:recorded/metadata", webAuth,
+		editDocumentMetadata).Name("document-metadata")
+	m.Post("/:owner/:project/:document/:recorded/metadata", webAuth,
+		editDocumentMetadata)
+	m.Get("/:owner/:project/:document/:recorded/categories", webAuth,
+		editDocumentCategories).Name("document-categories")
+	m.Post("/:owner/:project/:document/:recorded/categories", webAuth,
+		editDocumentCategories)
+	m.Post("/:owner/:project/:document/:recorded/adjudicate", webAuth,
+		adjudicateDocument).Name("document-adjudicate")
 
 	m.Run()
 }
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// The OpenAPI document of the API is generated from the routes registered
+// by apiRoutes. Each route is described by an entry in apiSpecs that names
+// the types its handler decodes and responds with, and the schemas of those
+// types are generated by reflection. So the document can't drift from the
+// code, except by a handler decoding a different type than its entry says.
+
+// apiSpec describes an API route. Query is decoded from the query string,
+// Body is decoded from the JSON body of the request and Response is the
+// type encoded in a successful response. Any of them may be nil.
+type apiSpec struct {
+	Summary  string
+	Query    interface{}
+	Body     interface{}
+	Response interface{}
+
+	// Status is the status code of a successful response. It is 200 when
+	// zero.
+	Status int
+
+	// Paged is true for lists described by the headers of listPage.
+	Paged bool
+}
+
+// apiSpecs maps the names of API routes to their descriptions.
+var apiSpecs = map[string]apiSpec{
+	"api-openapi": {
+		Summary: "This OpenAPI document (without an envelope).",
+	},
+	"api-schemes": {
+		Summary:  "List the scoring schemes and their categories.",
+		Response: []apiScheme{},
+	},
+	"api-projects": {
+		Summary:  "List the projects you own.",
+		Query:    projectListOptions{},
+		Response: []apiProject{},
+		Paged:    true,
+	},
+	"api-project-add": {
+		Summary:  "Create a project.",
+		Body:     apiFormProject{},
+		Response: apiProject{},
+		Status:   http.StatusCreated,
+	},
+	"api-project": {
+		Summary:  "Get a project.",
+		Response: apiProject{},
+	},
+	"api-project-delete": {
+		Summary: "Delete a project with all of its documents and scores.",
+		Status:  http.StatusNoContent,
+	},
+	"api-collaborators": {
+		Summary:  "List the collaborators of a project.",
+		Response: []apiUser{},
+	},
+	"api-collaborators-set": {
+		Summary:  "Replace the collaborators of a project.",
+		Body:     apiFormCollaborators{},
+		Response: []apiUser{},
+	},
+	"api-documents": {
+		Summary:  "List the documents in a project.",
+		Query:    documentListOptions{},
+		Response: []apiListedDocument{},
+		Paged:    true,
+	},
+	"api-document-add": {
+		Summary:  "Add a document to a project.",
+		Body:     apiFormDocument{},
+		Response: apiDocumentDetail{},
+		Status:   http.StatusCreated,
+	},
+	"api-document": {
+		Summary:  "Get a document with its content, schemes and metadata.",
+		Response: apiDocumentDetail{},
+	},
+	"api-tokens": {
+		Summary:  "List the words of a document.",
+		Response: []token{},
+	},
+	"api-scores": {
+		Summary:  "List the scores of a document.",
+		Response: []apiScore{},
+	},
+	"api-score-set": {
+		Summary:  "Set the score of a word in a scheme.",
+		Body:     apiFormScore{},
+		Response: apiScore{},
+	},
+	"api-score-delete": {
+		Summary: "Remove the score of a word in a scheme.",
+		Status:  http.StatusNoContent,
+	},
+	"api-export-scores": {
+		Summary:  "Export the scores of a project.",
+		Query:    exportFilter{},
+		Response: []apiExportScore{},
+	},
+	"api-export-summary": {
+		Summary:  "Export a summary of each document in a project.",
+		Query:    summaryOptions{},
+		Response: []apiExportSummary{},
+	},
+}
+
+// apiPathParams describes the parameters that appear in the paths of API
+// routes.
+var apiPathParams = map[string]m{
+	"owner": {
+		"description": "Id of the user who owns the project.",
+		"schema":      m{"type": "string"},
+	},
+	"project": {
+		"description": "Name of the project.",
+		"schema":      m{"type": "string"},
+	},
+	"document": {
+		"description": "Name of the document.",
+		"schema":      m{"type": "string"},
+	},
+	"recorded": {
+		"description": "Date the document was recorded.",
+		"schema":      m{"type": "string", "format": "date"},
+	},
+	"scheme": {
+		"description": "Name of a scoring scheme.",
+		"schema":      m{"type": "string"},
+	},
+	"word": {
+		"description": "Index of a word in the document.",
+		"schema":      m{"type": "integer"},
+	},
+}
+
+var reRouteParam = regexp.MustCompile(`:(\w+)`)
+
+// openAPIDocument serves the OpenAPI document of the API. It isn't wrapped
+// in an envelope so that tools can read it.
+func openAPIDocument(w *web) {
+	w.ren.JSON(200, newOpenAPI(w.routes.All()))
+}
+
+// newOpenAPI returns an OpenAPI 3 document describing the API routes among
+// `routes`.
+func newOpenAPI(routes []martini.Route) m {
+	gen := &schemaGen{schemas: m{
+		"Error": m{
+			"type":     "object",
+			"required": []string{"status", "message"},
+			"properties": m{
+				"status": m{
+					"type": "string",
+					"enum": []string{"noauth", "fail", "error"},
+				},
+				"message": m{"type": "string"},
+			},
+		},
+	}}
+	paths := m{}
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Pattern(), apiPrefix+"/") {
+			continue
+		}
+		spec, ok := apiSpecs[route.GetName()]
+		if !ok {
+			continue
+		}
+
+		params := make([]m, 0)
+		path := reRouteParam.ReplaceAllStringFunc(
+			strings.TrimPrefix(route.Pattern(), apiPrefix),
+			func(param string) string {
+				name := param[1:]
+				p := m{"name": name, "in": "path", "required": true}
+				for k, v := range apiPathParams[name] {
+					p[k] = v
+				}
+				params = append(params, p)
+				return "{" + name + "}"
+			})
+		if spec.Query != nil {
+			params = append(params, gen.queryParams(spec.Query)...)
+		}
+
+		op := m{
+			"operationId": route.GetName(),
+			"summary":     spec.Summary,
+			"parameters":  params,
+			"responses":   gen.responses(spec),
+		}
+		if spec.Body != nil {
+			op["requestBody"] = m{
+				"required": true,
+				"content": m{
+					"application/json": m{"schema": gen.schema(spec.Body)},
+				},
+			}
+		}
+		if route.GetName() == "api-openapi" {
+			op["security"] = []m{}
+		}
+
+		item, ok := paths[path].(m)
+		if !ok {
+			item = m{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method())] = op
+	}
+	return m{
+		"openapi": "3.0.3",
+		"info": m{
+			"title":   "Linguistic Category Model API",
+			"version": strconv.Itoa(apiVersion),
+		},
+		"servers": []m{{"url": apiPrefix}},
+		"paths":   paths,
+		"components": m{
+			"schemas": gen.schemas,
+			"securitySchemes": m{
+				"token": m{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []m{{"token": []string{}}},
+	}
+}
+
+func (gen *schemaGen) responses(spec apiSpec) m {
+	status := spec.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := m{"description": http.StatusText(status)}
+	if spec.Response != nil {
+		envelope := m{
+			"type":     "object",
+			"required": []string{"status", "content"},
+			"properties": m{
+				"status":  m{"type": "string", "enum": []string{"success"}},
+				"content": gen.schema(spec.Response),
+			},
+		}
+		success["content"] = m{
+			"application/json": m{"schema": envelope},
+		}
+	}
+	if spec.Paged {
+		integer := m{"schema": m{"type": "integer"}}
+		success["headers"] = m{
+			"X-Total-Count": integer,
+			"X-Page":        integer,
+			"X-Per-Page":    integer,
+			"Link": m{
+				"description": "URLs of the next and previous pages.",
+				"schema":      m{"type": "string"},
+			},
+		}
+	}
+	return m{
+		strconv.Itoa(status): success,
+		"default": m{
+			"description": "An error.",
+			"content": m{
+				"application/json": m{
+					"schema": m{"$ref": "#/components/schemas/Error"},
+				},
+			},
+		},
+	}
+}
+
+// queryParams describes the fields of a struct decoded from a query string.
+// Slices are given by repeating the parameter.
+func (gen *schemaGen) queryParams(v interface{}) []m {
+	t := reflect.TypeOf(v)
+	params := make([]m, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		params = append(params, m{
+			"name":   f.Name,
+			"in":     "query",
+			"schema": gen.typeSchema(f.Type),
+		})
+	}
+	return params
+}
+
+// schemaGen generates schemas for the types used by the API. Structs are
+// added to `schemas` (so that they can be shared) and referred to by name.
+type schemaGen struct {
+	schemas m
+}
+
+func (gen *schemaGen) schema(v interface{}) m {
+	return gen.typeSchema(reflect.TypeOf(v))
+}
+
+func (gen *schemaGen) typeSchema(t reflect.Type) m {
+	if t == reflect.TypeOf(time.Time{}) {
+		return m{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := gen.typeSchema(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return m{"allOf": []m{s}, "nullable": true}
+		}
+		s["nullable"] = true
+		return s
+	case reflect.String:
+		return m{"type": "string"}
+	case reflect.Bool:
+		return m{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return m{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return m{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return m{"type": "array", "items": gen.typeSchema(t.Elem())}
+	case reflect.Map:
+		return m{
+			"type":                 "object",
+			"additionalProperties": gen.typeSchema(t.Elem()),
+		}
+	case reflect.Interface:
+		return m{}
+	case reflect.Struct:
+		name := schemaName(t)
+		if _, ok := gen.schemas[name]; !ok {
+			// Reserve the name first in case the type refers to itself.
+			gen.schemas[name] = m{}
+			props, required := m{}, make([]string, 0)
+			gen.fields(t, props, &required)
+			gen.schemas[name] = m{
+				"type":       "object",
+				"properties": props,
+				"required":   required,
+			}
+		}
+		return m{"$ref": "#/components/schemas/" + name}
+	}
+	panic(ef("Cannot describe type %s in the OpenAPI document.", t))
+}
+
+// fields describes the fields of a struct as encoding/json encodes them.
+// The fields of embedded structs are included in the struct's own fields.
+func (gen *schemaGen) fields(t reflect.Type, props m, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := f.Name, ""
+		if tag := f.Tag.Get("json"); len(tag) > 0 {
+			pieces := strings.SplitN(tag, ",", 2)
+			if pieces[0] == "-" {
+				continue
+			}
+			if len(pieces[0]) > 0 {
+				name = pieces[0]
+			}
+			if len(pieces) > 1 {
+				opts = pieces[1]
+			}
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && name == f.Name {
+			gen.fields(f.Type, props, required)
+			continue
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		props[name] = gen.typeSchema(f.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// schemaName names the schema of a struct after its type, without the
+// `api` prefix: `apiProject` becomes `Project`.
+func schemaName(t reflect.Type) string {
+	name := strings.TrimPrefix(t.Name(), "api")
+	if len(name) == 0 {
+		return name
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
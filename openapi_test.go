@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/go-martini/martini"
+)
+
+func apiTestRouter() martini.Router {
+	r := martini.NewRouter()
+	r.Group(apiPrefix, apiRoutes)
+	return r
+}
+
+// Every API route must be described in apiSpecs, and every entry in
+// apiSpecs must be a route.
+func TestOpenAPICoversRoutes(t *testing.T) {
+	routes := apiTestRouter().All()
+	if len(routes) == 0 {
+		t.Fatal("apiRoutes registered no routes")
+	}
+	names := make(map[string]bool)
+	for _, route := range routes {
+		name := route.GetName()
+		if len(name) == 0 {
+			t.Errorf("%s %s has no name", route.Method(), route.Pattern())
+			continue
+		}
+		names[name] = true
+		if _, ok := apiSpecs[name]; !ok {
+			t.Errorf("%s %s (%s) has no entry in apiSpecs",
+				route.Method(), route.Pattern(), name)
+		}
+		for _, param := range reRouteParam.FindAllStringSubmatch(
+			route.Pattern(), -1) {
+			if _, ok := apiPathParams[param[1]]; !ok {
+				t.Errorf("parameter %s of %s has no entry in apiPathParams",
+					param[1], route.Pattern())
+			}
+		}
+	}
+	for name := range apiSpecs {
+		if !names[name] {
+			t.Errorf("apiSpecs describes %s, which is not a route", name)
+		}
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	routes := apiTestRouter().All()
+	doc := newOpenAPI(routes)
+	bs, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		Paths      map[string]map[string]interface{}
+		Components struct {
+			Schemas map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(bs, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	ops := 0
+	for _, item := range decoded.Paths {
+		ops += len(item)
+	}
+	if ops != len(routes) {
+		t.Errorf("document has %d operations, but there are %d routes",
+			ops, len(routes))
+	}
+	doc2 := decoded.Paths["/projects/{owner}/{project}/documents"]
+	if _, ok := doc2["post"]; !ok {
+		t.Errorf("document has no POST for documents: %v", doc2)
+	}
+	for _, name := range []string{"Project", "DocumentDetail", "Error"} {
+		if _, ok := decoded.Components.Schemas[name]; !ok {
+			t.Errorf("document has no schema for %s", name)
+		}
+	}
+}
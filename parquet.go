@@ -0,0 +1,571 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+)
+
+// This file implements a writer for Apache Parquet files. The format is
+// documented at https://github.com/apache/parquet-format.
+//
+// Only what our exports need is supported: flat schemas of required or
+// optional columns, no compression, PLAIN encoding and dictionary encoding
+// for strings. Rows are buffered in memory until a row group is full, so
+// files of any size can be streamed.
+
+// Physical types.
+const (
+	parquetInt32     = 1
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Converted (logical) types. parquetNone means a column has no logical type.
+const (
+	parquetNone            = -1
+	parquetUTF8            = 0
+	parquetDate            = 6
+	parquetTimestampMillis = 9
+)
+
+// Encodings.
+const (
+	parquetPlain           = 0
+	parquetPlainDictionary = 2
+	parquetRLE             = 3
+)
+
+// parquetRowGroupSize is the number of rows buffered before a row group is
+// written.
+const parquetRowGroupSize = 100000
+
+// parquetColumn describes a column in a Parquet file.
+//
+// The values written to a column depend on its type: int for INT32 columns,
+// time.Time for DATE columns (stored as days since the Unix epoch), time.Time
+// for TIMESTAMP_MILLIS columns, float64 for DOUBLE columns and string for
+// BYTE_ARRAY columns. Optional columns also accept nil.
+type parquetColumn struct {
+	Name       string
+	Type       int
+	Converted  int
+	Optional   bool
+	Dictionary bool
+}
+
+func parquetString(name string, optional, dictionary bool) parquetColumn {
+	return parquetColumn{name, parquetByteArray, parquetUTF8, optional,
+		dictionary}
+}
+
+func parquetInt(name string, optional bool) parquetColumn {
+	return parquetColumn{name, parquetInt32, parquetNone, optional, false}
+}
+
+func parquetFloat(name string, optional bool) parquetColumn {
+	return parquetColumn{name, parquetDouble, parquetNone, optional, false}
+}
+
+func parquetDateColumn(name string) parquetColumn {
+	return parquetColumn{name, parquetInt32, parquetDate, false, false}
+}
+
+func parquetTimestamp(name string) parquetColumn {
+	return parquetColumn{name, parquetInt64, parquetTimestampMillis, false,
+		false}
+}
+
+// parquetWriter writes rows to a Parquet file. Close must be called to write
+// the file's footer.
+type parquetWriter struct {
+	w         *countingWriter
+	cols      []parquetColumn
+	meta      map[string]string
+	buffered  [][]interface{}
+	rows      int
+	totalRows int64
+	groups    []parquetRowGroup
+	err       error
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int
+	size   int64
+}
+
+type parquetChunk struct {
+	col        parquetColumn
+	encodings  []int
+	values     int
+	size       int64
+	dataOffset int64
+	dictOffset int64 // or -1 if there's no dictionary page
+}
+
+// countingWriter keeps track of how many bytes have been written, since
+// the footer refers to pages by their offset in the file.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(bs []byte) (int, error) {
+	n, err := cw.w.Write(bs)
+	cw.n += int64(n)
+	return n, err
+}
+
+// newParquetWriter starts a Parquet file with the given columns. The key/value
+// pairs in `meta` are stored in the file's footer.
+func newParquetWriter(
+	w io.Writer,
+	cols []parquetColumn,
+	meta map[string]string,
+) *parquetWriter {
+	pw := &parquetWriter{
+		w:        &countingWriter{w: w},
+		cols:     cols,
+		meta:     meta,
+		buffered: make([][]interface{}, len(cols)),
+	}
+	_, pw.err = io.WriteString(pw.w, "PAR1")
+	return pw
+}
+
+// writeRow adds a row with one value for each column.
+func (pw *parquetWriter) writeRow(values ...interface{}) error {
+	if pw.err != nil {
+		return pw.err
+	}
+	if len(values) != len(pw.cols) {
+		return fmt.Errorf("Expected %d values in row but got %d.",
+			len(pw.cols), len(values))
+	}
+	for i, v := range values {
+		if v == nil && !pw.cols[i].Optional {
+			return fmt.Errorf("Column '%s' is required.", pw.cols[i].Name)
+		}
+	}
+	for i, v := range values {
+		pw.buffered[i] = append(pw.buffered[i], v)
+	}
+	pw.rows++
+	if pw.rows >= parquetRowGroupSize {
+		pw.writeRowGroup()
+	}
+	return pw.err
+}
+
+// close writes any buffered rows and the footer of the file.
+func (pw *parquetWriter) close() error {
+	if pw.rows > 0 || len(pw.groups) == 0 {
+		pw.writeRowGroup()
+	}
+	if pw.err != nil {
+		return pw.err
+	}
+	footer := pw.footer()
+	if _, err := pw.w.Write(footer); err != nil {
+		return err
+	}
+	if err := binary.Write(pw.w, binary.LittleEndian,
+		uint32(len(footer))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(pw.w, "PAR1")
+	return err
+}
+
+func (pw *parquetWriter) writeRowGroup() {
+	if pw.err != nil {
+		return
+	}
+	group := parquetRowGroup{rows: pw.rows}
+	for i, col := range pw.cols {
+		chunk := pw.writeChunk(col, pw.buffered[i])
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.size
+		pw.buffered[i] = pw.buffered[i][:0]
+	}
+	pw.groups = append(pw.groups, group)
+	pw.totalRows += int64(pw.rows)
+	pw.rows = 0
+}
+
+// writeChunk writes all values of a column in the current row group as a
+// single data page, preceded by a dictionary page if the column uses one.
+func (pw *parquetWriter) writeChunk(
+	col parquetColumn,
+	values []interface{},
+) parquetChunk {
+	chunk := parquetChunk{
+		col:        col,
+		values:     len(values),
+		dictOffset: -1,
+		encodings:  []int{parquetPlain, parquetRLE},
+	}
+	start := pw.w.n
+
+	page := new(bytes.Buffer)
+	if col.Optional {
+		levels := make([]int, len(values))
+		for i, v := range values {
+			if v != nil {
+				levels[i] = 1
+			}
+		}
+		encoded := parquetRLEHybrid(levels, 1)
+		binary.Write(page, binary.LittleEndian, uint32(len(encoded)))
+		page.Write(encoded)
+	}
+
+	encoding := parquetPlain
+	if col.Dictionary {
+		dict, indices := parquetDictionary(values)
+		plain := new(bytes.Buffer)
+		for _, s := range dict {
+			pw.plain(plain, col, s)
+		}
+		chunk.dictOffset = pw.w.n
+		pw.page(2, plain.Bytes(), func(t *thriftWriter) {
+			t.structBegin(7)
+			t.i32(1, int32(len(dict)))
+			t.i32(2, parquetPlainDictionary)
+			t.structEnd()
+		})
+
+		width := 1
+		for (1 << uint(width)) < len(dict) {
+			width++
+		}
+		page.WriteByte(byte(width))
+		page.Write(parquetRLEHybrid(indices, width))
+		encoding = parquetPlainDictionary
+		chunk.encodings = append(chunk.encodings, parquetPlainDictionary)
+	} else {
+		for _, v := range values {
+			if v != nil {
+				pw.plain(page, col, v)
+			}
+		}
+	}
+
+	chunk.dataOffset = pw.w.n
+	pw.page(0, page.Bytes(), func(t *thriftWriter) {
+		t.structBegin(5)
+		t.i32(1, int32(len(values)))
+		t.i32(2, int32(encoding))
+		t.i32(3, parquetRLE)
+		t.i32(4, parquetRLE)
+		t.structEnd()
+	})
+	chunk.size = pw.w.n - start
+	return chunk
+}
+
+// page writes a page header followed by the page's data. `header` writes the
+// header specific to the type of page.
+func (pw *parquetWriter) page(
+	typ int32,
+	data []byte,
+	header func(t *thriftWriter),
+) {
+	if pw.err != nil {
+		return
+	}
+	t := newThriftWriter()
+	t.i32(1, typ)
+	t.i32(2, int32(len(data)))
+	t.i32(3, int32(len(data)))
+	header(t)
+	t.stop()
+	if _, pw.err = pw.w.Write(t.buf.Bytes()); pw.err != nil {
+		return
+	}
+	_, pw.err = pw.w.Write(data)
+}
+
+// plain writes a single non-null value with PLAIN encoding.
+func (pw *parquetWriter) plain(
+	buf *bytes.Buffer,
+	col parquetColumn,
+	v interface{},
+) {
+	var err error
+	switch col.Type {
+	case parquetInt32:
+		switch val := v.(type) {
+		case int:
+			err = binary.Write(buf, binary.LittleEndian, int32(val))
+		case time.Time:
+			days := val.Unix() / (24 * 60 * 60)
+			if val.Unix()%(24*60*60) < 0 {
+				days-- // round towards negative infinity
+			}
+			err = binary.Write(buf, binary.LittleEndian, int32(days))
+		default:
+			err = fmt.Errorf("Invalid INT32 value of type %T.", v)
+		}
+	case parquetInt64:
+		switch val := v.(type) {
+		case int64:
+			err = binary.Write(buf, binary.LittleEndian, val)
+		case time.Time:
+			millis := val.UnixNano() / int64(time.Millisecond)
+			err = binary.Write(buf, binary.LittleEndian, millis)
+		default:
+			err = fmt.Errorf("Invalid INT64 value of type %T.", v)
+		}
+	case parquetDouble:
+		f, ok := v.(float64)
+		if !ok {
+			err = fmt.Errorf("Invalid DOUBLE value of type %T.", v)
+		} else {
+			err = binary.Write(buf, binary.LittleEndian, math.Float64bits(f))
+		}
+	case parquetByteArray:
+		s, ok := v.(string)
+		if !ok {
+			err = fmt.Errorf("Invalid BYTE_ARRAY value of type %T.", v)
+		} else {
+			binary.Write(buf, binary.LittleEndian, uint32(len(s)))
+			buf.WriteString(s)
+		}
+	}
+	if err != nil && pw.err == nil {
+		pw.err = err
+	}
+}
+
+// parquetDictionary returns the distinct non-null string values (sorted) and
+// the index into them of each non-null value.
+func parquetDictionary(values []interface{}) ([]interface{}, []int) {
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if v != nil {
+			seen[v.(string)] = true
+		}
+	}
+	strs := make([]string, 0, len(seen))
+	for s := range seen {
+		strs = append(strs, s)
+	}
+	sort.Strings(strs)
+
+	dict := make([]interface{}, len(strs))
+	index := make(map[string]int, len(strs))
+	for i, s := range strs {
+		dict[i] = s
+		index[s] = i
+	}
+	indices := make([]int, 0, len(values))
+	for _, v := range values {
+		if v != nil {
+			indices = append(indices, index[v.(string)])
+		}
+	}
+	return dict, indices
+}
+
+// parquetRLEHybrid encodes values of the given bit width with the RLE/bit
+// packing hybrid encoding, using only RLE runs.
+func parquetRLEHybrid(values []int, width int) []byte {
+	buf := new(bytes.Buffer)
+	varint := make([]byte, binary.MaxVarintLen64)
+	valueBytes := (width + 7) / 8
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j] == values[i] {
+			j++
+		}
+		n := binary.PutUvarint(varint, uint64(j-i)<<1)
+		buf.Write(varint[0:n])
+		for b := 0; b < valueBytes; b++ {
+			buf.WriteByte(byte(values[i] >> uint(8*b)))
+		}
+		i = j
+	}
+	return buf.Bytes()
+}
+
+func (pw *parquetWriter) footer() []byte {
+	t := newThriftWriter()
+	t.i32(1, 1)
+
+	t.listBegin(2, thriftStruct, len(pw.cols)+1)
+	t.elemBegin()
+	t.binary(4, "schema")
+	t.i32(5, int32(len(pw.cols)))
+	t.elemEnd()
+	for _, col := range pw.cols {
+		t.elemBegin()
+		t.i32(1, int32(col.Type))
+		if col.Optional {
+			t.i32(3, 1)
+		} else {
+			t.i32(3, 0)
+		}
+		t.binary(4, col.Name)
+		if col.Converted != parquetNone {
+			t.i32(6, int32(col.Converted))
+		}
+		t.elemEnd()
+	}
+
+	t.i64(3, pw.totalRows)
+
+	t.listBegin(4, thriftStruct, len(pw.groups))
+	for _, group := range pw.groups {
+		t.elemBegin()
+		t.listBegin(1, thriftStruct, len(group.chunks))
+		for _, chunk := range group.chunks {
+			firstPage := chunk.dataOffset
+			if chunk.dictOffset >= 0 {
+				firstPage = chunk.dictOffset
+			}
+			t.elemBegin()
+			t.i64(2, firstPage)
+			t.structBegin(3)
+			t.i32(1, int32(chunk.col.Type))
+			t.listBegin(2, thriftI32, len(chunk.encodings))
+			for _, enc := range chunk.encodings {
+				t.elemI32(int32(enc))
+			}
+			t.listBegin(3, thriftBinary, 1)
+			t.elemBinary(chunk.col.Name)
+			t.i32(4, 0)
+			t.i64(5, int64(chunk.values))
+			t.i64(6, chunk.size)
+			t.i64(7, chunk.size)
+			t.i64(9, chunk.dataOffset)
+			if chunk.dictOffset >= 0 {
+				t.i64(11, chunk.dictOffset)
+			}
+			t.structEnd()
+			t.elemEnd()
+		}
+		t.i64(2, group.size)
+		t.i64(3, int64(group.rows))
+		t.elemEnd()
+	}
+
+	if len(pw.meta) > 0 {
+		keys := sortedKeys(pw.meta)
+		t.listBegin(5, thriftStruct, len(keys))
+		for _, key := range keys {
+			t.elemBegin()
+			t.binary(1, key)
+			t.binary(2, pw.meta[key])
+			t.elemEnd()
+		}
+	}
+	t.binary(6, "lcmweb")
+	t.stop()
+	return t.buf.Bytes()
+}
+
+// Types in the Thrift compact protocol.
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the Thrift compact protocol, which is
+// used for Parquet's page headers and footer. Field ids must be written in
+// increasing order within each struct.
+type thriftWriter struct {
+	buf    *bytes.Buffer
+	fields []int16
+}
+
+func newThriftWriter() *thriftWriter {
+	return &thriftWriter{buf: new(bytes.Buffer), fields: []int16{0}}
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	last := &t.fields[len(t.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		t.buf.WriteByte(typ)
+		t.varint(int64(id))
+	}
+	*last = id
+}
+
+func (t *thriftWriter) varint(n int64) {
+	t.uvarint(uint64((n << 1) ^ (n >> 63)))
+}
+
+func (t *thriftWriter) uvarint(n uint64) {
+	bs := make([]byte, binary.MaxVarintLen64)
+	t.buf.Write(bs[0:binary.PutUvarint(bs, n)])
+}
+
+func (t *thriftWriter) i32(id int16, n int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(n))
+}
+
+func (t *thriftWriter) i64(id int16, n int64) {
+	t.field(id, thriftI64)
+	t.varint(n)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.elemBinary(s)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+// stop ends the outermost struct.
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+}
+
+func (t *thriftWriter) listBegin(id int16, elemType byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		t.buf.WriteByte(0xF0 | elemType)
+		t.uvarint(uint64(size))
+	}
+}
+
+// elemBegin starts a struct that is an element of a list.
+func (t *thriftWriter) elemBegin() {
+	t.fields = append(t.fields, 0)
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.buf.WriteByte(0)
+	t.fields = t.fields[:len(t.fields)-1]
+}
+
+func (t *thriftWriter) elemI32(n int32) {
+	t.varint(int64(n))
+}
+
+func (t *thriftWriter) elemBinary(s string) {
+	t.uvarint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParquetWriter(t *testing.T) {
+	cols := []parquetColumn{
+		parquetString("document", false, true),
+		parquetDateColumn("recorded"),
+		parquetInt("word", false),
+		parquetString("surface", false, false),
+		parquetString("coder", true, true),
+		parquetFloat("proportion", true),
+		parquetTimestamp("timestamp"),
+	}
+	buf := new(bytes.Buffer)
+	pw := newParquetWriter(buf, cols, map[string]string{
+		"lcmweb.project": "andrew/test",
+		"lcmweb.scheme":  "lcm",
+	})
+	recorded := time.Date(1969, 12, 31, 0, 0, 0, 0, time.UTC)
+	scored := time.Date(2014, 3, 2, 15, 4, 5, 6e6, time.UTC)
+	rows := [][]interface{}{
+		{"Interview 1", recorded, 0, "The", "andrew", 0.25, scored},
+		{"Interview 1", recorded, 1, "cat", nil, nil, scored},
+		{"Ünïcode", recorded, 0, "sat", "andrew", 1.0, scored},
+		{"Interview 1", recorded, 2, "sat", "kim", 0.0, scored},
+	}
+	for _, row := range rows {
+		if err := pw.writeRow(row...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pw.writeRow(nil, recorded, 3, "", nil, nil, scored); err == nil {
+		t.Error("a null value in a required column was accepted")
+	}
+	// A rejected row must leave no values behind in earlier columns.
+	err := pw.writeRow("Interview 1", recorded, 3, nil, nil, nil, scored)
+	if err == nil {
+		t.Error("a null value in a later required column was accepted")
+	}
+	for i, col := range pw.buffered {
+		if len(col) != len(rows) {
+			t.Errorf("column %d has %d values, want %d",
+				i, len(col), len(rows))
+		}
+	}
+	if err := pw.close(); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "scores.parquet", buf.Bytes())
+}
+
+func TestParquetRLEHybrid(t *testing.T) {
+	got := parquetRLEHybrid([]int{1, 1, 1, 0, 2, 2}, 2)
+	want := []byte{3 << 1, 1, 1 << 1, 0, 2 << 1, 2}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// This file implements a minimal writer for PDF documents. The format is
+// described in the PDF Reference, sixth edition (PDF 1.7).
+//
+// Only what our reports need is supported: pages of text in Helvetica (one
+// of the standard fonts, so nothing needs to be embedded), filled
+// rectangles and lines. Text is limited to the characters of the Windows
+// code page 1252, which covers most Western European languages. Any other
+// character is replaced by a question mark.
+//
+// Coordinates are in points (1/72 inch) and are measured from the top left
+// corner of the page, which is more convenient for laying out text than the
+// bottom left corner that PDF uses.
+
+// Dimensions of an A4 page in points.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+// Fonts available to text. Their names are the resources used in content
+// streams.
+const (
+	pdfRegular = "F1"
+	pdfBold    = "F2"
+)
+
+type pdfColor struct {
+	R, G, B float64
+}
+
+var pdfBlack = pdfColor{0, 0, 0}
+
+// parsePDFColor converts a color of the form `#rrggbb`. Anything else is
+// black.
+func parsePDFColor(s string) pdfColor {
+	var r, g, b int
+	if _, err := fmt.Sscanf(s, "#%02x%02x%02x", &r, &g, &b); err != nil {
+		return pdfBlack
+	}
+	return pdfColor{float64(r) / 255, float64(g) / 255, float64(b) / 255}
+}
+
+// pdfDoc is a PDF document that is built in memory and written all at once.
+type pdfDoc struct {
+	Title   string
+	Created time.Time
+	pages   []*bytes.Buffer
+	cur     *bytes.Buffer
+}
+
+func newPDF(title string) *pdfDoc {
+	return &pdfDoc{Title: title, Created: time.Now().UTC()}
+}
+
+// addPage starts a new page. Everything drawn goes to the newest page.
+func (pdf *pdfDoc) addPage() {
+	pdf.cur = new(bytes.Buffer)
+	pdf.pages = append(pdf.pages, pdf.cur)
+}
+
+// pageCount returns the number of pages added so far.
+func (pdf *pdfDoc) pageCount() int {
+	return len(pdf.pages)
+}
+
+// setPage makes the i'th page (starting at zero) the one drawn on.
+func (pdf *pdfDoc) setPage(i int) {
+	pdf.cur = pdf.pages[i]
+}
+
+func (pdf *pdfDoc) printf(format string, v ...interface{}) {
+	fmt.Fprintf(pdf.cur, format, v...)
+}
+
+// text draws `s` with its baseline at `y`.
+func (pdf *pdfDoc) text(x, y float64, font string, size float64, s string) {
+	pdf.printf("BT /%s %.2f Tf %.2f %.2f Td (%s) Tj ET\n",
+		font, size, x, pdfPageHeight-y, pdfEscape(s))
+}
+
+// rect fills a rectangle whose top left corner is at (x, y).
+func (pdf *pdfDoc) rect(x, y, w, h float64, c pdfColor) {
+	pdf.printf("%.3f %.3f %.3f rg %.2f %.2f %.2f %.2f re f 0 g\n",
+		c.R, c.G, c.B, x, pdfPageHeight-y-h, w, h)
+}
+
+// line draws a straight line. `dash` gives the lengths of alternating dashes
+// and gaps, or is empty for a solid line.
+func (pdf *pdfDoc) line(
+	x1, y1, x2, y2, width float64,
+	dash []float64,
+	c pdfColor,
+) {
+	dashes := make([]string, len(dash))
+	for i, d := range dash {
+		dashes[i] = fmt.Sprintf("%.2f", d)
+	}
+	pdf.printf("q %.3f %.3f %.3f RG %.2f w [%s] 0 d "+
+		"%.2f %.2f m %.2f %.2f l S Q\n",
+		c.R, c.G, c.B, width, strings.Join(dashes, " "),
+		x1, pdfPageHeight-y1, x2, pdfPageHeight-y2)
+}
+
+// write writes the complete document.
+func (pdf *pdfDoc) write(w io.Writer) error {
+	buf := new(bytes.Buffer)
+	offsets := make([]int, 0)
+	obj := func(format string, v ...interface{}) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n", len(offsets))
+		fmt.Fprintf(buf, format, v...)
+		buf.WriteString("\nendobj\n")
+	}
+
+	// Objects 1 to 5 are fixed. Each page then has two objects: the page
+	// itself and its content stream.
+	kids := make([]string, len(pdf.pages))
+	for i := range pdf.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 %.2f %.2f] >>",
+		strings.Join(kids, " "), len(pdf.pages), pdfPageWidth, pdfPageHeight)
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica " +
+		"/Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold " +
+		"/Encoding /WinAnsiEncoding >>")
+	obj("<< /Title (%s) /Producer (lcmweb) /CreationDate (D:%s) >>",
+		pdfEscape(pdf.Title), pdf.Created.Format("20060102150405Z"))
+	for i, page := range pdf.pages {
+		obj("<< /Type /Page /Parent 2 0 R /Contents %d 0 R "+
+			"/Resources << /Font << /%s 3 0 R /%s 4 0 R >> >> >>",
+			7+2*i, pdfRegular, pdfBold)
+
+		compressed := new(bytes.Buffer)
+		zw := zlib.NewWriter(compressed)
+		if _, err := zw.Write(page.Bytes()); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		obj("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream",
+			compressed.Len(), compressed.Bytes())
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\n"+
+		"startxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// pdfEscape encodes `s` in code page 1252 as the contents of a PDF string.
+func pdfEscape(s string) string {
+	buf := new(bytes.Buffer)
+	for _, r := range s {
+		b := pdfWinAnsi(r)
+		if b == '\\' || b == '(' || b == ')' {
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(b)
+	}
+	return buf.String()
+}
+
+// pdfWinAnsi returns the code page 1252 byte for a character, or `?` if
+// there isn't one. White space is turned into a space.
+func pdfWinAnsi(r rune) byte {
+	switch {
+	case unicode.IsSpace(r):
+		return ' '
+	case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+		return byte(r)
+	}
+	for b, special := range pdfWinAnsiSpecial {
+		if special == r {
+			return byte(0x80 + b)
+		}
+	}
+	return '?'
+}
+
+// pdfWinAnsiSpecial maps bytes 0x80 to 0x9f of code page 1252 to
+// characters. Zeros are unused.
+var pdfWinAnsiSpecial = []rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+// textWidth returns the width of `s` in points. Widths come from the font
+// metrics of Helvetica for ASCII. Other characters are approximated by the
+// width of `N` or `n`.
+func (pdf *pdfDoc) textWidth(font string, size float64, s string) float64 {
+	widths := pdfHelveticaWidths
+	if font == pdfBold {
+		widths = pdfHelveticaBoldWidths
+	}
+	total := 0
+	for _, r := range s {
+		b := pdfWinAnsi(r)
+		switch {
+		case b >= 0x20 && b < 0x7f:
+			total += widths[b-0x20]
+		case unicode.IsUpper(r):
+			total += widths['N'-0x20]
+		default:
+			total += widths['n'-0x20]
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Widths of the ASCII characters from space to tilde in thousandths of the
+// font size.
+var pdfHelveticaWidths = []int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333,
+	278, 278, 556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278,
+	584, 584, 584, 556, 1015, 667, 667, 722, 722, 667, 611, 778, 722, 278,
+	500, 667, 556, 833, 722, 778, 667, 778, 722, 667, 611, 722, 667, 944,
+	667, 667, 611, 278, 278, 278, 469, 556, 333, 556, 556, 500, 556, 556,
+	278, 556, 556, 222, 222, 500, 222, 833, 556, 556, 556, 556, 333, 500,
+	278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var pdfHelveticaBoldWidths = []int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333,
+	278, 278, 556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333,
+	584, 584, 584, 611, 975, 722, 722, 722, 722, 667, 611, 778, 722, 278,
+	556, 722, 611, 833, 722, 778, 667, 778, 722, 667, 611, 722, 667, 944,
+	667, 667, 611, 333, 278, 333, 584, 556, 333, 556, 611, 556, 611, 556,
+	333, 611, 611, 278, 278, 556, 278, 889, 611, 611, 611, 611, 389, 556,
+	333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
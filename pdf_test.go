@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"testing"
+	"time"
+)
+
+var rePDFStream = regexp.MustCompile(
+	`(?s)<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*?)\nendstream`)
+
+func TestPDFWriter(t *testing.T) {
+	pdf := newPDF("Report (draft) for “Ünïcode” \\ €")
+	pdf.Created = time.Date(2014, 3, 2, 15, 4, 5, 0, time.UTC)
+	pdf.addPage()
+	pdf.text(40, 60, pdfBold, 14, "Abstraction (by document)")
+	pdf.rect(40, 80, 100, 12.5, parsePDFColor("#336699"))
+	pdf.line(40, 100, 200, 100, 0.5, []float64{2, 1}, pdfBlack)
+	pdf.addPage()
+	pdf.text(40, 60, pdfRegular, 10, "Naïve\tcafé — 日本")
+	pdf.setPage(0)
+	pdf.text(40, 800, pdfRegular, 8, fmt.Sprintf("Page 1 of %d",
+		pdf.pageCount()))
+
+	buf := new(bytes.Buffer)
+	if err := pdf.write(buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.Bytes()
+
+	// Every entry in the cross-reference table must point at its object.
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(got)
+	if m == nil {
+		t.Fatal("no startxref at the end of the file")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	entries := regexp.MustCompile(`(\d{10}) 00000 n `).FindAllSubmatch(
+		got[xref:], -1)
+	if len(entries) != 5+2*pdf.pageCount() {
+		t.Fatalf("%d objects in the xref table, want %d",
+			len(entries), 5+2*pdf.pageCount())
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		want := fmt.Sprintf("%d 0 obj\n", i+1)
+		if !bytes.HasPrefix(got[off:], []byte(want)) {
+			t.Errorf("object %d is not at offset %d", i+1, off)
+		}
+	}
+
+	// Content streams are compared uncompressed, so that the golden file
+	// doesn't depend on the output of the zlib package.
+	var streamErr error
+	readable := rePDFStream.ReplaceAllFunc(got, func(stream []byte) []byte {
+		m := rePDFStream.FindSubmatch(stream)
+		if n, _ := strconv.Atoi(string(m[1])); n != len(m[2]) {
+			streamErr = fmt.Errorf("stream has /Length %d but is %d bytes",
+				n, len(m[2]))
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(m[2]))
+		if err != nil {
+			streamErr = err
+			return stream
+		}
+		content, err := ioutil.ReadAll(zr)
+		if err != nil {
+			streamErr = err
+		}
+		return []byte(fmt.Sprintf(
+			"<< /Filter /FlateDecode >>\nstream\n%s\nendstream", content))
+	})
+	if streamErr != nil {
+		t.Fatal(streamErr)
+	}
+	checkGolden(t, "report.pdf.txt", readable)
+}
+
+func TestPDFTextWidth(t *testing.T) {
+	pdf := newPDF("")
+	if got := pdf.textWidth(pdfRegular, 10, "Hello"); got != 22.78 {
+		t.Errorf("width of Hello is %v, want 22.78", got)
+	}
+	if got := pdf.textWidth(pdfBold, 10, "Hi"); got != 10 {
+		t.Errorf("width of Hi is %v, want 10", got)
+	}
+}
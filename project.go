@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"sort"
 	"strings"
@@ -19,20 +20,32 @@ var (
 )
 
 func projects(w *web) {
+	var opts projectListOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+	projs, page := w.user.projects(opts)
 	data := m{
 		"js":    []string{"project"},
 		"Title": "Projects",
 		"Nav": w.mkNav(
 			nav{"Projects", w.routes.URLFor("project-list")},
 		),
-		"MyProjects": w.user.projects(),
+		"MyProjects": projs,
+		"Options":    opts,
+		"Page":       page,
 	}
 	w.html("projects", data)
 }
 
+// bitMyProjects shows the first page of projects. It is used to refresh the
+// list after adding a project.
 func bitMyProjects(w *web) {
+	opts := projectListOptions{Sort: "name"}
+	projs, page := w.user.projects(opts)
 	w.html("bit-myprojects", m{
-		"MyProjects": w.user.projects(),
+		"MyProjects": projs,
+		"Options":    opts,
+		"Page":       page,
 	})
 }
 
@@ -87,7 +100,13 @@ func manageCollaborators(w *web) {
 	}
 	w.decode(&form)
 	proj := getProject(w.user, w.user.Id, form.ProjectName)
+	proj.setCollaborators(form.Collaborators)
+	w.json(w.r.PostForm)
+}
 
+// setCollaborators replaces the collaborators of the project with the users
+// with the given ids.
+func (proj *project) setCollaborators(userids []string) {
 	// We need to do a delete followed by an insert, which means we need
 	// exclusion for this project to prevent race conditions.
 	lockKey := fmt.Sprintf("%s-%s", proj.Name, proj.Owner.Id)
@@ -101,7 +120,7 @@ func manageCollaborators(w *web) {
 			WHERE
 				project_owner = $1 AND project_name = $2
 		`, proj.Owner.Id, proj.Name)
-		for _, collaborator := range form.Collaborators {
+		for _, collaborator := range userids {
 			u := findUserById(collaborator)
 			csql.Exec(tx, `
 				INSERT INTO collaborator
@@ -111,8 +130,7 @@ func manageCollaborators(w *web) {
 			`, proj.Owner.Id, proj.Name, u.Id)
 		}
 	})
-
-	w.json(w.r.PostForm)
+	proj.collaborators = nil
 }
 
 func bitCollaborators(w *web) {
@@ -126,6 +144,7 @@ type project struct {
 	Display       string
 	Added         time.Time
 	collaborators []*lcmUser
+	numDocuments  *int
 }
 
 // insertProject will add the details given as a project to the database.
@@ -243,8 +262,22 @@ func (proj *project) isDuplicate() bool {
 	return n > 0
 }
 
+// NumDocuments returns the number of documents in the project. Projects
+// listed by (*lcmUser).projects already know it.
 func (proj *project) NumDocuments() int {
-	return 0
+	if proj.numDocuments != nil {
+		return *proj.numDocuments
+	}
+	n := csql.Count(db, `
+		SELECT
+			COUNT(*)
+		FROM
+			document
+		WHERE
+			project_owner = $1 AND project_name = $2
+		`, proj.Owner.Id, proj.Name)
+	proj.numDocuments = &n
+	return n
 }
 
 func (proj *project) IsCollaborator(user *lcmUser) bool {
@@ -286,23 +319,71 @@ func (proj *project) Collaborators() []*lcmUser {
 	return proj.collaborators
 }
 
-func (user *lcmUser) projects() []*project {
+// projectListOptions sorts and paginates the projects of a user.
+type projectListOptions struct {
+	// Sort is "name", "added", "documents" or "modified", prefixed by "-" to
+	// sort in descending order. A project is modified when any of its
+	// documents is.
+	Sort string
+
+	Page    int
+	PerPage int
+}
+
+func (opts *projectListOptions) defaults() {
+	if len(opts.Sort) == 0 {
+		opts.Sort = "name"
+	}
+}
+
+// SortURL returns the query string of the same list sorted by `by`.
+func (opts projectListOptions) SortURL(by string) string {
+	return "?" + url.Values{"Sort": {toggleSort(opts.Sort, by)}}.Encode()
+}
+
+var projectSorts = map[string]string{
+	"name":      "p.name",
+	"added":     "p.created",
+	"documents": "COUNT(d.name)",
+	"modified":  "GREATEST(p.created, MAX(d.modified))",
+}
+
+// projects returns a page of the projects owned by the user, with their
+// number of documents.
+func (user *lcmUser) projects(opts projectListOptions) ([]*project, listPage) {
+	opts.defaults()
+	page := newListPage(opts.Page, opts.PerPage, url.Values{
+		"Sort": {opts.Sort},
+	})
+	page.Total = csql.Count(db, `
+		SELECT COUNT(*) FROM project WHERE owner = $1
+	`, user.Id)
+
+	order := sortSQL(opts.Sort, projectSorts, "p.name ASC")
+	limit, args := page.limitSQL([]interface{}{user.Id})
 	projs := make([]*project, 0)
 	rows := csql.Query(db, `
 		SELECT
-			name, created
+			p.name, p.created, COUNT(d.name)
 		FROM
-			project
+			project p
+		LEFT JOIN
+			document d
+			ON d.project_owner = p.owner AND d.project_name = p.name
 		WHERE
-			owner = $1
+			p.owner = $1
+		GROUP BY
+			p.name, p.created
 		ORDER BY
-			name ASC
-	`, user.Id)
+			`+order+`
+		`+limit, args...)
 	csql.ForRow(rows, func(s csql.RowScanner) {
 		proj := &project{Owner: user}
-		csql.Scan(rows, &proj.Name, &proj.Added)
+		var n int
+		csql.Scan(rows, &proj.Name, &proj.Added, &n)
 		proj.Display = nameToDisplay(proj.Name)
+		proj.numDocuments = &n
 		projs = append(projs, proj)
 	})
-	return projs
+	return projs, page
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// reportOptions selects the scores shown in a document report and how they
+// are marked.
+type reportOptions struct {
+	Scheme string
+	Coder  string
+
+	// Style is "color" to highlight scored words with the color of their
+	// category, or "underline" to underline them with a different line for
+	// each category (for printing in black and white).
+	Style string
+}
+
+// reportUnderline is how words in a category are underlined. Categories use
+// these in the order of their scheme, starting over when there are more
+// categories than styles.
+type reportUnderline struct {
+	Width  float64
+	Dash   []float64
+	Double bool
+}
+
+var reportUnderlines = []reportUnderline{
+	{0.8, nil, false},
+	{0.8, []float64{3, 2}, false},
+	{1, []float64{0.8, 1.6}, false},
+	{0.6, nil, true},
+	{2, nil, false},
+	{1, []float64{4, 1.5, 1, 1.5}, false},
+}
+
+// Layout of the report in points.
+const (
+	reportMargin     = 50
+	reportFontSize   = 11
+	reportLineHeight = 17
+)
+
+// documentReport writes a PDF of a document with its scored words marked,
+// followed by a legend and totals for each category.
+func documentReport(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	d := getDocument(proj, w.params["document"], w.params["recorded"])
+	var opts reportOptions
+	w.decodeQuery(&opts)
+	if len(opts.Scheme) == 0 {
+		if len(d.Categories) == 0 {
+			panic(ue("Document **%s** isn't scored with any scoring scheme.",
+				d.Display))
+		}
+		opts.Scheme = d.Categories[0]
+	}
+	if !d.HasCategory(opts.Scheme) {
+		panic(ue("Document **%s** isn't scored with **%s**.",
+			d.Display, opts.Scheme))
+	}
+	sumOpts := summaryOptions{Scheme: opts.Scheme, Coder: opts.Coder}
+	scheme := sumOpts.scheme()
+
+	// Maps word indices to category keys.
+	scored := make(map[int]string)
+	sum := &documentSummary{
+		Document: d,
+		Scheme:   scheme,
+		Tokens:   len(d.Tokens()),
+		Counts:   make(map[string]int),
+	}
+	for _, s := range d.scores() {
+		if s.Category != opts.Scheme {
+			continue
+		}
+		if len(opts.Coder) > 0 && s.CreatedBy.Id != opts.Coder {
+			continue
+		}
+		scored[s.Word] = s.Name
+		sum.add(s.Name, 1)
+	}
+
+	r := &reportLayout{
+		pdf:    newPDF(fmt.Sprintf("%s (%s)", d.Display, d.RecordedKey())),
+		opts:   opts,
+		scheme: scheme,
+		index:  make(map[string]int),
+	}
+	for i, cat := range scheme.Ordered() {
+		r.index[cat.Key] = i
+		r.colors = append(r.colors, parsePDFColor(cat.Color))
+	}
+	r.pdf.addPage()
+	r.y = reportMargin
+	r.header(d, sumOpts)
+	r.legend(sum)
+	r.body(d, scored)
+	r.footers(d)
+
+	name := fmt.Sprintf("%s-%s-%s.pdf", d.Name, d.RecordedKey(), opts.Scheme)
+	w.attachment(name, "application/pdf")
+	assert(r.pdf.write(w.w))
+}
+
+// reportLayout keeps track of the position on the current page while a
+// report is laid out.
+type reportLayout struct {
+	pdf    *pdfDoc
+	opts   reportOptions
+	scheme configScoringScheme
+
+	// index maps category keys to their position in the scheme's order.
+	index  map[string]int
+	colors []pdfColor
+
+	x, y float64
+}
+
+func (r *reportLayout) right() float64 {
+	return pdfPageWidth - reportMargin
+}
+
+// advance moves down by `h`, starting a new page first if there isn't room.
+func (r *reportLayout) advance(h float64) {
+	if r.y+h > pdfPageHeight-reportMargin {
+		r.pdf.addPage()
+		r.y = reportMargin
+	}
+	r.y += h
+}
+
+func (r *reportLayout) header(d *document, opts summaryOptions) {
+	r.advance(18)
+	r.pdf.text(reportMargin, r.y, pdfBold, 18, d.Display)
+	lines := []string{
+		fmt.Sprintf("Project: %s", d.Project.Display),
+		fmt.Sprintf("Recorded: %s", d.RecordedKey()),
+		fmt.Sprintf("Scoring scheme: %s (version %s)",
+			opts.Scheme, r.scheme.VersionString()),
+		fmt.Sprintf("Scores from: %s", opts.Layer()),
+	}
+	r.advance(6)
+	for _, line := range lines {
+		r.advance(13)
+		r.pdf.text(reportMargin, r.y, pdfRegular, 10, line)
+	}
+	r.advance(10)
+}
+
+// legend shows how each category is marked, in the scheme's order, with the
+// number and proportion of words in it. The abstraction index follows.
+func (r *reportLayout) legend(sum *documentSummary) {
+	cols := []float64{reportMargin, reportMargin + 60, 300, 360, 430}
+	headers := []string{"Category", "Value", "Words", "Proportion"}
+	r.advance(14)
+	for i, h := range headers {
+		r.pdf.text(cols[i+1], r.y, pdfBold, 10, h)
+	}
+	for _, cat := range r.scheme.Ordered() {
+		r.advance(reportLineHeight)
+		r.mark(cat.Key, cols[0], r.y, 45, 10)
+		r.pdf.text(cols[1], r.y, pdfRegular, 10, cat.Name)
+		r.pdf.text(cols[2], r.y, pdfRegular, 10, strconv.Itoa(cat.Value))
+		r.pdf.text(cols[3], r.y, pdfRegular, 10,
+			strconv.Itoa(sum.Counts[cat.Key]))
+		r.pdf.text(cols[4], r.y, pdfRegular, 10,
+			fmt.Sprintf("%.3f", sum.Proportion(cat.Key)))
+	}
+
+	abstraction := "undefined (no words are scored)"
+	if abs, ok := sum.Abstraction(); ok {
+		abstraction = fmt.Sprintf("%.3f", abs)
+	}
+	r.advance(reportLineHeight + 4)
+	r.pdf.text(reportMargin, r.y, pdfRegular, 10, fmt.Sprintf(
+		"%d of %d words are scored. Abstraction index: %s",
+		sum.Scored(), sum.Tokens, abstraction))
+	r.advance(12)
+	r.pdf.line(reportMargin, r.y, r.right(), r.y, 0.5, nil,
+		pdfColor{0.6, 0.6, 0.6})
+	r.advance(8)
+}
+
+// mark draws the marking of a category for text whose baseline is at `y`.
+func (r *reportLayout) mark(cat string, x, y, width, size float64) {
+	i, ok := r.index[cat]
+	if !ok {
+		return
+	}
+	if r.opts.Style == "underline" {
+		u := reportUnderlines[i%len(reportUnderlines)]
+		r.pdf.line(x, y+2, x+width, y+2, u.Width, u.Dash, pdfBlack)
+		if u.Double {
+			r.pdf.line(x, y+3.8, x+width, y+3.8, u.Width, u.Dash, pdfBlack)
+		}
+		return
+	}
+	r.pdf.rect(x-1, y-size*0.85, width+2, size*1.15, r.colors[i])
+}
+
+// body lays out the content of the document. Line breaks in the content are
+// kept and blank lines separate paragraphs. Scored words are marked.
+func (r *reportLayout) body(d *document, scored map[int]string) {
+	toks := d.Tokens()
+	next := 0
+	space := r.pdf.textWidth(pdfRegular, reportFontSize, " ")
+	isSpace := func(i int) (bool, int) {
+		c, size := utf8.DecodeRuneInString(d.Content[i:])
+		return unicode.IsSpace(c), size
+	}
+
+	r.x = reportMargin
+	r.advance(reportLineHeight)
+	content := d.Content
+	for i := 0; i < len(content); {
+		j := i
+		if sp, _ := isSpace(i); sp {
+			newlines := 0
+			for j < len(content) {
+				sp, size := isSpace(j)
+				if !sp {
+					break
+				}
+				if content[j] == '\n' {
+					newlines++
+				}
+				j += size
+			}
+			switch {
+			case newlines >= 2:
+				r.x = reportMargin
+				r.advance(reportLineHeight * 1.5)
+			case newlines == 1:
+				r.x = reportMargin
+				r.advance(reportLineHeight)
+			case r.x > reportMargin:
+				r.x += space
+			}
+			i = j
+			continue
+		}
+		for j < len(content) {
+			sp, size := isSpace(j)
+			if sp {
+				break
+			}
+			j += size
+		}
+		run := content[i:j]
+		width := r.pdf.textWidth(pdfRegular, reportFontSize, run)
+		if r.x > reportMargin && r.x+width > r.right() {
+			r.x = reportMargin
+			r.advance(reportLineHeight)
+		}
+		for ; next < len(toks) && toks[next].Start < j; next++ {
+			tok := toks[next]
+			if cat, ok := scored[tok.Index]; ok {
+				before := r.pdf.textWidth(pdfRegular, reportFontSize,
+					content[i:tok.Start])
+				r.mark(cat, r.x+before, r.y,
+					r.pdf.textWidth(pdfRegular, reportFontSize, tok.Text),
+					reportFontSize)
+			}
+		}
+		r.pdf.text(r.x, r.y, pdfRegular, reportFontSize, run)
+		r.x += width
+		i = j
+	}
+}
+
+// footers numbers each page once the total number of pages is known.
+func (r *reportLayout) footers(d *document) {
+	n := r.pdf.pageCount()
+	for i := 0; i < n; i++ {
+		r.pdf.setPage(i)
+		footer := fmt.Sprintf("%s (%s) - page %d of %d",
+			d.Display, d.RecordedKey(), i+1, n)
+		r.pdf.text(reportMargin, pdfPageHeight-reportMargin/2,
+			pdfRegular, 8, footer)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/BurntSushi/csql"
+	"github.com/BurntSushi/locker"
+)
+
+type formScore struct {
+	Owner    string
+	Project  string
+	Document string
+	Recorded string
+	Word     int
+	Category string
+	Name     string
+}
+
+// saveScore sets (or clears, when no name is given) the score of a single
+// word in a document. Every page viewing the document is notified.
+func saveScore(w *web) {
+	var form formScore
+	w.decode(&form)
+
+	proj := getProject(w.user, form.Owner, form.Project)
+	d := getDocument(proj, form.Document, form.Recorded)
+	if len(form.Name) == 0 {
+		d.deleteScore(w.user, form.Word, form.Category)
+		w.json(nil)
+		return
+	}
+	s, err := d.setScore(w.user, form.Word, form.Category, form.Name)
+	assert(err)
+	w.json(s.event(false))
+}
+
+type score struct {
+	Document  *document
+	Word      int
+	Category  string
+	Name      string
+	CreatedBy *lcmUser
+	Created   time.Time
+}
+
+// scoreEvent is the data sent to clients when a score changes.
+type scoreEvent struct {
+	Word      int
+	Category  string
+	Name      string
+	CreatedBy string
+	Deleted   bool
+}
+
+func (s *score) event(deleted bool) scoreEvent {
+	return scoreEvent{
+		Word:      s.Word,
+		Category:  s.Category,
+		Name:      s.Name,
+		CreatedBy: s.CreatedBy.Id,
+		Deleted:   deleted,
+	}
+}
+
+// setScore replaces the score of a word in the given category.
+func (d *document) setScore(
+	user *lcmUser,
+	word int,
+	category, name string,
+) (*score, error) {
+	s := &score{
+		Document:  d,
+		Word:      word,
+		Category:  category,
+		Name:      name,
+		CreatedBy: user,
+		Created:   time.Now().UTC(),
+	}
+
+	// The categories of the document are reloaded while locked so that
+	// they can't change between validation and saving the score.
+	d.lock()
+	defer d.unlock()
+	d.Categories = d.loadCategories()
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	replaced := false
+	csql.Tx(db, func(tx *sql.Tx) {
+		res := csql.Exec(tx, `
+			DELETE FROM score
+			WHERE project_owner = $1 AND project_name = $2
+				AND document_name = $3 AND document_recorded = $4
+				AND word = $5 AND category = $6
+			`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+			s.Word, s.Category)
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			replaced = true
+		}
+		csql.Exec(tx, `
+			INSERT INTO score (
+				project_owner, project_name, document_name, document_recorded,
+				word, category, name, created_by, created
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+			s.Word, s.Category, s.Name, s.CreatedBy.Id, s.Created)
+	})
+	docEvents.publish(d, eventScore, s.event(false))
+
+	// Only a score for a word that wasn't scored before can complete the
+	// document.
+	if !replaced && d.completed() {
+		d.Project.triggerHooks(hookDocumentCompleted, user, d)
+	}
+	return s, nil
+}
+
+// deleteScore removes the score of a word in the given category, if one
+// exists.
+func (d *document) deleteScore(user *lcmUser, word int, category string) {
+	d.lock()
+	defer d.unlock()
+
+	res := csql.Exec(db, `
+		DELETE FROM score
+		WHERE project_owner = $1 AND project_name = $2
+			AND document_name = $3 AND document_recorded = $4
+			AND word = $5 AND category = $6
+		`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+		word, category)
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		s := &score{Word: word, Category: category, CreatedBy: user}
+		docEvents.publish(d, eventScore, s.event(true))
+	}
+}
+
+// completed reports whether every word of the document is scored in every
+// scheme it is scored with.
+func (d *document) completed() bool {
+	units := len(d.Tokens()) * len(d.Categories)
+	scored := csql.Count(db, `
+		SELECT COUNT(*)
+		FROM score s
+		JOIN document_category dc
+			ON dc.project_owner = s.project_owner
+			AND dc.project_name = s.project_name
+			AND dc.document_name = s.document_name
+			AND dc.document_recorded = s.document_recorded
+			AND dc.category = s.category
+		WHERE s.project_owner = $1 AND s.project_name = $2
+			AND s.document_name = $3 AND s.document_recorded = $4
+		`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	return units > 0 && scored >= units
+}
+
+// validate checks that a score refers to a word in its document and to a
+// category in a known scoring scheme.
+func (s *score) validate() error {
+	if s.Word < 0 || s.Word >= len(s.Document.Tokens()) {
+		return ue("Word %d does not exist in document **%s**.",
+			s.Word, s.Document.Display)
+	}
+	scheme, ok := conf.Scores[s.Category]
+	if !ok {
+		return ue("Scoring scheme **%s** does not exist.", s.Category)
+	}
+	if !s.Document.HasCategory(s.Category) {
+		return ue("Document **%s** is not scored with the **%s** scheme.",
+			s.Document.Display, s.Category)
+	}
+	if _, ok := scheme.Categories[s.Name]; !ok {
+		return ue("**%s** is not a category in the **%s** scoring scheme.",
+			s.Name, s.Category)
+	}
+	return nil
+}
+
+// scores returns every score in the document. Scores in a category that was
+// removed from the document are not included.
+func (d *document) scores() []*score {
+	scores := make([]*score, 0)
+	rows := csql.Query(db, `
+		SELECT
+			s.word, s.category, s.name, s.created_by, s.created
+		FROM
+			score s
+		JOIN
+			document_category dc
+			ON s.project_owner = dc.project_owner
+			AND s.project_name = dc.project_name
+			AND s.document_name = dc.document_name
+			AND s.document_recorded = dc.document_recorded
+			AND s.category = dc.category
+		WHERE
+			s.project_owner = $1 AND s.project_name = $2
+			AND s.document_name = $3 AND s.document_recorded = $4
+		ORDER BY
+			s.word ASC, s.category ASC
+	`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		s := &score{Document: d}
+		var createdBy string
+		csql.Scan(row, &s.Word, &s.Category, &s.Name, &createdBy, &s.Created)
+		s.CreatedBy = findUserByNo(createdBy)
+		if s.CreatedBy == nil {
+			s.CreatedBy = &lcmUser{configUser{Id: createdBy, Name: createdBy}}
+		}
+		scores = append(scores, s)
+	})
+	return scores
+}
+
+// ScoreEvents returns every score in the document in the same form that
+// score changes are pushed to clients.
+func (d *document) ScoreEvents() []scoreEvent {
+	scores := d.scores()
+	evs := make([]scoreEvent, len(scores))
+	for i, s := range scores {
+		evs[i] = s.event(false)
+	}
+	return evs
+}
+
+func (d *document) lock() {
+	locker.Lock(d.eventKey())
+}
+
+func (d *document) unlock() {
+	locker.Unlock(d.eventKey())
+}
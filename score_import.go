@@ -0,0 +1,584 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// The status of each row of a score import, as shown in its preview.
+const (
+	importNew       = "new"
+	importChange    = "change"
+	importUnchanged = "unchanged"
+	importError     = "error"
+)
+
+// importColumns are the columns recognized in a CSV of scores. The
+// export of scores can be imported as is, since its other columns are
+// ignored.
+var importColumns = []string{
+	"document", "recorded", "word", "surface", "occurrence",
+	"scheme", "category", "coder",
+}
+
+// formScoreImport is posted both when a CSV is uploaded and when its
+// preview is applied. After the upload, the content of the CSV is carried
+// in Data so that the same rows are checked again.
+type formScoreImport struct {
+	UploadCSV string
+	Data      string
+	Scheme    string
+	Replace   bool
+	Apply     bool
+}
+
+// importScoresPage checks an uploaded CSV of scores and shows a preview of
+// the changes it would make. Once the preview is accepted, the scores are
+// saved.
+func importScoresPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	show := func(form formScoreImport, imp *scoreImport, msg string) {
+		w.html("score-import", m{
+			"Title":   "Import scores into " + proj.Display,
+			"Nav":     documentNav(w, proj, nil, "Import scores"),
+			"P":       proj,
+			"Form":    form,
+			"Import":  imp,
+			"Schemes": conf.Categories(),
+			"Columns": importColumns,
+			"Message": formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(formScoreImport{}, nil, "")
+		return
+	} else if w.r.Method != "POST" {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+
+	form := w.decodeScoreImport()
+	imp, err := proj.checkScoreImport(w.user, form.Data, form.Scheme)
+	if err != nil {
+		show(form, nil, err.Error())
+		return
+	}
+	if !form.Apply {
+		show(form, imp, "")
+		return
+	}
+	res, err := proj.applyScoreImport(w.user, form.Data, form.Scheme,
+		form.Replace)
+	if err != nil {
+		show(form, imp, err.Error())
+		return
+	}
+	show(formScoreImport{}, nil, fmt.Sprintf(
+		"Saved %d new and %d changed scores. %d scores were left as they "+
+			"were.", res.New, res.Changed, res.Skipped))
+}
+
+// importScoresErrors downloads the rows of a CSV of scores that can't be
+// imported, along with the reason for each.
+func importScoresErrors(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	form := w.decodeScoreImport()
+	imp, err := proj.checkScoreImport(w.user, form.Data, form.Scheme)
+	if err != nil {
+		panic(err)
+	}
+
+	w.attachment(proj.Name+"-import-errors.csv", "text/csv; charset=utf-8")
+	out := csv.NewWriter(w.w)
+	assert(out.Write(append([]string{"line", "error"}, imp.Header...)))
+	for _, row := range imp.Rows {
+		if row.Status != importError {
+			continue
+		}
+		record := []string{strconv.Itoa(row.Line), row.Error}
+		assert(out.Write(append(record, row.Record...)))
+	}
+	out.Flush()
+	assert(out.Error())
+}
+
+// decodeScoreImport decodes a score import form, reading the CSV from the
+// uploaded file when it isn't already in the form.
+func (w *web) decodeScoreImport() formScoreImport {
+	var form formScoreImport
+	w.multiDecode(&form)
+	if len(form.Data) > 0 {
+		return form
+	}
+	file, _, err := w.r.FormFile("UploadCSV")
+	if err != nil {
+		panic(ue("There was a problem uploading your CSV file: %s", err))
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		panic(ue("There was a problem reading your CSV file: %s", err))
+	}
+	form.Data = string(data)
+	return form
+}
+
+// scoreImport is a CSV of scores after every row has been checked.
+type scoreImport struct {
+	Header []string
+	Rows   []*importRow
+}
+
+// importRow is a single row of a score import. Rows that can't be imported
+// have the error status and a message saying why.
+type importRow struct {
+	// Line is the number of the row in the CSV file, counting the header
+	// as the first.
+	Line     int
+	Record   []string
+	Document *document
+	Word     int
+	Surface  string
+	Scheme   string
+	Category string
+	Coder    *lcmUser
+
+	// Existing is the score that the row would replace, if any.
+	Existing *score
+	Status   string
+	Error    string
+}
+
+// Count returns the number of rows with the given status.
+func (imp *scoreImport) Count(status string) int {
+	n := 0
+	for _, row := range imp.Rows {
+		if row.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Documents returns every document with a score in the import, ordered by
+// key.
+func (imp *scoreImport) Documents() []*document {
+	seen := make(map[string]bool)
+	docs := make([]*document, 0)
+	for _, row := range imp.Rows {
+		if row.Document == nil || seen[row.Document.Key()] {
+			continue
+		}
+		seen[row.Document.Key()] = true
+		docs = append(docs, row.Document)
+	}
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].Key() < docs[j].Key()
+	})
+	return docs
+}
+
+// checkScoreImport reads a CSV of scores and checks every row against the
+// documents of the project, their words and their scoring schemes. Rows
+// without a scheme use `scheme`.
+//
+// An error is returned only when the CSV can't be read at all. Problems
+// with individual rows are recorded in the rows.
+func (proj *project) checkScoreImport(
+	user *lcmUser,
+	data, scheme string,
+) (*scoreImport, error) {
+	chk := &importChecker{
+		proj:    proj,
+		user:    user,
+		scheme:  scheme,
+		members: proj.members(),
+		find: func(name string, recorded time.Time) *document {
+			return findDocument(proj, name, recorded)
+		},
+		scoresOf: (*document).scores,
+	}
+	return chk.read(data)
+}
+
+// importChecker holds what is looked up while checking the rows of an
+// import, so that each document and its scores are only loaded once.
+type importChecker struct {
+	proj    *project
+	user    *lcmUser
+	scheme  string
+	members []*lcmUser
+
+	// find and scoresOf look up a document and its scores. They are
+	// findDocument and document.scores outside of tests.
+	find     func(name string, recorded time.Time) *document
+	scoresOf func(d *document) []*score
+
+	cols   map[string]int
+	docs   map[string]*document
+	scores map[string]map[string]*score
+
+	// units maps every word and scheme already seen in the import to the
+	// line it is on.
+	units map[string]int
+}
+
+// read checks every row of a CSV of scores.
+func (chk *importChecker) read(data string) (*scoreImport, error) {
+	r := csv.NewReader(strings.NewReader(data))
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, ue("The CSV file is empty.")
+	} else if err != nil {
+		return nil, ue("Could not read the CSV file: %s", err)
+	}
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	chk.cols = make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := chk.cols[name]; !ok {
+			chk.cols[name] = i
+		}
+	}
+	for _, name := range []string{"document", "recorded", "category"} {
+		if _, ok := chk.cols[name]; !ok {
+			return nil, ue("The CSV file has no **%s** column.", name)
+		}
+	}
+	_, hasWord := chk.cols["word"]
+	_, hasSurface := chk.cols["surface"]
+	if !hasWord && !hasSurface {
+		return nil, ue("The CSV file needs a **word** or **surface** column.")
+	}
+
+	chk.docs = make(map[string]*document)
+	chk.scores = make(map[string]map[string]*score)
+	chk.units = make(map[string]int)
+	imp := &scoreImport{Header: header, Rows: make([]*importRow, 0)}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, ue("Could not read the CSV file: %s", err)
+		}
+		if len(strings.TrimSpace(strings.Join(record, ""))) == 0 {
+			continue
+		}
+		row := &importRow{Line: line, Record: record}
+		if err := chk.check(row); err != nil {
+			row.Status = importError
+			row.Error = plainError(err)
+		}
+		imp.Rows = append(imp.Rows, row)
+	}
+	if len(imp.Rows) == 0 {
+		return nil, ue("The CSV file has no scores.")
+	}
+	return imp, nil
+}
+
+// field returns the trimmed value of the named column in a record, or an
+// empty string if there is no such column.
+func (chk *importChecker) field(record []string, name string) string {
+	i, ok := chk.cols[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// check fills in a row from its record, or returns the reason it can't be
+// imported.
+func (chk *importChecker) check(row *importRow) error {
+	field := func(name string) string { return chk.field(row.Record, name) }
+
+	d, err := chk.document(field("document"), field("recorded"))
+	if err != nil {
+		return err
+	}
+	row.Document = d
+
+	row.Word, err = chk.word(d, field("word"), field("surface"),
+		field("occurrence"))
+	if err != nil {
+		return err
+	}
+	row.Surface = d.Tokens()[row.Word].Text
+
+	row.Scheme = field("scheme")
+	if len(row.Scheme) == 0 {
+		row.Scheme = chk.scheme
+	}
+	if len(row.Scheme) == 0 {
+		return ue("No scoring scheme was given.")
+	}
+	row.Category = schemeCategoryKey(row.Scheme, field("category"))
+	s := &score{
+		Document: d,
+		Word:     row.Word,
+		Category: row.Scheme,
+		Name:     row.Category,
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	if row.Coder, err = chk.coder(field("coder")); err != nil {
+		return err
+	}
+
+	unit := fmt.Sprintf("%s/%d/%s", d.Key(), row.Word, row.Scheme)
+	if line, ok := chk.units[unit]; ok {
+		return ue("Word %d is already scored with the **%s** scheme on "+
+			"line %d.", row.Word, row.Scheme, line)
+	}
+	chk.units[unit] = row.Line
+
+	row.Existing = chk.scores[d.Key()][fmt.Sprintf("%d/%s", row.Word,
+		row.Scheme)]
+	switch {
+	case row.Existing == nil:
+		row.Status = importNew
+	case row.Existing.Name == row.Category &&
+		row.Existing.CreatedBy.Id == row.Coder.Id:
+		row.Status = importUnchanged
+	default:
+		row.Status = importChange
+	}
+	return nil
+}
+
+// document finds a document by its name (or display name) and recorded
+// date, loading its scores the first time it is seen.
+func (chk *importChecker) document(name, recorded string) (*document, error) {
+	if len(name) == 0 {
+		return nil, ue("No document was given.")
+	}
+	rec, err := time.Parse(recordedFmt, recorded)
+	if err != nil {
+		return nil, ue("Could not parse recorded date **%s**. Please use "+
+			"the format YYYY-MM-DD.", recorded)
+	}
+	key := displayToName(name) + "/" + rec.Format(recordedFmt)
+	if d, ok := chk.docs[key]; ok {
+		if d == nil {
+			return nil, ue("Could not find any document named **%s** "+
+				"recorded on **%s**.", name, recorded)
+		}
+		return d, nil
+	}
+	d := chk.find(displayToName(name), rec)
+	chk.docs[key] = d
+	if d == nil {
+		return nil, ue("Could not find any document named **%s** "+
+			"recorded on **%s**.", name, recorded)
+	}
+	existing := make(map[string]*score)
+	for _, s := range chk.scoresOf(d) {
+		existing[fmt.Sprintf("%d/%s", s.Word, s.Category)] = s
+	}
+	chk.scores[key] = existing
+	return d, nil
+}
+
+// word finds the index of a word in a document. A word can be given by its
+// index, by its surface form or by both, in which case they must agree.
+// Surface forms are compared to the tokenizer's words without regard to
+// case or surrounding punctuation, and `occurrence` picks among repeated
+// words (the first is 1).
+func (chk *importChecker) word(
+	d *document,
+	index, surface, occurrence string,
+) (int, error) {
+	toks := d.Tokens()
+	if len(surface) > 0 {
+		if words := tokenize(surface); len(words) == 1 {
+			surface = words[0].Text
+		} else {
+			return 0, ue("**%s** is not a single word.", surface)
+		}
+	}
+	if len(index) > 0 {
+		i, err := strconv.Atoi(index)
+		if err != nil {
+			return 0, ue("**%s** is not a word number.", index)
+		}
+		if i < 0 || i >= len(toks) {
+			return 0, ue("Word %d does not exist in document **%s**.",
+				i, d.Display)
+		}
+		if len(surface) > 0 && !strings.EqualFold(toks[i].Text, surface) {
+			return 0, ue("Word %d of document **%s** is **%s**, not **%s**.",
+				i, d.Display, toks[i].Text, surface)
+		}
+		return i, nil
+	}
+	if len(surface) == 0 {
+		return 0, ue("No word was given.")
+	}
+
+	nth := 1
+	if len(occurrence) > 0 {
+		n, err := strconv.Atoi(occurrence)
+		if err != nil || n < 1 {
+			return 0, ue("**%s** is not an occurrence number.", occurrence)
+		}
+		nth = n
+	}
+	seen := 0
+	for _, tok := range toks {
+		if strings.EqualFold(tok.Text, surface) {
+			if seen++; seen == nth {
+				return tok.Index, nil
+			}
+		}
+	}
+	if seen == 0 {
+		return 0, ue("The word **%s** does not occur in document **%s**.",
+			surface, d.Display)
+	}
+	return 0, ue("The word **%s** occurs only %d times in document **%s**.",
+		surface, seen, d.Display)
+}
+
+// coder finds the member of the project with the given id or name. Scores
+// without a coder are attributed to the user importing them.
+func (chk *importChecker) coder(name string) (*lcmUser, error) {
+	if len(name) == 0 {
+		return chk.user, nil
+	}
+	for _, u := range chk.members {
+		if u.Id == name || strings.EqualFold(u.Name, name) {
+			return u, nil
+		}
+	}
+	return nil, ue("**%s** is not a member of project **%s**.",
+		name, chk.proj.Display)
+}
+
+// schemeCategoryKey returns the key of the category in a scoring scheme
+// that is named `name`. Categories may be given by key or by name.
+func schemeCategoryKey(scheme, name string) string {
+	cats := conf.Scores[scheme].Categories
+	if _, ok := cats[name]; ok {
+		return name
+	}
+	for key, cat := range cats {
+		if strings.EqualFold(cat.Name, name) {
+			return key
+		}
+	}
+	return name
+}
+
+// plainError returns the message of an error without its Markdown.
+func plainError(err error) string {
+	return strings.Replace(err.Error(), "**", "", -1)
+}
+
+// importResult counts the scores saved by an import.
+type importResult struct {
+	New     int
+	Changed int
+	Skipped int
+}
+
+// changes returns the rows of an import that are saved when it is applied,
+// and counts them. Changed scores are only saved if `replace` is set. The
+// import must not have any errors.
+func (imp *scoreImport) changes(replace bool) ([]*importRow, importResult) {
+	var res importResult
+	rows := make([]*importRow, 0, len(imp.Rows))
+	for _, row := range imp.Rows {
+		switch {
+		case row.Status == importUnchanged:
+			res.Skipped++
+			continue
+		case row.Status == importChange && !replace:
+			res.Skipped++
+			continue
+		case row.Status == importChange:
+			res.Changed++
+		default:
+			res.New++
+		}
+		rows = append(rows, row)
+	}
+	return rows, res
+}
+
+// applyScoreImport checks a CSV of scores and saves them in a single
+// transaction. Nothing is saved if any row has an error. Scores that differ
+// from existing scores only replace them if `replace` is set.
+//
+// The rows are checked again once every document in the import is locked,
+// so that scores saved since the preview can't be overwritten unseen.
+func (proj *project) applyScoreImport(
+	user *lcmUser,
+	data, scheme string,
+	replace bool,
+) (importResult, error) {
+	var res importResult
+	imp, err := proj.checkScoreImport(user, data, scheme)
+	if err != nil {
+		return res, err
+	}
+	docs := imp.Documents()
+	for _, d := range docs {
+		d.lock()
+		defer d.unlock()
+	}
+	if imp, err = proj.checkScoreImport(user, data, scheme); err != nil {
+		return res, err
+	}
+	if n := imp.Count(importError); n > 0 {
+		return res, ue("%d rows have errors. Please fix them and upload "+
+			"the CSV file again.", n)
+	}
+
+	completed := make(map[string]bool)
+	for _, d := range docs {
+		completed[d.Key()] = d.completed()
+	}
+	rows, res := imp.changes(replace)
+	now := time.Now().UTC()
+	csql.Tx(db, func(tx *sql.Tx) {
+		for _, row := range rows {
+			d := row.Document
+			csql.Exec(tx, `
+				DELETE FROM score
+				WHERE project_owner = $1 AND project_name = $2
+					AND document_name = $3 AND document_recorded = $4
+					AND word = $5 AND category = $6
+				`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+				row.Word, row.Scheme)
+			csql.Exec(tx, `
+				INSERT INTO score (
+					project_owner, project_name,
+					document_name, document_recorded,
+					word, category, name, created_by, created
+				) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+				`, d.Project.Owner.Id, d.Project.Name, d.Name, d.Recorded,
+				row.Word, row.Scheme, row.Category, row.Coder.Id, now)
+		}
+	})
+
+	for _, d := range imp.Documents() {
+		docEvents.publish(d, eventReload, nil)
+		if !completed[d.Key()] && d.completed() {
+			proj.triggerHooks(hookDocumentCompleted, user, d)
+		}
+	}
+	return res, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImportChecker returns a checker for a project with one document, in
+// which two words are already scored. The scoring schemes in the
+// configuration are replaced until `restore` is called.
+func testImportChecker() (chk *importChecker, finds *int, restore func()) {
+	old := conf.Scores
+	conf.Scores = map[string]configScoringScheme{
+		"lcm": {Categories: map[string]configScoreCategory{
+			"dav": {Name: "Descriptive action verb", Value: 1},
+			"adj": {Name: "Adjective", Value: 4},
+		}},
+	}
+	restore = func() { conf.Scores = old }
+
+	owner := &lcmUser{configUser{Id: "andrew", Name: "Andrew"}}
+	kim := &lcmUser{configUser{Id: "kim", Name: "Kim Lee"}}
+	proj := &project{Owner: owner, Name: "test", Display: "test"}
+	d := &document{
+		Project:    proj,
+		Display:    "Interview 1",
+		Name:       "Interview_1",
+		Recorded:   time.Date(2014, 3, 2, 0, 0, 0, 0, time.UTC),
+		Categories: []string{"lcm"},
+		Content:    "The cat sat. The cat ran!",
+	}
+	existing := []*score{
+		{Document: d, Word: 1, Category: "lcm", Name: "dav",
+			CreatedBy: owner},
+		{Document: d, Word: 2, Category: "lcm", Name: "adj",
+			CreatedBy: kim},
+	}
+
+	finds = new(int)
+	chk = &importChecker{
+		proj:    proj,
+		user:    owner,
+		scheme:  "lcm",
+		members: []*lcmUser{owner, kim},
+		find: func(name string, recorded time.Time) *document {
+			*finds++
+			if name == d.Name && recorded.Equal(d.Recorded) {
+				return d
+			}
+			return nil
+		},
+		scoresOf: func(*document) []*score { return existing },
+	}
+	return chk, finds, restore
+}
+
+func TestCheckScoreImport(t *testing.T) {
+	chk, finds, restore := testImportChecker()
+	defer restore()
+
+	// The header has a byte order mark and is matched without regard to
+	// case or spaces, as spreadsheets tend to write it.
+	data := "\ufeffDocument, Recorded ,Word,SURFACE,Occurrence,Scheme," +
+		"Category,Coder\n" + strings.Join([]string{
+		"Interview 1,2014-03-02,0,,,lcm,dav,",
+		"Interview 1,2014-03-02,1,,,lcm,dav,andrew",
+		"Interview 1,2014-03-02,2,,,lcm,Adjective,andrew",
+		"Interview 1,2014-03-02,,cat,2,,adj,Kim Lee",
+		"Interview 1,2014-03-02,,Ran!,,lcm,dav,",
+		"Interview 1,2014-03-02,0,,,lcm,adj,",
+		"Interview 1,2014-03-02,3,cat,,lcm,dav,",
+		"Interview 1,2014-03-02,,dog,,lcm,dav,",
+		"Interview 1,2014-03-02,,cat,3,lcm,dav,",
+		"Interview 2,2014-03-02,0,,,lcm,dav,",
+		"Interview 1,03/02/2014,0,,,lcm,dav,",
+		"Interview 1,2014-03-02,3,,,lcm,nope,",
+		"Interview 1,2014-03-02,9,,,lcm,dav,",
+		"Interview 1,2014-03-02,3,,,other,dav,",
+		",,,,,,,",
+		"Interview 1,2014-03-02,3,,,lcm,dav,nobody",
+		"Interview 1,2014-03-02,,two words,,lcm,dav,",
+	}, "\n")
+	imp, err := chk.read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line     int
+		status   string
+		word     int
+		category string
+		coder    string
+		err      string
+	}{
+		{2, importNew, 0, "dav", "andrew", ""},
+		{3, importUnchanged, 1, "dav", "andrew", ""},
+		{4, importChange, 2, "adj", "andrew", ""},
+		{5, importNew, 4, "adj", "kim", ""},
+		{6, importNew, 5, "dav", "andrew", ""},
+		{7, importError, 0, "", "",
+			"Word 0 is already scored with the lcm scheme on line 2."},
+		{8, importError, 0, "", "",
+			"Word 3 of document Interview 1 is The, not cat."},
+		{9, importError, 0, "", "",
+			"The word dog does not occur in document Interview 1."},
+		{10, importError, 0, "", "",
+			"The word cat occurs only 2 times in document Interview 1."},
+		{11, importError, 0, "", "",
+			"Could not find any document named Interview 2 recorded " +
+				"on 2014-03-02."},
+		{12, importError, 0, "", "",
+			"Could not parse recorded date 03/02/2014. Please use the " +
+				"format YYYY-MM-DD."},
+		{13, importError, 0, "", "",
+			"nope is not a category in the lcm scoring scheme."},
+		{14, importError, 0, "", "",
+			"Word 9 does not exist in document Interview 1."},
+		{15, importError, 0, "", "",
+			"Scoring scheme other does not exist."},
+		{17, importError, 0, "", "",
+			"nobody is not a member of project test."},
+		{18, importError, 0, "", "", "two words is not a single word."},
+	}
+	if len(imp.Rows) != len(tests) {
+		t.Fatalf("got %d rows, want %d", len(imp.Rows), len(tests))
+	}
+	for i, test := range tests {
+		row := imp.Rows[i]
+		if row.Line != test.line || row.Status != test.status ||
+			row.Error != test.err {
+			t.Errorf("line %d: got line %d, %s (%s), want %s (%s)",
+				test.line, row.Line, row.Status, row.Error,
+				test.status, test.err)
+			continue
+		}
+		if row.Status == importError {
+			continue
+		}
+		if row.Word != test.word || row.Category != test.category ||
+			row.Coder.Id != test.coder {
+			t.Errorf("line %d: got word %d, %s by %s, want %d, %s by %s",
+				test.line, row.Word, row.Category, row.Coder.Id,
+				test.word, test.category, test.coder)
+		}
+	}
+	if n := imp.Count(importError); n != 11 {
+		t.Errorf("Count(error) = %d, want 11", n)
+	}
+
+	// Each document is looked up once, and so is a missing one.
+	if *finds != 2 {
+		t.Errorf("documents were looked up %d times, want 2", *finds)
+	}
+}
+
+func TestCheckScoreImportHeader(t *testing.T) {
+	chk, _, restore := testImportChecker()
+	defer restore()
+
+	tests := []struct {
+		data, err string
+	}{
+		{"", "The CSV file is empty."},
+		{"document,recorded,word\n", "The CSV file has no **category** " +
+			"column."},
+		{"document,recorded,category\n", "The CSV file needs a **word** " +
+			"or **surface** column."},
+		{"document,recorded,word,category\n\n", "The CSV file has no " +
+			"scores."},
+		{"document,\"recorded\n", "Could not read the CSV file: "},
+	}
+	// Errors from the CSV reader are only checked up to their own message,
+	// which depends on the version of Go.
+	for _, test := range tests {
+		_, err := chk.read(test.data)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, want %q", test.data, err, test.err)
+		}
+	}
+}
+
+func TestScoreImportChanges(t *testing.T) {
+	imp := &scoreImport{Rows: []*importRow{
+		{Line: 2, Status: importNew},
+		{Line: 3, Status: importChange},
+		{Line: 4, Status: importUnchanged},
+		{Line: 5, Status: importNew},
+	}}
+	for _, test := range []struct {
+		replace bool
+		lines   []int
+		res     importResult
+	}{
+		{false, []int{2, 5}, importResult{New: 2, Skipped: 2}},
+		{true, []int{2, 3, 5},
+			importResult{New: 2, Changed: 1, Skipped: 1}},
+	} {
+		rows, res := imp.changes(test.replace)
+		lines := make([]int, len(rows))
+		for i, row := range rows {
+			lines[i] = row.Line
+		}
+		if res != test.res || !reflect.DeepEqual(lines, test.lines) {
+			t.Errorf("replace %v: got lines %v, %+v, want %v, %+v",
+				test.replace, lines, res, test.lines, test.res)
+		}
+	}
+}
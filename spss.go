@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// This file implements a writer for uncompressed SPSS system files (.sav).
+// The format is documented by the PSPP project:
+// https://www.gnu.org/software/pspp/pspp-dev/html_node/System-File-Format.html
+//
+// Only what our exports need is supported: numeric variables, string
+// variables of up to 255 bytes, variable labels, value labels on numeric
+// variables and long variable names. Text is always written as UTF-8.
+
+// spssSysmis is the system-missing value.
+const spssSysmis = -math.MaxFloat64
+
+// Print and write formats of variables.
+const (
+	spssFmtA        = 1
+	spssFmtF        = 5
+	spssFmtDate     = 20
+	spssFmtDateTime = 22
+)
+
+// spssEpoch is the time that SPSS dates and times are counted from, in
+// seconds since the Unix epoch.
+var spssEpoch = time.Date(1582, 10, 14, 0, 0, 0, 0, time.UTC).Unix()
+
+// spssTime converts a time into the number of seconds that SPSS uses to
+// represent it.
+func spssTime(t time.Time) float64 {
+	return float64(t.Unix() - spssEpoch)
+}
+
+// spssVar is a variable (column) in an SPSS system file.
+type spssVar struct {
+	// Name may be up to 64 bytes long. It must start with a letter and
+	// contain only letters, numbers and underscores.
+	Name  string
+	Label string
+
+	// Width is 0 for numeric variables and the length in bytes (at most
+	// 255) for string variables. Longer strings are truncated.
+	Width int
+
+	// Format is one of the spssFmt* constants. Width and Decimals apply to
+	// how a value is displayed.
+	Format   int
+	FmtWidth int
+	Decimals int
+
+	// ValueLabels maps values of a numeric variable to labels.
+	ValueLabels []spssValueLabel
+}
+
+type spssValueLabel struct {
+	Value float64
+	Label string
+}
+
+func spssNumeric(name, label string, width, decimals int) spssVar {
+	return spssVar{
+		Name: name, Label: label,
+		Format: spssFmtF, FmtWidth: width, Decimals: decimals,
+	}
+}
+
+func spssString(name, label string, width int) spssVar {
+	return spssVar{
+		Name: name, Label: label, Width: width,
+		Format: spssFmtA, FmtWidth: width,
+	}
+}
+
+func spssDate(name, label string) spssVar {
+	return spssVar{
+		Name: name, Label: label, Format: spssFmtDate, FmtWidth: 11,
+	}
+}
+
+func spssDateTime(name, label string) spssVar {
+	return spssVar{
+		Name: name, Label: label, Format: spssFmtDateTime, FmtWidth: 20,
+	}
+}
+
+// segments is the number of 8 byte slots that a value of the variable
+// occupies in each case.
+func (v spssVar) segments() int {
+	if v.Width == 0 {
+		return 1
+	}
+	return (v.Width + 7) / 8
+}
+
+func (v spssVar) format() int32 {
+	return int32(v.Format<<16 | v.FmtWidth<<8 | v.Decimals)
+}
+
+// spssWriter writes cases to an SPSS system file. The dictionary is written
+// when it is created, and cases can be written as they become available.
+type spssWriter struct {
+	buf  *bufio.Writer
+	vars []spssVar
+	err  error
+}
+
+// newSPSSWriter writes the dictionary of a system file with the given
+// variables. The number of cases doesn't need to be known in advance.
+// Variables with the same name are renamed with spssUniqueNames.
+func newSPSSWriter(
+	w io.Writer,
+	label string,
+	vars []spssVar,
+) (*spssWriter, error) {
+	sw := &spssWriter{buf: bufio.NewWriter(w), vars: vars}
+	spssUniqueNames(sw.vars)
+	for i := range sw.vars {
+		if sw.vars[i].Width > 255 {
+			sw.vars[i].Width = 255
+			sw.vars[i].FmtWidth = 255
+		}
+	}
+	sw.writeHeader(label)
+	sw.writeVariables()
+	sw.writeValueLabels()
+	sw.writeExtensions()
+	sw.int32s(999, 0)
+	return sw, sw.err
+}
+
+// writeCase writes a single case. There must be one value per variable:
+// a float64 (or int) for numeric variables, a string for string variables,
+// or nil for a missing value.
+func (sw *spssWriter) writeCase(values ...interface{}) error {
+	if len(values) != len(sw.vars) {
+		return fmt.Errorf("Expected %d values in case but got %d.",
+			len(sw.vars), len(values))
+	}
+	for i, v := range sw.vars {
+		switch val := values[i].(type) {
+		case nil:
+			if v.Width == 0 {
+				sw.float64s(spssSysmis)
+			} else {
+				sw.padded("", v.segments()*8)
+			}
+		case float64:
+			sw.float64s(val)
+		case int:
+			sw.float64s(float64(val))
+		case string:
+			sw.padded(truncateUTF8(val, v.Width), v.segments()*8)
+		default:
+			return fmt.Errorf("Unsupported SPSS value of type %T.", val)
+		}
+	}
+	return sw.err
+}
+
+// flush writes any buffered data to the underlying writer.
+func (sw *spssWriter) flush() error {
+	if sw.err != nil {
+		return sw.err
+	}
+	return sw.buf.Flush()
+}
+
+func (sw *spssWriter) writeHeader(label string) {
+	caseSize := 0
+	for _, v := range sw.vars {
+		caseSize += v.segments()
+	}
+	now := time.Now().UTC()
+
+	sw.padded("$FL2", 4)
+	sw.padded("@(#) SPSS DATA FILE lcmweb", 60)
+	sw.int32s(2, int32(caseSize), 0, 0, -1)
+	sw.float64s(100)
+	sw.padded(now.Format("02 Jan 06"), 9)
+	sw.padded(now.Format("15:04:05"), 8)
+	sw.padded(truncateUTF8(label, 64), 64)
+	sw.padded("", 3)
+}
+
+func (sw *spssWriter) writeVariables() {
+	for i, v := range sw.vars {
+		hasLabel := int32(0)
+		if len(v.Label) > 0 {
+			hasLabel = 1
+		}
+		sw.int32s(2, int32(v.Width), hasLabel, 0, v.format(), v.format())
+		sw.padded(spssShortName(i), 8)
+		if hasLabel == 1 {
+			label := truncateUTF8(v.Label, 255)
+			sw.int32s(int32(len(label)))
+			sw.padded(label, (len(label)+3)/4*4)
+		}
+
+		// Strings longer than 8 bytes are continued in unnamed variables.
+		for j := 1; j < v.segments(); j++ {
+			sw.int32s(2, -1, 0, 0, 0, 0)
+			sw.padded("", 8)
+		}
+	}
+}
+
+func (sw *spssWriter) writeValueLabels() {
+	index := 1
+	for _, v := range sw.vars {
+		if len(v.ValueLabels) > 0 && v.Width == 0 {
+			sw.int32s(3, int32(len(v.ValueLabels)))
+			for _, vl := range v.ValueLabels {
+				label := truncateUTF8(vl.Label, 120)
+				sw.float64s(vl.Value)
+				sw.bytes([]byte{byte(len(label))})
+				sw.padded(label, (len(label)+1+7)/8*8-1)
+			}
+			sw.int32s(4, 1, int32(index))
+		}
+		index += v.segments()
+	}
+}
+
+func (sw *spssWriter) writeExtensions() {
+	// Machine integer info: version 1.0.0, unknown machine, IEEE 754 floats,
+	// no compression, little endian and UTF-8.
+	sw.int32s(7, 3, 4, 8)
+	sw.int32s(1, 0, 0, -1, 1, 1, 2, 65001)
+
+	// Machine floating point info: sysmis, highest and lowest values.
+	sw.int32s(7, 4, 8, 3)
+	sw.float64s(spssSysmis, math.MaxFloat64,
+		math.Nextafter(-math.MaxFloat64, 0))
+
+	// Long variable names.
+	names := make([]string, len(sw.vars))
+	for i, v := range sw.vars {
+		names[i] = fmt.Sprintf("%s=%s", spssShortName(i), v.Name)
+	}
+	longNames := strings.Join(names, "\t")
+	sw.int32s(7, 13, 1, int32(len(longNames)))
+	sw.padded(longNames, len(longNames))
+
+	// Character encoding.
+	sw.int32s(7, 20, 1, int32(len("UTF-8")))
+	sw.padded("UTF-8", len("UTF-8"))
+}
+
+// spssShortName returns the 8 byte name of the i'th variable. Every
+// variable is also given its real name with a long variable names record.
+func spssShortName(i int) string {
+	return fmt.Sprintf("V%d", i+1)
+}
+
+func (sw *spssWriter) int32s(ns ...int32) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.buf, binary.LittleEndian, ns)
+	}
+}
+
+func (sw *spssWriter) float64s(fs ...float64) {
+	if sw.err == nil {
+		sw.err = binary.Write(sw.buf, binary.LittleEndian, fs)
+	}
+}
+
+func (sw *spssWriter) bytes(bs []byte) {
+	if sw.err == nil {
+		_, sw.err = sw.buf.Write(bs)
+	}
+}
+
+// padded writes `s` padded with spaces to `n` bytes. `s` must not be longer
+// than `n` bytes.
+func (sw *spssWriter) padded(s string, n int) {
+	sw.bytes([]byte(s + strings.Repeat(" ", n-len(s))))
+}
+
+// truncateUTF8 shortens `s` to at most `n` bytes without splitting a
+// character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[0:n]
+}
+
+// spssName turns an arbitrary string into a valid SPSS variable name by
+// replacing anything that isn't a letter, number or underscore. Names that
+// don't start with a letter are prefixed with `v`. Two strings may give the
+// same name, which newSPSSWriter resolves.
+func spssName(s string) string {
+	name := []rune(s)
+	for i, r := range name {
+		if !isSPSSLetter(r) && r != '_' && (r < '0' || r > '9') {
+			name[i] = '_'
+		}
+	}
+	if len(name) == 0 || !isSPSSLetter(name[0]) {
+		name = append([]rune{'v'}, name...)
+	}
+	return truncateUTF8(string(name), 64)
+}
+
+func isSPSSLetter(r rune) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+}
+
+// spssUniqueNames adds a numeric suffix to any variable name that is used
+// by an earlier variable. Names are compared without case, as in SPSS.
+func spssUniqueNames(vars []spssVar) {
+	seen := make(map[string]bool)
+	for i := range vars {
+		name := vars[i].Name
+		for n := 2; seen[strings.ToUpper(name)]; n++ {
+			suffix := fmt.Sprintf("_%d", n)
+			name = truncateUTF8(vars[i].Name, 64-len(suffix)) + suffix
+		}
+		seen[strings.ToUpper(name)] = true
+		vars[i].Name = name
+	}
+}
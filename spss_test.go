@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSPSSName(t *testing.T) {
+	tests := map[string]string{
+		"scored":     "scored",
+		"meta_a b":   "meta_a_b",
+		"meta_a-b":   "meta_a_b",
+		"_private":   "v_private",
+		"2nd":        "v2nd",
+		"":           "v",
+		"n_écrit":    "n__crit",
+		"Dimension1": "Dimension1",
+	}
+	for s, want := range tests {
+		if got := spssName(s); got != want {
+			t.Errorf("spssName(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+func TestSPSSUniqueNames(t *testing.T) {
+	vars := []spssVar{
+		{Name: "meta_a_b"}, {Name: "META_A_B"}, {Name: "meta_a_b"},
+		{Name: "meta_a_b_2"}, {Name: "other"},
+	}
+	spssUniqueNames(vars)
+	want := []string{
+		"meta_a_b", "META_A_B_2", "meta_a_b_3", "meta_a_b_2_2", "other",
+	}
+	for i, v := range vars {
+		if v.Name != want[i] {
+			t.Errorf("variable %d is named %q, want %q", i, v.Name, want[i])
+		}
+	}
+}
+
+func TestSPSSWriter(t *testing.T) {
+	category := spssNumeric("category", "Category", 8, 0)
+	category.ValueLabels = []spssValueLabel{
+		{1, "Descriptive action verb"}, {2, "Interpretive action verb"},
+	}
+	vars := []spssVar{
+		spssString("document", "Document name", 100),
+		spssDate("recorded", "Date the document was recorded"),
+		spssNumeric("word", "", 8, 0),
+		category,
+		spssDateTime("timestamp", "Time the word was scored (UTC)"),
+		spssNumeric(spssName("meta_a b"), "Metadata: a b", 8, 0),
+		spssNumeric(spssName("meta_a-b"), "Metadata: a-b", 8, 0),
+	}
+	recorded := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	scored := time.Date(2014, 3, 2, 15, 4, 5, 0, time.UTC)
+
+	buf := new(bytes.Buffer)
+	sav, err := newSPSSWriter(buf, "Test: scores", vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := [][]interface{}{
+		{"Interview 1", spssTime(recorded), 0, 1, spssTime(scored), 3, nil},
+		{"Ünïcode", nil, 17, 2.0, nil, nil, 1.5},
+	}
+	for _, c := range cases {
+		if err := sav.writeCase(c...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sav.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sav.writeCase("too few"); err == nil {
+		t.Error("a case with too few values was accepted")
+	}
+
+	// The header includes the time the file was written.
+	got := buf.Bytes()
+	copy(got[92:109], "01 Jan 0000:00:00")
+	if !bytes.Contains(got, []byte("V6=meta_a_b\tV7=meta_a_b_2")) {
+		t.Error("the long variable names are not unique")
+	}
+	checkGolden(t, "scores.sav", got)
+}
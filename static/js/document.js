@@ -0,0 +1,151 @@
+// scores maps a word index to a map from scoring scheme to score.
+var scores = {};
+
+function active_scheme() {
+    return $('#Scheme').val();
+}
+
+function render_word(word) {
+    var $word = $('#document-content .word[data-word=' + word + ']');
+    var s = scores[word] && scores[word][active_scheme()];
+    if (s) {
+        $word.addClass('scored');
+        $word.attr('title', '{0} (by {1})'.format(s.Name, s.CreatedBy));
+        $word.find('.score-label').text(s.Name);
+    } else {
+        $word.removeClass('scored');
+        $word.removeAttr('title');
+        $word.find('.score-label').text('');
+    }
+}
+
+function render_all_words() {
+    $('#document-content .word').each(function() {
+        render_word($(this).data('word'));
+    });
+    $('.score-scheme').hide();
+    $('.score-scheme[data-scheme="' + active_scheme() + '"]').show();
+}
+
+// highlight_codebook points out the codebook entry of the category that the
+// given word was scored with in the active scheme.
+function highlight_codebook(word) {
+    var $panel = $('#codebook-panel .score-scheme:visible');
+    var s = scores[word] && scores[word][active_scheme()];
+    $panel.find('.codebook-category').removeClass('current');
+    if (s) {
+        var $cat = $panel.find('.codebook-category[data-name="' + s.Name + '"]');
+        $cat.addClass('current');
+        var $scroll = $('#codebook-panel');
+        $scroll.scrollTop($scroll.scrollTop() + $cat.position().top);
+    }
+}
+
+// apply_score records a score change, whether it came from the initial page
+// load, from this page or from another user's page.
+function apply_score(s) {
+    if (!scores[s.Word]) {
+        scores[s.Word] = {};
+    }
+    if (s.Deleted) {
+        delete scores[s.Word][s.Category];
+    } else {
+        scores[s.Word][s.Category] = s;
+    }
+    render_word(s.Word);
+}
+
+// listen_document_events subscribes to changes made to this document by
+// anyone. The browser reconnects on its own after a dropped connection and
+// tells the server the last event it saw. But if the server refuses the
+// connection (e.g., the session expired), then we retry ourselves.
+function listen_document_events($content) {
+    var url = $content.data('events');
+    var last_id = $content.data('last-event-id');
+
+    function connect() {
+        var source = new EventSource(url + '?lastEventId=' + last_id);
+        source.addEventListener('score', function(ev) {
+            last_id = ev.lastEventId;
+            apply_score(JSON.parse(ev.data));
+        });
+        source.addEventListener('note', function(ev) {
+            last_id = ev.lastEventId;
+        });
+        source.addEventListener('lease', function(ev) {
+            last_id = ev.lastEventId;
+        });
+        source.addEventListener('reload', function(ev) {
+            source.close();
+            window.location.reload();
+        });
+        source.onerror = function() {
+            if (source.readyState == EventSource.CLOSED) {
+                window.setTimeout(connect, 5 * 1000);
+            }
+        };
+    }
+    connect();
+}
+
+$(document).ready(function() {
+    var $form = $('#document-score');
+    var $content = $('#document-content');
+    var $selected = null;
+
+    for (var i = 0; i < Scores.length; i++) {
+        apply_score(Scores[i]);
+    }
+    render_all_words();
+    $('#Scheme').change(render_all_words);
+
+    function submit_score(name) {
+        if (!$selected) {
+            return;
+        }
+        $form.find('input[name=Word]').val($selected.data('word'));
+        $form.find('input[name=Category]').val(active_scheme());
+        $form.find('input[name=Name]').val(name);
+        $form.submit();
+    }
+
+    $content.find('.word').click(function() {
+        if ($selected) {
+            $selected.removeClass('selected');
+        }
+        $selected = $(this);
+        $selected.addClass('selected');
+        highlight_codebook($selected.data('word'));
+    });
+    $('#score-categories a').click(function(ev) {
+        ev.preventDefault();
+        submit_score($(this).data('name'));
+    });
+    $(document).keypress(function(ev) {
+        var key = String.fromCharCode(ev.which);
+        var $scheme = $('#score-categories .score-scheme:visible');
+        var $cat = $scheme.find('a[data-shortcut="' + key + '"]');
+        if ($cat.length > 0) {
+            submit_score($cat.data('name'));
+        }
+    });
+
+    jajaxForm($form, function(r, status, xhr, $form) {
+        if (!is_success(r)) {
+            flash_response_error(r);
+            return;
+        }
+        flash_hide_error();
+        if (r.content) {
+            apply_score(r.content);
+        }
+    });
+
+    // Links to a particular word (e.g., from the concordance) select it.
+    var m = window.location.hash.match(/^#word-(\d+)$/);
+    if (m) {
+        $content.find('.word[data-word=' + m[1] + ']').click();
+    }
+
+    listen_document_events($content);
+});
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"math"
+	"sort"
+)
+
+// This file implements the statistics used to compare groups of documents.
+// Undefined results (for example, the standard deviation of a single value)
+// are NaN.
+
+// formatStat formats a statistic for export. Undefined statistics are empty.
+func formatStat(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return ""
+	}
+	return formatFloat(f)
+}
+
+func mean(xs []float64) float64 {
+	if len(xs) == 0 {
+		return math.NaN()
+	}
+	sum := 0.0
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+// variance is the sample variance of `xs`.
+func variance(xs []float64) float64 {
+	if len(xs) < 2 {
+		return math.NaN()
+	}
+	m := mean(xs)
+	sum := 0.0
+	for _, x := range xs {
+		sum += (x - m) * (x - m)
+	}
+	return sum / float64(len(xs)-1)
+}
+
+func stddev(xs []float64) float64 {
+	return math.Sqrt(variance(xs))
+}
+
+// welchResult is the result of Welch's unequal variances t-test together
+// with Cohen's d as an effect size.
+type welchResult struct {
+	T, DF, P float64
+
+	// D is Cohen's d using the pooled standard deviation. It is positive
+	// when the first group has the larger mean.
+	D float64
+}
+
+// welchTest compares the means of two samples without assuming that their
+// variances are equal. The p-value is two-sided.
+func welchTest(a, b []float64) welchResult {
+	na, nb := float64(len(a)), float64(len(b))
+	ma, mb := mean(a), mean(b)
+	va, vb := variance(a), variance(b)
+	nan := math.NaN()
+	if len(a) < 2 || len(b) < 2 {
+		return welchResult{nan, nan, nan, nan}
+	}
+
+	pooled := math.Sqrt(((na-1)*va + (nb-1)*vb) / (na + nb - 2))
+	d := nan
+	if pooled > 0 {
+		d = (ma - mb) / pooled
+	}
+	se2 := va/na + vb/nb
+	if se2 == 0 {
+		return welchResult{nan, nan, nan, d}
+	}
+	t := (ma - mb) / math.Sqrt(se2)
+	df := se2 * se2 /
+		((va/na)*(va/na)/(na-1) + (vb/nb)*(vb/nb)/(nb-1))
+	p := incompleteBeta(df/2, 0.5, df/(df+t*t))
+	return welchResult{t, df, p, d}
+}
+
+// mannWhitneyResult is the result of a Mann-Whitney U test together with
+// the rank-biserial correlation as an effect size.
+type mannWhitneyResult struct {
+	// U is the statistic for the first group: the number of pairs in which
+	// its value is larger (with ties counting half).
+	U float64
+
+	// Z and P come from the normal approximation, corrected for ties and
+	// for continuity. P is two-sided.
+	Z, P float64
+
+	// R is the rank-biserial correlation. It is positive when values in the
+	// first group tend to be larger.
+	R float64
+}
+
+// mannWhitneyTest compares the distributions of two samples by their ranks.
+func mannWhitneyTest(a, b []float64) mannWhitneyResult {
+	nan := math.NaN()
+	if len(a) == 0 || len(b) == 0 {
+		return mannWhitneyResult{nan, nan, nan, nan}
+	}
+	na, nb := float64(len(a)), float64(len(b))
+	n := na + nb
+
+	type obs struct {
+		x     float64
+		first bool
+	}
+	all := make([]obs, 0, len(a)+len(b))
+	for _, x := range a {
+		all = append(all, obs{x, true})
+	}
+	for _, x := range b {
+		all = append(all, obs{x, false})
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].x < all[j].x })
+
+	// Tied values get the mean of their ranks.
+	rankSum, ties := 0.0, 0.0
+	for i := 0; i < len(all); {
+		j := i
+		for j < len(all) && all[j].x == all[i].x {
+			j++
+		}
+		rank := float64(i+j+1) / 2
+		for k := i; k < j; k++ {
+			if all[k].first {
+				rankSum += rank
+			}
+		}
+		t := float64(j - i)
+		ties += t*t*t - t
+		i = j
+	}
+
+	u := rankSum - na*(na+1)/2
+	r := 2*u/(na*nb) - 1
+	mu := na * nb / 2
+	sigma := math.Sqrt(na * nb / 12 * ((n + 1) - ties/(n*(n-1))))
+	if sigma == 0 || math.IsNaN(sigma) {
+		return mannWhitneyResult{u, nan, nan, r}
+	}
+	diff := u - mu
+	switch {
+	case diff > 0.5:
+		diff -= 0.5
+	case diff < -0.5:
+		diff += 0.5
+	default:
+		diff = 0
+	}
+	z := diff / sigma
+	p := math.Erfc(math.Abs(z) / math.Sqrt2)
+	return mannWhitneyResult{u, z, p, r}
+}
+
+// incompleteBeta is the regularized incomplete beta function I_x(a, b). It
+// is evaluated with a continued fraction as described in Numerical Recipes.
+func incompleteBeta(a, b, x float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+	la, _ := math.Lgamma(a)
+	lb, _ := math.Lgamma(b)
+	lab, _ := math.Lgamma(a + b)
+	front := math.Exp(lab - la - lb + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges quickly only on this side.
+	if x < (a+1)/(a+b+2) {
+		return front * betaFraction(a, b, x) / a
+	}
+	return 1 - front*betaFraction(b, a, 1-x)/b
+}
+
+func betaFraction(a, b, x float64) float64 {
+	const (
+		maxIterations = 300
+		epsilon       = 3e-14
+		tiny          = 1e-300
+	)
+	clamp := func(v float64) float64 {
+		if math.Abs(v) < tiny {
+			return tiny
+		}
+		return v
+	}
+	c, d := 1.0, 1/clamp(1-(a+b)*x/(a+1))
+	h := d
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+		num := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 / clamp(1+num*d)
+		c = clamp(1 + num/c)
+		h *= d * c
+
+		num = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 / clamp(1+num*d)
+		c = clamp(1 + num/c)
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+	return h
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The `sleep` data set from R, split by group.
+var (
+	sleep1 = []float64{0.7, -1.6, -0.2, -1.2, -0.1, 3.4, 3.7, 0.8, 0.0, 2.0}
+	sleep2 = []float64{1.9, 0.8, 1.1, 0.1, -0.1, 4.4, 5.5, 1.6, 4.6, 3.4}
+)
+
+// checkStat compares a statistic with a known value to `digits`
+// significant digits. A NaN is only equal to a NaN.
+func checkStat(t *testing.T, name string, got, want float64, digits int) {
+	if math.IsNaN(want) {
+		if !math.IsNaN(got) {
+			t.Errorf("%s = %v, want NaN", name, got)
+		}
+		return
+	}
+	tol := math.Abs(want) * math.Pow(10, float64(1-digits)) / 2
+	if want == 0 {
+		tol = 1e-12
+	}
+	if math.IsNaN(got) || math.Abs(got-want) > tol {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+// Welch's test on the sleep data, as given by R's
+// t.test(extra ~ group, data = sleep) and effsize::cohen.d.
+func TestWelchSleep(t *testing.T) {
+	r := welchTest(sleep1, sleep2)
+	checkStat(t, "t", r.T, -1.8608, 5)
+	checkStat(t, "df", r.DF, 17.776, 5)
+	checkStat(t, "p", r.P, 0.07939, 4)
+	checkStat(t, "d", r.D, -0.8321811, 7)
+}
+
+func TestWelchUndefined(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name        string
+		a, b        []float64
+		t, df, p, d float64
+	}{
+		{"one value", []float64{1}, []float64{1, 2, 3}, nan, nan, nan, nan},
+		{"empty", nil, []float64{1, 2}, nan, nan, nan, nan},
+		{"no variance", []float64{2, 2}, []float64{1, 1, 1},
+			nan, nan, nan, nan},
+	}
+	for _, test := range tests {
+		r := welchTest(test.a, test.b)
+		checkStat(t, test.name+": t", r.T, test.t, 1)
+		checkStat(t, test.name+": df", r.DF, test.df, 1)
+		checkStat(t, test.name+": p", r.P, test.p, 1)
+		checkStat(t, test.name+": d", r.D, test.d, 1)
+	}
+
+	// With variance in only one group, the test is still defined.
+	r := welchTest([]float64{2, 2, 2}, []float64{1, 2, 3})
+	checkStat(t, "one constant group: t", r.T, 0, 1)
+	checkStat(t, "one constant group: df", r.DF, 2, 10)
+	checkStat(t, "one constant group: p", r.P, 1, 10)
+	checkStat(t, "one constant group: d", r.D, 0, 1)
+}
+
+// The Mann-Whitney test on the sleep data, which has ties, as given by R's
+// wilcox.test(extra ~ group, data = sleep, exact = FALSE).
+func TestMannWhitneySleep(t *testing.T) {
+	r := mannWhitneyTest(sleep1, sleep2)
+	checkStat(t, "U", r.U, 25.5, 10)
+	checkStat(t, "p", r.P, 0.06933, 4)
+	checkStat(t, "z", r.Z, -1.816279, 7)
+	checkStat(t, "r", r.R, 2*25.5/100-1, 10)
+}
+
+// The example from the documentation of scipy.stats.mannwhitneyu, with
+// method="asymptotic".
+func TestMannWhitneyScipy(t *testing.T) {
+	males := []float64{19, 22, 16, 29, 24}
+	females := []float64{20, 11, 17, 12}
+	r := mannWhitneyTest(males, females)
+	checkStat(t, "U", r.U, 17, 10)
+	checkStat(t, "p", r.P, 0.11134688653314041, 10)
+	checkStat(t, "r", r.R, 0.7, 10)
+
+	// Swapping the groups mirrors U, z and r.
+	s := mannWhitneyTest(females, males)
+	checkStat(t, "swapped U", s.U, 3, 10)
+	checkStat(t, "swapped z", s.Z, -r.Z, 10)
+	checkStat(t, "swapped p", s.P, r.P, 10)
+	checkStat(t, "swapped r", s.R, -0.7, 10)
+}
+
+func TestMannWhitneyUndefined(t *testing.T) {
+	nan := math.NaN()
+	r := mannWhitneyTest(nil, []float64{1, 2})
+	checkStat(t, "empty: U", r.U, nan, 1)
+	checkStat(t, "empty: p", r.P, nan, 1)
+
+	// When every value is tied, there is no variance in the ranks.
+	r = mannWhitneyTest([]float64{3, 3}, []float64{3, 3, 3})
+	checkStat(t, "all tied: U", r.U, 3, 10)
+	checkStat(t, "all tied: z", r.Z, nan, 1)
+	checkStat(t, "all tied: p", r.P, nan, 1)
+	checkStat(t, "all tied: r", r.R, 0, 1)
+
+	// A single value in each group is enough for the approximation. The
+	// continuity correction takes up the whole difference from the mean.
+	r = mannWhitneyTest([]float64{1}, []float64{2})
+	checkStat(t, "one each: U", r.U, 0, 1)
+	checkStat(t, "one each: z", r.Z, 0, 1)
+	checkStat(t, "one each: p", r.P, 1, 10)
+	checkStat(t, "one each: r", r.R, -1, 10)
+}
+
+// For whole numbers a and b, I_x(a, b) is the probability of at least a
+// successes in a+b-1 trials with probability x.
+func binomialTail(a, b int, x float64) float64 {
+	n := a + b - 1
+	sum := 0.0
+	for j := a; j <= n; j++ {
+		c := 1.0
+		for k := 0; k < j; k++ {
+			c = c * float64(n-k) / float64(k+1)
+		}
+		sum += c * math.Pow(x, float64(j)) * math.Pow(1-x, float64(n-j))
+	}
+	return sum
+}
+
+func TestIncompleteBeta(t *testing.T) {
+	for _, test := range []struct {
+		a, b int
+		x    float64
+	}{
+		{1, 1, 0.3}, {3, 5, 0.4}, {5, 3, 0.4}, {10, 2, 0.9},
+		{2, 20, 0.05}, {30, 30, 0.5}, {40, 7, 0.8},
+	} {
+		checkStat(t, "I", incompleteBeta(float64(test.a), float64(test.b),
+			test.x), binomialTail(test.a, test.b, test.x), 12)
+	}
+	checkStat(t, "I_0", incompleteBeta(2, 3, 0), 0, 1)
+	checkStat(t, "I_1", incompleteBeta(2, 3, 1), 1, 10)
+
+	// The two-sided p-value of Student's t with 1 and 2 degrees of freedom
+	// has a closed form. It is I_x(df/2, 1/2) with x = df/(df+t^2).
+	for _, tv := range []float64{0.1, 1, 2.5, 10} {
+		p1 := 1 - 2/math.Pi*math.Atan(tv)
+		checkStat(t, "p (df 1)", incompleteBeta(0.5, 0.5, 1/(1+tv*tv)),
+			p1, 12)
+		p2 := 1 - tv/math.Sqrt(tv*tv+2)
+		checkStat(t, "p (df 2)", incompleteBeta(1, 0.5, 2/(2+tv*tv)),
+			p2, 12)
+	}
+}
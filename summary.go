@@ -0,0 +1,197 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// layerConsensus is the name of the layer made up of every score, no matter
+// who created it. Since a word has at most one score in each scheme, this is
+// the agreed upon scoring of a document.
+const layerConsensus = "consensus"
+
+// summaryOptions selects the scores that a summary is computed from.
+type summaryOptions struct {
+	// Scheme is the name of a scoring scheme. Only documents scored with
+	// this scheme are summarized.
+	Scheme string
+
+	// Coder restricts the scores to those created by the user with this id.
+	// When empty, every score is used (the consensus layer).
+	Coder string
+}
+
+// scheme returns the configuration of the selected scoring scheme.
+func (opts summaryOptions) scheme() configScoringScheme {
+	scheme, ok := conf.Scores[opts.Scheme]
+	if !ok {
+		panic(ue("Scoring scheme **%s** does not exist.", opts.Scheme))
+	}
+	return scheme
+}
+
+// Layer names the set of scores that a summary was computed from.
+func (opts summaryOptions) Layer() string {
+	if len(opts.Coder) == 0 {
+		return layerConsensus
+	}
+	return "coder:" + opts.Coder
+}
+
+// documentSummary is the number of words in a document assigned to each
+// category of a scoring scheme.
+type documentSummary struct {
+	Document *document
+	Scheme   configScoringScheme
+
+	// Tokens is the number of words in the document.
+	Tokens int
+
+	// Counts maps category keys to the number of words in that category.
+	// It is filled in with add, so only categories in Scheme appear.
+	Counts map[string]int
+}
+
+// add counts `n` more words in the category with key `cat`. Scores in a
+// category that is no longer in the scheme are left out, so that Scored,
+// Proportion and Abstraction are all computed from the same words.
+func (sum *documentSummary) add(cat string, n int) {
+	if _, ok := sum.Scheme.Categories[cat]; ok {
+		sum.Counts[cat] += n
+	}
+}
+
+// Scored is the number of words with a score in the scheme.
+func (sum *documentSummary) Scored() int {
+	n := 0
+	for _, count := range sum.Counts {
+		n += count
+	}
+	return n
+}
+
+// Proportion is the fraction of scored words in the given category. It is
+// zero when no words are scored.
+func (sum *documentSummary) Proportion(cat string) float64 {
+	scored := sum.Scored()
+	if scored == 0 {
+		return 0
+	}
+	return float64(sum.Counts[cat]) / float64(scored)
+}
+
+// Abstraction is the abstraction index of the document: the mean value of
+// the categories of its scored words. The second return value is false when
+// no words are scored, in which case the index is undefined.
+func (sum *documentSummary) Abstraction() (float64, bool) {
+	scored, total := 0, 0
+	for key, count := range sum.Counts {
+		scored += count
+		total += count * sum.Scheme.Categories[key].Value
+	}
+	if scored == 0 {
+		return 0, false
+	}
+	return float64(total) / float64(scored), true
+}
+
+// summaries computes a summary of every document in the project that is
+// scored with the selected scheme, ordered by document name and recorded
+// date. Words are counted from the stored tokens, as they are for searches.
+// This is done with two queries no matter how many documents there are.
+func (proj *project) summaries(opts summaryOptions) []*documentSummary {
+	scheme := opts.scheme()
+
+	type docKey struct {
+		name     string
+		recorded time.Time
+	}
+	counts := make(map[docKey]map[string]int)
+	args := []interface{}{proj.Owner.Id, proj.Name, opts.Scheme}
+	coderCond := "TRUE"
+	if len(opts.Coder) > 0 {
+		coderCond = "created_by = $4"
+		args = append(args, opts.Coder)
+	}
+	rows := csql.Query(db, `
+		SELECT
+			document_name, document_recorded, name, COUNT(*)
+		FROM
+			score
+		WHERE
+			project_owner = $1 AND project_name = $2 AND category = $3
+			AND `+coderCond+`
+		GROUP BY
+			document_name, document_recorded, name
+	`, args...)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var key docKey
+		var cat string
+		var count int
+		csql.Scan(row, &key.name, &key.recorded, &cat, &count)
+		if counts[key] == nil {
+			counts[key] = make(map[string]int)
+		}
+		counts[key][cat] = count
+	})
+
+	sums := make([]*documentSummary, 0)
+	rows = csql.Query(db, `
+		SELECT
+			d.name, d.recorded, COALESCE(t.tokens, 0), d.created, d.modified
+		FROM
+			document d
+		JOIN
+			document_category dc
+			ON d.project_owner = dc.project_owner
+			AND d.project_name = dc.project_name
+			AND d.name = dc.document_name
+			AND d.recorded = dc.document_recorded
+		LEFT JOIN (
+			SELECT
+				document_name, document_recorded, COUNT(*) AS tokens
+			FROM
+				token
+			WHERE
+				project_owner = $1 AND project_name = $2
+			GROUP BY
+				document_name, document_recorded
+		) t
+			ON d.name = t.document_name
+			AND d.recorded = t.document_recorded
+		WHERE
+			d.project_owner = $1 AND d.project_name = $2
+			AND dc.category = $3
+		ORDER BY
+			d.name ASC, d.recorded ASC
+	`, proj.Owner.Id, proj.Name, opts.Scheme)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := &document{Project: proj}
+		var tokens int
+		csql.Scan(row, &d.Name, &d.Recorded, &tokens, &d.Created,
+			&d.Modified)
+		d.Display = nameToDisplay(d.Name)
+
+		sum := &documentSummary{
+			Document: d,
+			Scheme:   scheme,
+			Tokens:   tokens,
+			Counts:   make(map[string]int),
+		}
+		for cat, count := range counts[docKey{d.Name, d.Recorded}] {
+			sum.add(cat, count)
+		}
+		sums = append(sums, sum)
+	})
+	return sums
+}
+
+// summaryFileName returns a file name for an export of summaries that says
+// which scheme and layer the export was computed from.
+func summaryFileName(proj *project, opts summaryOptions, ext string) string {
+	layer := strings.Replace(opts.Layer(), ":", "-", -1)
+	return strings.Join(
+		[]string{proj.Name, "summary", opts.Scheme, layer}, "-") + "." + ext
+}
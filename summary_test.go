@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+)
+
+// Scores in a category that has since been removed from the scheme must be
+// left out of every statistic, not just the abstraction index.
+func TestSummaryUnknownCategory(t *testing.T) {
+	sum := &documentSummary{
+		Scheme: configScoringScheme{
+			Categories: map[string]configScoreCategory{
+				"dav": {Value: 1},
+				"adj": {Value: 4},
+			},
+		},
+		Counts: make(map[string]int),
+	}
+	sum.add("dav", 3)
+	sum.add("adj", 1)
+	sum.add("removed", 4)
+
+	if got := sum.Scored(); got != 4 {
+		t.Errorf("Scored() = %d, want 4", got)
+	}
+	if got := sum.Proportion("dav"); got != 0.75 {
+		t.Errorf("Proportion(dav) = %v, want 0.75", got)
+	}
+	if got, ok := sum.Abstraction(); !ok || got != 1.75 {
+		t.Errorf("Abstraction() = %v, %v, want 1.75, true", got, ok)
+	}
+}
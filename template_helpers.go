@@ -6,6 +6,7 @@ import (
 	"fmt"
 	html "html/template"
 	"log"
+	"math"
 	"reflect"
 	"strings"
 	"time"
@@ -20,6 +21,9 @@ var templateHelpers = map[string]interface{}{
 	"stringify": thStringify,
 	"jsonify":   thJsonify,
 	"combine":   thCombine,
+	"contains":  thContains,
+	"stat":      thStat,
+	"pvalue":    thPValue,
 
 	"datetime": thDateTime,
 	"date":     thDate,
@@ -86,6 +90,15 @@ func thCommafy(strs []string) string {
 	}
 }
 
+func thContains(items []string, needle string) bool {
+	for _, item := range items {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
 func thJsonify(v interface{}) html.JS {
 	bs, err := json.Marshal(v)
 	assert(err)
@@ -127,3 +140,19 @@ func thCombine(keyvals ...interface{}) map[string]interface{} {
 	}
 	return m
 }
+
+// thStat formats a statistic for display. Undefined statistics are shown as
+// a dash.
+func thStat(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "—"
+	}
+	return fmt.Sprintf("%.3f", f)
+}
+
+func thPValue(p float64) string {
+	if !math.IsNaN(p) && p < 0.001 {
+		return "< .001"
+	}
+	return thStat(p)
+}
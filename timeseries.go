@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Intervals that documents can be grouped by in a time series.
+var timeseriesIntervals = []string{"day", "week", "month", "year"}
+
+// timeseriesNone is the group of documents that don't have a value for the
+// metadata key that a time series is split by.
+const timeseriesNone = "(none)"
+
+// timeseriesOptions selects the scores in a time series and how documents
+// are grouped.
+type timeseriesOptions struct {
+	Scheme string
+	Coder  string
+
+	// Interval is one of timeseriesIntervals. Every document recorded in
+	// the same interval is summarized together.
+	Interval string
+
+	// Split is a metadata key. When set, there is a separate series for
+	// each value of the key.
+	Split string
+}
+
+func (opts timeseriesOptions) summary() summaryOptions {
+	return summaryOptions{Scheme: opts.Scheme, Coder: opts.Coder}
+}
+
+// defaults fills in a scheme and interval when they weren't chosen.
+func (opts *timeseriesOptions) defaults() {
+	if len(opts.Scheme) == 0 && len(conf.Scores) > 0 {
+		opts.Scheme = conf.Categories()[0]
+	}
+	if len(opts.Interval) == 0 {
+		opts.Interval = "month"
+	}
+	ok := false
+	for _, interval := range timeseriesIntervals {
+		ok = ok || interval == opts.Interval
+	}
+	if !ok {
+		panic(ue("Unknown interval **%s**.", opts.Interval))
+	}
+}
+
+// periodStart returns the start of the interval containing `t`. Weeks start
+// on Monday.
+func periodStart(t time.Time, interval string) time.Time {
+	y, m, d := t.Date()
+	switch interval {
+	case "week":
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(y, m, d-offset, 0, 0, 0, 0, time.UTC)
+	case "month":
+		return time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+	case "year":
+		return time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// periodLabel formats the start of an interval for display.
+func periodLabel(t time.Time, interval string) string {
+	switch interval {
+	case "week":
+		y, w := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", y, w)
+	case "month":
+		return t.Format("2006-01")
+	case "year":
+		return t.Format("2006")
+	}
+	return t.Format(recordedFmt)
+}
+
+// timeseriesPoint pools the scores of every document in a group that was
+// recorded in the same interval. Its summary has no document.
+type timeseriesPoint struct {
+	Period    time.Time
+	Documents int
+	Summary   *documentSummary
+}
+
+// timeseries is the series of points for one group of documents.
+type timeseries struct {
+	Group  string
+	Points []*timeseriesPoint
+}
+
+// timeseries computes a series for each group of documents in the project,
+// ordered by group. Points are ordered by period.
+func (proj *project) timeseries(opts timeseriesOptions) []*timeseries {
+	scheme := opts.summary().scheme()
+	meta := proj.metadata()
+
+	type pointKey struct {
+		group  string
+		period int64
+	}
+	points := make(map[pointKey]*timeseriesPoint)
+	groups := make(map[string]*timeseries)
+	for _, sum := range proj.summaries(opts.summary()) {
+		group := ""
+		if len(opts.Split) > 0 {
+			group = meta.ByDocument[sum.Document.Key()][opts.Split]
+			if len(group) == 0 {
+				group = timeseriesNone
+			}
+		}
+		period := periodStart(sum.Document.Recorded, opts.Interval)
+		key := pointKey{group, period.Unix()}
+		p := points[key]
+		if p == nil {
+			p = &timeseriesPoint{
+				Period: period,
+				Summary: &documentSummary{
+					Scheme: scheme,
+					Counts: make(map[string]int),
+				},
+			}
+			points[key] = p
+			if groups[group] == nil {
+				groups[group] = &timeseries{Group: group}
+			}
+			groups[group].Points = append(groups[group].Points, p)
+		}
+		p.Documents++
+		p.Summary.Tokens += sum.Tokens
+		for cat, count := range sum.Counts {
+			p.Summary.add(cat, count)
+		}
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	series := make([]*timeseries, len(names))
+	for i, name := range names {
+		series[i] = groups[name]
+		sort.Sort(timeseriesPoints(series[i].Points))
+	}
+	return series
+}
+
+type timeseriesPoints []*timeseriesPoint
+
+func (ps timeseriesPoints) Len() int { return len(ps) }
+func (ps timeseriesPoints) Less(i, j int) bool {
+	return ps[i].Period.Before(ps[j].Period)
+}
+func (ps timeseriesPoints) Swap(i, j int) { ps[i], ps[j] = ps[j], ps[i] }
+
+// timeseriesCharts returns a chart of the abstraction index and charts of
+// the category proportions. Without a split, every proportion is shown in
+// one chart. With a split, each category gets its own chart with a line per
+// group.
+func timeseriesCharts(
+	opts timeseriesOptions,
+	series []*timeseries,
+) []lineChart {
+	scheme := opts.summary().scheme()
+	label := func(t time.Time) string { return periodLabel(t, opts.Interval) }
+	groupName := func(s *timeseries) string {
+		if len(s.Group) == 0 {
+			return "All documents"
+		}
+		return s.Group
+	}
+
+	lo, hi := 0, 1
+	for i, cat := range scheme.Ordered() {
+		if i == 0 || cat.Value < lo {
+			lo = cat.Value
+		}
+		if i == 0 || cat.Value > hi {
+			hi = cat.Value
+		}
+	}
+	if lo == hi {
+		hi = lo + 1
+	}
+	abs := lineChart{
+		Title:  "Abstraction index",
+		YMin:   float64(lo),
+		YMax:   float64(hi),
+		XLabel: label,
+	}
+	for i, s := range series {
+		cs := chartSeries{Name: groupName(s), Color: chartColor(i)}
+		for _, p := range s.Points {
+			if v, ok := p.Summary.Abstraction(); ok {
+				cs.Points = append(cs.Points, chartPoint{p.Period, v,
+					pointLabel(opts, s, p, formatTick(v))})
+			}
+		}
+		abs.Series = append(abs.Series, cs)
+	}
+	charts := []lineChart{abs}
+
+	proportion := func(s *timeseries, cat string) []chartPoint {
+		points := make([]chartPoint, 0)
+		for _, p := range s.Points {
+			if p.Summary.Scored() > 0 {
+				v := p.Summary.Proportion(cat)
+				points = append(points, chartPoint{p.Period, v,
+					pointLabel(opts, s, p, formatTick(v))})
+			}
+		}
+		return points
+	}
+	if len(opts.Split) == 0 {
+		props := lineChart{
+			Title: "Proportion of scored words", YMax: 1, XLabel: label,
+		}
+		// Without a split, there's at most one series.
+		for _, s := range series {
+			for i, cat := range scheme.Ordered() {
+				props.Series = append(props.Series, chartSeries{
+					Name:   cat.Name,
+					Color:  chartColor(i),
+					Points: proportion(s, cat.Key),
+				})
+			}
+		}
+		return append(charts, props)
+	}
+	for _, cat := range scheme.Ordered() {
+		props := lineChart{
+			Title: "Proportion of scored words that are " + cat.Name,
+			YMax:  1, XLabel: label,
+		}
+		for i, s := range series {
+			props.Series = append(props.Series, chartSeries{
+				Name:   groupName(s),
+				Color:  chartColor(i),
+				Points: proportion(s, cat.Key),
+			})
+		}
+		charts = append(charts, props)
+	}
+	return charts
+}
+
+func pointLabel(
+	opts timeseriesOptions,
+	s *timeseries,
+	p *timeseriesPoint,
+	value string,
+) string {
+	parts := []string{periodLabel(p.Period, opts.Interval)}
+	if len(s.Group) > 0 {
+		parts = append(parts, s.Group)
+	}
+	parts = append(parts, value,
+		strconv.Itoa(p.Documents)+" documents, "+
+			strconv.Itoa(p.Summary.Scored())+" scored words")
+	return strings.Join(parts, ": ")
+}
+
+// timeseriesPage charts the abstraction index and category proportions of
+// a project's documents over the dates they were recorded.
+func timeseriesPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts timeseriesOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+
+	var charts []lineChart
+	if len(opts.Scheme) > 0 {
+		charts = timeseriesCharts(opts, proj.timeseries(opts))
+	}
+	w.html("timeseries", m{
+		"Title":     "Time series for " + proj.Display,
+		"Nav":       documentNav(w, proj, nil, "Time series"),
+		"P":         proj,
+		"Opts":      opts,
+		"Charts":    charts,
+		"Intervals": timeseriesIntervals,
+		"MetaKeys":  proj.metadata().Keys,
+		"Coders":    proj.members(),
+		"Conf":      conf,
+	})
+}
+
+// exportTimeseriesCSV writes the points of every series shown by
+// timeseriesPage, one row per group and period.
+func exportTimeseriesCSV(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	var opts timeseriesOptions
+	w.decodeQuery(&opts)
+	opts.defaults()
+	scheme := opts.summary().scheme()
+	series := proj.timeseries(opts)
+
+	name := summaryFileName(proj, opts.summary(), "csv")
+	name = strings.Replace(name, "-summary-",
+		"-timeseries-"+opts.Interval+"-", 1)
+	w.attachment(name, "text/csv; charset=utf-8")
+	out := csv.NewWriter(w.w)
+	header := []string{
+		"scheme", "scheme_version", "layer", "interval", "period", "label",
+		"split", "group", "documents", "tokens", "scored",
+	}
+	for _, cat := range scheme.Ordered() {
+		header = append(header, "n_"+cat.Key)
+	}
+	for _, cat := range scheme.Ordered() {
+		header = append(header, "p_"+cat.Key)
+	}
+	header = append(header, "abstraction")
+	assert(out.Write(header))
+
+	for _, s := range series {
+		for _, p := range s.Points {
+			record := []string{
+				opts.Scheme, scheme.VersionString(), opts.summary().Layer(),
+				opts.Interval, p.Period.Format(recordedFmt),
+				periodLabel(p.Period, opts.Interval), opts.Split, s.Group,
+				strconv.Itoa(p.Documents), strconv.Itoa(p.Summary.Tokens),
+				strconv.Itoa(p.Summary.Scored()),
+			}
+			for _, cat := range scheme.Ordered() {
+				record = append(record, strconv.Itoa(p.Summary.Counts[cat.Key]))
+			}
+			for _, cat := range scheme.Ordered() {
+				record = append(record,
+					formatFloat(p.Summary.Proportion(cat.Key)))
+			}
+			if abs, ok := p.Summary.Abstraction(); ok {
+				record = append(record, formatFloat(abs))
+			} else {
+				record = append(record, "")
+			}
+			assert(out.Write(record))
+		}
+	}
+	out.Flush()
+	assert(out.Error())
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/migration"
+)
+
+// token is a single scoreable word in a document. Its index is the value
+// stored in the `word` column of the `score` table.
+type token struct {
+	Index int
+	Text  string
+	Start int
+	End   int
+}
+
+// tokenize splits a document's content into words. Words are separated by
+// white space and have any leading or trailing punctuation removed. Anything
+// left empty after trimming punctuation is not a word and is skipped.
+//
+// Start and End are byte offsets into the content that delimit the trimmed
+// word, so that content[Start:End] == Text.
+//
+// Since scores refer to words by their index, the output of this function
+// must never change for a given document.
+func tokenize(content string) []token {
+	toks := make([]token, 0)
+	start := -1
+	add := func(end int) {
+		field := content[start:end]
+		trimmed := strings.TrimLeftFunc(field, unicode.IsPunct)
+		left := start + len(field) - len(trimmed)
+		trimmed = strings.TrimRightFunc(trimmed, unicode.IsPunct)
+		if len(trimmed) > 0 {
+			toks = append(toks, token{
+				Index: len(toks),
+				Text:  trimmed,
+				Start: left,
+				End:   left + len(trimmed),
+			})
+		}
+		start = -1
+	}
+	for i, r := range content {
+		if unicode.IsSpace(r) {
+			if start > -1 {
+				add(i)
+			}
+		} else if start == -1 {
+			start = i
+		}
+	}
+	if start > -1 {
+		add(len(content))
+	}
+	return toks
+}
+
+// tokenInsertBatch is the number of tokens inserted with each statement.
+const tokenInsertBatch = 500
+
+// insertTokens stores the tokens of a document along with their lower case
+// form and the lemma given by `lemma`. It is used both when adding a
+// document and in the migration that created the `token` table, so it
+// accepts any transaction.
+func insertTokens(
+	tx migration.LimitedTx,
+	owner, project, name string,
+	recorded time.Time,
+	toks []token,
+	lemma func(string) string,
+) error {
+	for len(toks) > 0 {
+		batch := toks
+		if len(batch) > tokenInsertBatch {
+			batch = batch[0:tokenInsertBatch]
+		}
+		toks = toks[len(batch):]
+
+		values := make([]string, len(batch))
+		args := []interface{}{owner, project, name, recorded}
+		for i, tok := range batch {
+			n := len(args)
+			values[i] = fmt.Sprintf("($1, $2, $3, $4, $%d, $%d, $%d, $%d)",
+				n+1, n+2, n+3, n+4)
+			args = append(args, tok.Index, tok.Text,
+				strings.ToLower(tok.Text), lemma(tok.Text))
+		}
+		_, err := tx.Exec(`
+			INSERT INTO token (
+				project_owner, project_name,
+				document_name, document_recorded,
+				word, surface, lower, lemma
+			) VALUES `+strings.Join(values, ", "), args...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
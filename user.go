@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"log"
 	"net/smtp"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/BurntSushi/csql"
 	"github.com/BurntSushi/locker"
 )
 
@@ -16,11 +20,84 @@ func newLcmUser(confUser configUser) *lcmUser {
 	return &lcmUser{confUser}
 }
 
+// users holds every user account in the `users` table. Users are looked
+// up for nearly every row that is shown, so the table is read into memory
+// when the server starts and read again whenever an account is changed.
+var users = &userStore{byId: make(map[string]configUser)}
+
+type userStore struct {
+	sync.RWMutex
+	byId map[string]configUser
+}
+
+// load reads every user and their friends from the database.
+func (us *userStore) load() {
+	byId := make(map[string]configUser)
+	rows := csql.Query(db, `
+		SELECT id, name, email, admin, time_zone, date_fmt, time_fmt
+		FROM users
+	`)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var u configUser
+		csql.Scan(row, &u.Id, &u.Name, &u.Email, &u.Admin, &u.TimeZone,
+			&u.DateFmt, &u.TimeFmt)
+		var err error
+		if u.timeZone, err = time.LoadLocation(u.TimeZone); err != nil {
+			log.Printf("Invalid time zone '%s' for user '%s': %s",
+				u.TimeZone, u.Id, err)
+			u.timeZone = time.UTC
+		}
+		byId[u.Id] = u
+	})
+
+	rows = csql.Query(db, `
+		SELECT userid, friend FROM user_friend ORDER BY userid, friend
+	`)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		var userid, friend string
+		csql.Scan(row, &userid, &friend)
+		u := byId[userid]
+		u.Friends = append(u.Friends, friend)
+		byId[userid] = u
+	})
+	for id, u := range byId {
+		u.Collaborators = make([]*lcmUser, len(u.Friends))
+		for i, friend := range u.Friends {
+			u.Collaborators[i] = newLcmUser(byId[friend])
+		}
+		sort.Sort(usersAlphabetical(u.Collaborators))
+		byId[id] = u
+	}
+
+	us.Lock()
+	defer us.Unlock()
+	us.byId = byId
+}
+
+func (us *userStore) get(id string) (configUser, bool) {
+	us.RLock()
+	defer us.RUnlock()
+	u, ok := us.byId[id]
+	return u, ok
+}
+
+// all returns every user, sorted by name.
+func (us *userStore) all() []*lcmUser {
+	us.RLock()
+	defer us.RUnlock()
+	all := make([]*lcmUser, 0, len(us.byId))
+	for _, u := range us.byId {
+		all = append(all, newLcmUser(u))
+	}
+	sort.Sort(usersAlphabetical(all))
+	return all
+}
+
 func findUserById(userid string) *lcmUser {
 	if len(userid) == 0 {
 		panic(ue("No user specified."))
 	}
-	if user, ok := conf.Users[userid]; ok {
+	if user, ok := users.get(userid); ok {
 		return newLcmUser(user)
 	}
 	panic(ue("Could not find user with id **%s**.", userid))
@@ -30,12 +107,21 @@ func findUserById(userid string) *lcmUser {
 // because we only look for users by number to match things in the DB. If
 // we don't find a match, we want to be free to ignore it.
 func findUserByNo(userid string) *lcmUser {
-	if user, ok := conf.usersById[userid]; ok {
+	if user, ok := users.get(userid); ok {
 		return newLcmUser(user)
 	}
 	return nil
 }
 
+func findUserByEmail(email string) *lcmUser {
+	for _, user := range users.all() {
+		if email == user.Email {
+			return user
+		}
+	}
+	return nil
+}
+
 func (user *lcmUser) String() string {
 	return user.Name
 }
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// User ids appear in URLs (they own projects), so they are kept simple.
+var reUserId = regexp.MustCompile("^[a-zA-Z0-9][-a-zA-Z0-9_.]*$")
+
+type formUser struct {
+	Id       string
+	Name     string
+	Email    string
+	TimeZone string
+	DateFmt  string
+	TimeFmt  string
+	Admin    bool
+	Friends  []string
+}
+
+func (form formUser) user() configUser {
+	return configUser{
+		Id:       strings.TrimSpace(form.Id),
+		Name:     strings.TrimSpace(form.Name),
+		Email:    strings.TrimSpace(form.Email),
+		Admin:    form.Admin,
+		TimeZone: strings.TrimSpace(form.TimeZone),
+		DateFmt:  form.DateFmt,
+		TimeFmt:  form.TimeFmt,
+		Friends:  uniqueFriends(form.Friends),
+	}
+}
+
+// uniqueFriends removes empty and repeated ids from a list of friends.
+func uniqueFriends(ids []string) []string {
+	friends := make([]string, 0, len(ids))
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		id = strings.TrimSpace(id)
+		if len(id) > 0 && !seen[id] {
+			seen[id] = true
+			friends = append(friends, id)
+		}
+	}
+	return friends
+}
+
+func newFormUser(u configUser) formUser {
+	return formUser{
+		Id:       u.Id,
+		Name:     u.Name,
+		Email:    u.Email,
+		TimeZone: u.TimeZone,
+		DateFmt:  u.DateFmt,
+		TimeFmt:  u.TimeFmt,
+		Admin:    u.Admin,
+		Friends:  u.Friends,
+	}
+}
+
+// requireAdmin stops anyone but administrators from managing users.
+// Users can only be managed when logged in, so that a leaked token cannot
+// be used to add accounts.
+func (w *web) requireAdmin() {
+	if w.token != nil {
+		panic(ue("Users cannot be managed with an API token."))
+	}
+	if !w.user.Admin {
+		panic(ue("Only administrators can manage users."))
+	}
+}
+
+// adminUsers lists every user and adds new ones. A new user sets their
+// password by following the link shown once they're added.
+func adminUsers(w *web) {
+	w.requireAdmin()
+	show := func(form formUser, msg string) {
+		w.html("admin-users", m{
+			"Title":   "Users",
+			"Nav":     w.mkNav(nav{"Users", ""}),
+			"Users":   users.all(),
+			"Form":    form,
+			"Message": formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(formUser{
+			TimeZone: w.user.TimeZone,
+			DateFmt:  w.user.DateFmt,
+			TimeFmt:  w.user.TimeFmt,
+		}, "")
+	} else if w.r.Method == "POST" {
+		var form formUser
+		w.decode(&form)
+		u := form.user()
+		if err := saveUser(u, true); err != nil {
+			show(form, err.Error())
+			return
+		}
+		show(formUser{
+			TimeZone: form.TimeZone,
+			DateFmt:  form.DateFmt,
+			TimeFmt:  form.TimeFmt,
+		}, "Added **"+u.Name+"**. They can set their password at "+
+			w.absoluteURL(w.url("newpassword", u.Id))+".")
+	} else {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+}
+
+// adminUser changes the account of a user. Their id can't be changed,
+// since it identifies their projects and scores.
+func adminUser(w *web) {
+	w.requireAdmin()
+	user := findUserById(w.params["id"])
+	show := func(form formUser, msg string) {
+		w.html("admin-user", m{
+			"Title": "User " + user.Name,
+			"Nav": w.mkNav(
+				nav{"Users", w.url("admin-users")},
+				nav{user.Name, ""},
+			),
+			"Users":   users.all(),
+			"Form":    form,
+			"Message": formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(newFormUser(user.configUser), "")
+	} else if w.r.Method == "POST" {
+		var form formUser
+		w.decode(&form)
+		form.Id = user.Id
+		u := form.user()
+		if u.Id == w.user.Id && !u.Admin {
+			show(form, "You can't remove your own administrator access.")
+			return
+		}
+		if err := saveUser(u, false); err != nil {
+			show(form, err.Error())
+			return
+		}
+		http.Redirect(w.w, w.r, w.url("admin-users"), 302)
+	} else {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+}
+
+// validate checks a user's account before it is saved.
+func (u configUser) validate() error {
+	if !reUserId.MatchString(u.Id) {
+		return ue("User ids must start with a letter or number and may " +
+			"only contain letters, numbers, dashes, dots and underscores.")
+	}
+	if len(u.Name) == 0 {
+		return ue("Please give a name.")
+	}
+	if !strings.Contains(u.Email, "@") {
+		return ue("**%s** is not an email address.", u.Email)
+	}
+	if _, err := time.LoadLocation(u.TimeZone); err != nil {
+		return ue("Invalid time zone **%s**: %s", u.TimeZone, err)
+	}
+	if len(u.DateFmt) == 0 || len(u.TimeFmt) == 0 {
+		return ue("Please give date and time formats.")
+	}
+	for _, friend := range u.Friends {
+		if friend == u.Id {
+			return ue("A user cannot be friends with themself.")
+		}
+		if _, ok := users.get(friend); !ok {
+			return ue("Could not find user with id **%s**.", friend)
+		}
+	}
+	return nil
+}
+
+// saveUser adds a user, or changes one if `isNew` is false, and replaces
+// their friends. Every user is then loaded again.
+func saveUser(u configUser, isNew bool) error {
+	if err := u.validate(); err != nil {
+		return err
+	}
+	if _, ok := users.get(u.Id); ok && isNew {
+		return ue("A user with id **%s** already exists.", u.Id)
+	}
+	if other := findUserByEmail(u.Email); other != nil && other.Id != u.Id {
+		return ue("**%s** already uses the email address **%s**.",
+			other.Name, u.Email)
+	}
+	csql.Tx(db, func(tx *sql.Tx) {
+		if isNew {
+			csql.Exec(tx, `
+				INSERT INTO users (
+					id, name, email, admin, time_zone, date_fmt, time_fmt
+				) VALUES ($1, $2, $3, $4, $5, $6, $7)
+				`, u.Id, u.Name, u.Email, u.Admin, u.TimeZone,
+				u.DateFmt, u.TimeFmt)
+		} else {
+			csql.Exec(tx, `
+				UPDATE users
+				SET name = $2, email = $3, admin = $4, time_zone = $5,
+					date_fmt = $6, time_fmt = $7
+				WHERE id = $1
+				`, u.Id, u.Name, u.Email, u.Admin, u.TimeZone,
+				u.DateFmt, u.TimeFmt)
+		}
+		csql.Exec(tx, `DELETE FROM user_friend WHERE userid = $1`, u.Id)
+		for _, friend := range u.Friends {
+			csql.Exec(tx, `
+				INSERT INTO user_friend (userid, friend) VALUES ($1, $2)
+				`, u.Id, friend)
+		}
+	})
+	users.load()
+	return nil
+}
@@ -28,6 +28,10 @@ type web struct {
 	decode      formDecoder
 	multiDecode multiDecoder
 	user        *lcmUser
+
+	// token is the API token the user authenticated with, or nil if they
+	// logged in.
+	token *apiToken
 }
 
 func webGuest(
@@ -65,7 +69,19 @@ func webAuth(
 	dec formDecoder,
 	mdec multiDecoder,
 ) {
+	// An API token given as a bearer credential takes precedence over the
+	// session.
 	userId := sessGet(s, sessionUserId)
+	var tok *apiToken
+	if secret := bearerToken(r); len(secret) > 0 {
+		if tok = findAPIToken(secret); tok == nil {
+			panic(ae("Invalid or expired API token."))
+		}
+		if !tok.allows(r.Method) {
+			panic(ue("API token **%s** is read-only.", tok.Name))
+		}
+		userId = tok.UserId
+	}
 	if len(userId) == 0 {
 		panic(ae(""))
 	}
@@ -73,7 +89,8 @@ func webAuth(
 		lg: lg, c: c, routes: routes, params: params,
 		r: r, w: w, s: s, ren: ren,
 		decode: dec, multiDecode: mdec,
-		user: findUserById(userId),
+		user:  findUserById(userId),
+		token: tok,
 	}
 	ren.Template().Funcs(template.FuncMap{
 		"url": state.url,
@@ -110,7 +127,6 @@ func (w *web) tplData(data m) m {
 			data["User"] = w.user
 		}
 	}
-	w.lg.Printf("%#v", data)
 	return data
 }
 
@@ -141,9 +157,18 @@ func recovery(
 ) {
 	defer func() {
 		if r := recover(); r != nil {
+			// Scripts using an API token can't read error pages, so they
+			// always get errors in JSON.
+			if err, ok := r.(error); ok && len(bearerToken(req)) > 0 {
+				if _, ok := err.(apiError); !ok {
+					r = apiError{err}
+				}
+			}
 			switch err := r.(type) {
 			case jsonError:
 				handleJsonError(err, ren)
+			case apiError:
+				handleAPIError(err, ren)
 			case authError:
 				authenticate(err, dec, ren, req)
 			case userError:
@@ -163,22 +188,23 @@ func recovery(
 }
 
 func handleJsonError(err jsonError, ren render.Render) {
-	switch e := err.error.(type) {
+	ren.JSON(200, m{
+		"status":  errorStatus(err.error),
+		"message": formatMessage(err.Error()),
+	})
+}
+
+// errorStatus classifies an error for JSON responses: "noauth" when the user
+// must log in, "fail" when the request can't be satisfied and "error" for
+// anything else (which is a bug).
+func errorStatus(err error) string {
+	switch err.(type) {
 	case authError:
-		ren.JSON(200, m{
-			"status":  "noauth",
-			"message": formatMessage(e.Error()),
-		})
+		return "noauth"
 	case userError:
-		ren.JSON(200, m{
-			"status":  "fail",
-			"message": formatMessage(e.Error()),
-		})
+		return "fail"
 	default:
-		ren.JSON(200, m{
-			"status":  "error",
-			"message": formatMessage(e.Error()),
-		})
+		return "error"
 	}
 }
 
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/csql"
+)
+
+// Events that are sent to webhooks. A document is completed when every one
+// of its words is scored in every scheme it is scored with (see
+// projectDashboard), and the coder who set the last score completed it. It
+// is adjudicated when the owner of the project finishes adjudicating it
+// (see adjudicateDocument). Pings are only sent when asked for from the
+// webhooks page.
+const (
+	hookDocumentAdded       = "document.added"
+	hookDocumentCompleted   = "document.completed"
+	hookDocumentAdjudicated = "document.adjudicated"
+	hookPing                = "ping"
+)
+
+// hookEvents are the events a webhook can subscribe to.
+var hookEvents = []string{
+	hookDocumentAdded, hookDocumentCompleted, hookDocumentAdjudicated,
+}
+
+const (
+	// hookMaxAttempts is how many times a delivery is tried before giving
+	// up on it.
+	hookMaxAttempts = 8
+
+	// hookBackoff is how long to wait before retrying a failed delivery. It
+	// doubles after each attempt, so the last attempt is about an hour
+	// after the first.
+	hookBackoff = 30 * time.Second
+
+	// hookPoll is how often the queue is checked for deliveries that are
+	// due. New deliveries are sent immediately.
+	hookPoll = 10 * time.Second
+
+	// hookResponseMax is the number of bytes of a receiver's response kept
+	// in the delivery log.
+	hookResponseMax = 1024
+
+	// hookLogSize is the number of deliveries shown on the webhooks page.
+	hookLogSize = 50
+)
+
+var (
+	hookClient = &http.Client{Timeout: 10 * time.Second}
+
+	// hookWake tells the delivery queue that there is something new to
+	// send.
+	hookWake = make(chan struct{}, 1)
+)
+
+// webhook is a URL that is sent a JSON payload when certain events happen in
+// a project. Payloads are signed with the webhook's secret: the
+// X-LCM-Signature header is `sha256=` followed by the hex encoded
+// HMAC-SHA256 of the request body.
+type webhook struct {
+	Id        int
+	Project   *project
+	URL       string
+	Secret    string
+	Events    []string
+	CreatedBy *lcmUser
+	Created   time.Time
+}
+
+// hookPayload is the body of a request to a webhook.
+type hookPayload struct {
+	Event    string
+	Created  time.Time
+	Project  hookProject
+	Document *hookDocument `json:",omitempty"`
+
+	// User is the id of the user who caused the event.
+	User string
+}
+
+type hookProject struct {
+	Owner string
+	Name  string
+}
+
+type hookDocument struct {
+	Name     string
+	Display  string
+	Recorded string
+}
+
+// webhookDelivery is a payload queued for a webhook, along with the result
+// of the latest attempt to send it.
+type webhookDelivery struct {
+	Id          int
+	URL         string
+	Event       string
+	Payload     string
+	Created     time.Time
+	Attempts    int
+	NextAttempt *time.Time
+	LastAttempt *time.Time
+	Delivered   *time.Time
+	StatusCode  int
+	Response    string
+	Error       string
+}
+
+// Status is "delivered", "failed" (after running out of attempts) or
+// "pending".
+func (del *webhookDelivery) Status() string {
+	if del.Delivered != nil {
+		return "delivered"
+	} else if del.NextAttempt == nil {
+		return "failed"
+	}
+	return "pending"
+}
+
+func hookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func newHookSecret() string {
+	bs := make([]byte, 20)
+	_, err := rand.Read(bs)
+	assert(err)
+	return hex.EncodeToString(bs)
+}
+
+func insertWebhook(
+	creator *lcmUser,
+	proj *project,
+	rawurl string,
+	events []string,
+) (*webhook, error) {
+	hook := &webhook{
+		Project:   proj,
+		URL:       strings.TrimSpace(rawurl),
+		Secret:    newHookSecret(),
+		Events:    events,
+		CreatedBy: creator,
+		Created:   time.Now().UTC(),
+	}
+	if err := hook.validate(); err != nil {
+		return nil, err
+	}
+	err := db.QueryRow(`
+		INSERT INTO webhook (
+			project_owner, project_name, url, secret, events,
+			created_by, created
+		) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+		`, proj.Owner.Id, proj.Name, hook.URL, hook.Secret,
+		strings.Join(hook.Events, ","), creator.Id, hook.Created).
+		Scan(&hook.Id)
+	assert(err)
+	return hook, nil
+}
+
+func (hook *webhook) validate() error {
+	u, err := url.Parse(hook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		len(u.Host) == 0 {
+		return ue("**%s** is not a valid http or https URL.", hook.URL)
+	}
+	if len(hook.Events) == 0 {
+		return ue("Please choose at least one event for the webhook.")
+	}
+	for _, event := range hook.Events {
+		if !thContains(hookEvents, event) {
+			return ue("**%s** is not a webhook event.", event)
+		}
+	}
+	return nil
+}
+
+// Wants reports whether the webhook is subscribed to `event`.
+func (hook *webhook) Wants(event string) bool {
+	return thContains(hook.Events, event)
+}
+
+// webhooks returns the webhooks of the project, oldest first.
+func (proj *project) webhooks() []*webhook {
+	hooks := make([]*webhook, 0)
+	rows := csql.Query(db, `
+		SELECT
+			id, url, secret, events, created_by, created
+		FROM
+			webhook
+		WHERE
+			project_owner = $1 AND project_name = $2
+		ORDER BY
+			id ASC
+	`, proj.Owner.Id, proj.Name)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		hook := &webhook{Project: proj}
+		var events, createdBy string
+		csql.Scan(row, &hook.Id, &hook.URL, &hook.Secret, &events,
+			&createdBy, &hook.Created)
+		hook.Events = strings.Split(events, ",")
+		hook.CreatedBy = findUserByNo(createdBy)
+		hooks = append(hooks, hook)
+	})
+	return hooks
+}
+
+// getWebhook finds a webhook of the project given its id.
+func (proj *project) getWebhook(id string) *webhook {
+	for _, hook := range proj.webhooks() {
+		if strconv.Itoa(hook.Id) == id {
+			return hook
+		}
+	}
+	panic(ue("Could not find webhook **%s** in project **%s**.",
+		id, proj.Display))
+}
+
+// triggerHooks queues a delivery of `event` to every webhook of the project
+// that is subscribed to it. `d` may be nil.
+func (proj *project) triggerHooks(event string, user *lcmUser, d *document) {
+	for _, hook := range proj.webhooks() {
+		if hook.Wants(event) {
+			hook.enqueue(event, user, d)
+		}
+	}
+}
+
+// enqueue adds a delivery of `event` to the queue and wakes it up.
+func (hook *webhook) enqueue(event string, user *lcmUser, d *document) {
+	payload := hookPayload{
+		Event:   event,
+		Created: time.Now().UTC(),
+		Project: hookProject{
+			Owner: hook.Project.Owner.Id,
+			Name:  hook.Project.Name,
+		},
+		User: user.Id,
+	}
+	if d != nil {
+		payload.Document = &hookDocument{
+			Name:     d.Name,
+			Display:  d.Display,
+			Recorded: d.RecordedKey(),
+		}
+	}
+	body, err := json.Marshal(payload)
+	assert(err)
+	csql.Exec(db, `
+		INSERT INTO webhook_delivery
+			(webhook, event, payload, created, next_attempt)
+		VALUES
+			($1, $2, $3, $4, $4)
+		`, hook.Id, event, string(body), payload.Created)
+	wakeHooks()
+}
+
+func wakeHooks() {
+	select {
+	case hookWake <- struct{}{}:
+	default:
+	}
+}
+
+// deliverHooks sends queued deliveries as they become due. It never
+// returns.
+func deliverHooks() {
+	ticker := time.Tick(hookPoll)
+	for {
+		select {
+		case <-ticker:
+		case <-hookWake:
+		}
+		deliverDueHooks()
+	}
+}
+
+// deliverDueHooks makes one attempt at each delivery that is due. Problems
+// with the database are logged rather than stopping the queue.
+func deliverDueHooks() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Could not deliver webhooks: %v", r)
+		}
+	}()
+
+	type due struct {
+		del         *webhookDelivery
+		url, secret string
+	}
+	dues := make([]due, 0)
+	rows := csql.Query(db, `
+		SELECT
+			d.id, d.event, d.payload, d.attempts, w.url, w.secret
+		FROM
+			webhook_delivery d
+		JOIN
+			webhook w ON w.id = d.webhook
+		WHERE
+			d.next_attempt <= $1
+		ORDER BY
+			d.next_attempt ASC
+		LIMIT 100
+	`, time.Now().UTC())
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		d := due{del: &webhookDelivery{}}
+		csql.Scan(row, &d.del.Id, &d.del.Event, &d.del.Payload,
+			&d.del.Attempts, &d.url, &d.secret)
+		dues = append(dues, d)
+	})
+	for _, d := range dues {
+		d.del.attempt(d.url, d.secret)
+	}
+}
+
+// attempt sends the delivery once and records the result.
+func (del *webhookDelivery) attempt(url, secret string) {
+	now := time.Now().UTC()
+	del.try(url, secret, now)
+	csql.Exec(db, `
+		UPDATE webhook_delivery
+		SET attempts = $2, next_attempt = $3, last_attempt = $4,
+			delivered = $5, status_code = $6, response = $7, error = $8
+		WHERE id = $1
+		`, del.Id, del.Attempts, del.NextAttempt, now, del.Delivered,
+		del.StatusCode, del.Response, del.Error)
+}
+
+// try sends the delivery once at `now` and sets its result. A failed
+// delivery is scheduled to be retried until it runs out of attempts.
+func (del *webhookDelivery) try(url, secret string, now time.Time) {
+	del.Attempts++
+	del.StatusCode, del.Response, del.Error = 0, "", ""
+	del.NextAttempt = nil
+	if err := del.send(url, secret); err != nil {
+		del.Error = err.Error()
+		if del.Attempts < hookMaxAttempts {
+			next := now.Add(hookBackoff << uint(del.Attempts-1))
+			del.NextAttempt = &next
+		}
+	} else {
+		del.Delivered = &now
+	}
+}
+
+// send posts the payload to the webhook. Any response other than 2xx is an
+// error.
+func (del *webhookDelivery) send(url, secret string) error {
+	body := []byte(del.Payload)
+	req, err := http.NewRequest("POST", url, strings.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "lcmweb-webhook")
+	req.Header.Set("X-LCM-Event", del.Event)
+	req.Header.Set("X-LCM-Delivery", strconv.Itoa(del.Id))
+	req.Header.Set("X-LCM-Signature", hookSignature(secret, body))
+
+	resp, err := hookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bs, _ := ioutil.ReadAll(io.LimitReader(resp.Body, hookResponseMax))
+	del.StatusCode = resp.StatusCode
+	del.Response = strings.ToValidUTF8(string(bs), "")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("the receiver responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// deliveries returns the most recent deliveries to the webhooks of the
+// project, newest first.
+func (proj *project) deliveries() []*webhookDelivery {
+	dels := make([]*webhookDelivery, 0)
+	rows := csql.Query(db, `
+		SELECT
+			d.id, w.url, d.event, d.payload, d.created, d.attempts,
+			d.next_attempt, d.last_attempt, d.delivered, d.status_code,
+			d.response, d.error
+		FROM
+			webhook_delivery d
+		JOIN
+			webhook w ON w.id = d.webhook
+		WHERE
+			w.project_owner = $1 AND w.project_name = $2
+		ORDER BY
+			d.created DESC, d.id DESC
+		LIMIT $3
+	`, proj.Owner.Id, proj.Name, hookLogSize)
+	csql.ForRow(rows, func(row csql.RowScanner) {
+		del := &webhookDelivery{}
+		csql.Scan(row, &del.Id, &del.URL, &del.Event, &del.Payload,
+			&del.Created, &del.Attempts, &del.NextAttempt, &del.LastAttempt,
+			&del.Delivered, &del.StatusCode, &del.Response, &del.Error)
+		dels = append(dels, del)
+	})
+	return dels
+}
+
+type formWebhook struct {
+	URL    string
+	Events []string
+}
+
+// webhooksPage lists the webhooks of a project with their recent
+// deliveries, and adds new webhooks. Only the owner of a project can manage
+// its webhooks, since the secrets are shown.
+func webhooksPage(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can manage their webhooks."))
+	}
+	show := func(form formWebhook, msg string) {
+		w.html("webhooks", m{
+			"Title":      "Webhooks for " + proj.Display,
+			"Nav":        documentNav(w, proj, nil, "Webhooks"),
+			"P":          proj,
+			"Form":       form,
+			"Events":     hookEvents,
+			"Hooks":      proj.webhooks(),
+			"Deliveries": proj.deliveries(),
+			"Message":    formatMessage(msg),
+		})
+	}
+	if w.r.Method == "GET" {
+		show(formWebhook{Events: hookEvents}, "")
+	} else if w.r.Method == "POST" {
+		var form formWebhook
+		w.decode(&form)
+		if _, err := insertWebhook(w.user, proj, form.URL,
+			form.Events); err != nil {
+			show(form, err.Error())
+			return
+		}
+		w.redirectHooks(proj)
+	} else {
+		panic(ef("Unrecognized request method: %s", w.r.Method))
+	}
+}
+
+// deleteWebhook removes a webhook along with its deliveries, including any
+// that haven't been sent yet.
+func deleteWebhook(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can manage their webhooks."))
+	}
+	hook := proj.getWebhook(w.params["id"])
+	csql.Exec(db, `DELETE FROM webhook WHERE id = $1`, hook.Id)
+	w.redirectHooks(proj)
+}
+
+// pingWebhook sends a ping to a webhook, so that its receiver can be tested.
+func pingWebhook(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can manage their webhooks."))
+	}
+	proj.getWebhook(w.params["id"]).enqueue(hookPing, w.user, nil)
+	w.redirectHooks(proj)
+}
+
+// retryDelivery sends a delivery again right away, with its full number of
+// attempts.
+func retryDelivery(w *web) {
+	proj := getProject(w.user, w.params["owner"], w.params["project"])
+	if w.user.Id != proj.Owner.Id {
+		panic(ue("Only owners of projects can manage their webhooks."))
+	}
+	id, err := strconv.Atoi(w.params["id"])
+	if err != nil {
+		panic(ue("Invalid delivery **%s**.", w.params["id"]))
+	}
+	csql.Exec(db, `
+		UPDATE webhook_delivery
+		SET attempts = 0, next_attempt = $2, delivered = NULL
+		WHERE id = $1 AND webhook IN (
+			SELECT id FROM webhook
+			WHERE project_owner = $3 AND project_name = $4
+		)
+		`, id, time.Now().UTC(), proj.Owner.Id, proj.Name)
+	wakeHooks()
+	w.redirectHooks(proj)
+}
+
+func (w *web) redirectHooks(proj *project) {
+	http.Redirect(w.w, w.r,
+		w.routes.URLFor("webhooks", proj.Owner.Id, proj.Name), 302)
+}
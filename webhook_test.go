@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// hookReceiver is a webhook receiver that checks the signature of every
+// request with `secret` and responds with `status`.
+func hookReceiver(t *testing.T, secret string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mac := hmac.New(sha256.New, []byte(secret))
+			mac.Write(body)
+			want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+			if got := r.Header.Get("X-LCM-Signature"); got != want {
+				t.Errorf("signature is %q, want %q", got, want)
+			}
+			if got := r.Header.Get("X-LCM-Event"); got != hookPing {
+				t.Errorf("event is %q, want %q", got, hookPing)
+			}
+			w.WriteHeader(status)
+		}))
+}
+
+func TestWebhookDelivered(t *testing.T) {
+	srv := hookReceiver(t, "s3cret", http.StatusNoContent)
+	defer srv.Close()
+
+	del := &webhookDelivery{Id: 1, Event: hookPing, Payload: `{"a":1}`}
+	now := time.Now().UTC()
+	del.try(srv.URL, "s3cret", now)
+	if del.Status() != "delivered" || !del.Delivered.Equal(now) {
+		t.Fatalf("delivery is %s (%s), want delivered",
+			del.Status(), del.Error)
+	}
+	if del.StatusCode != http.StatusNoContent {
+		t.Errorf("status code is %d, want %d",
+			del.StatusCode, http.StatusNoContent)
+	}
+}
+
+func TestWebhookRetried(t *testing.T) {
+	srv := hookReceiver(t, "s3cret", http.StatusInternalServerError)
+	defer srv.Close()
+
+	del := &webhookDelivery{Id: 1, Event: hookPing, Payload: `{"a":1}`}
+	now := time.Now().UTC()
+	wait := hookBackoff
+	for i := 1; i < hookMaxAttempts; i++ {
+		del.try(srv.URL, "s3cret", now)
+		if del.Status() != "pending" || del.NextAttempt == nil {
+			t.Fatalf("attempt %d: delivery is %s, want pending",
+				i, del.Status())
+		}
+		if got := del.NextAttempt.Sub(now); got != wait {
+			t.Errorf("attempt %d: retried after %s, want %s", i, got, wait)
+		}
+		if len(del.Error) == 0 {
+			t.Errorf("attempt %d: no error was recorded", i)
+		}
+		wait *= 2
+	}
+	del.try(srv.URL, "s3cret", now)
+	if del.Status() != "failed" {
+		t.Fatalf("delivery is %s after %d attempts, want failed",
+			del.Status(), del.Attempts)
+	}
+}
+
+func TestHookSignature(t *testing.T) {
+	// From RFC 4231, test case 2.
+	got := hookSignature("Jefe", []byte("what do ya want for nothing?"))
+	want := "sha256=" +
+		"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if got != want {
+		t.Errorf("signature is %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// This file implements a minimal writer for Office Open XML workbooks
+// (.xlsx). Strings are written inline instead of in a shared strings table,
+// so that each sheet can be streamed into the archive as it is generated.
+
+// Cell styles. Every fill color given to newXLSXWriter gets its own style,
+// starting at xlsxStyleFill.
+const (
+	xlsxStyleNormal = 0
+	xlsxStyleBold   = 1
+	xlsxStyleFill   = 2
+)
+
+// Namespaces and content types used by the parts of a workbook.
+const (
+	xlsxNamespace = "http://schemas.openxmlformats.org/" +
+		"spreadsheetml/2006/main"
+	xlsxPackageRels = "http://schemas.openxmlformats.org/" +
+		"package/2006/relationships"
+	xlsxOfficeRels = "http://schemas.openxmlformats.org/" +
+		"officeDocument/2006/relationships"
+	xlsxContentTypes = "http://schemas.openxmlformats.org/" +
+		"package/2006/content-types"
+	xlsxSpreadsheetML = "application/vnd.openxmlformats-officedocument." +
+		"spreadsheetml"
+)
+
+// xlsxCell is a single cell in a row. Its value may be a string, an int, a
+// float64 or nil for an empty cell.
+type xlsxCell struct {
+	Value interface{}
+	Style int
+}
+
+// xlsxWriter writes the sheets of a workbook one at a time.
+type xlsxWriter struct {
+	zip    *zip.Writer
+	fills  []string
+	sheets []string
+	cur    *bufio.Writer
+	row    int
+	err    error
+}
+
+// newXLSXWriter starts a workbook. `fills` are colors of the form `#rrggbb`.
+// The i'th color is used by cells with style xlsxStyleFill + i.
+func newXLSXWriter(w io.Writer, fills []string) *xlsxWriter {
+	return &xlsxWriter{zip: zip.NewWriter(w), fills: fills}
+}
+
+// sheet finishes the current sheet (if any) and starts a new one. Sheet
+// names are made valid and unique as needed.
+func (xw *xlsxWriter) sheet(name string) {
+	xw.endSheet()
+	if xw.err != nil {
+		return
+	}
+	xw.sheets = append(xw.sheets, xw.sheetName(name))
+	f, err := xw.zip.Create(
+		fmt.Sprintf("xl/worksheets/sheet%d.xml", len(xw.sheets)))
+	if err != nil {
+		xw.err = err
+		return
+	}
+	xw.cur = bufio.NewWriter(f)
+	xw.row = 0
+	xw.printf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+
+		"\n"+`<worksheet xmlns="%s"><sheetData>`, xlsxNamespace)
+}
+
+// writeRow adds a row of cells to the current sheet.
+func (xw *xlsxWriter) writeRow(cells ...xlsxCell) {
+	xw.row++
+	xw.printf(`<row r="%d">`, xw.row)
+	for i, cell := range cells {
+		ref := xlsxColumn(i) + strconv.Itoa(xw.row)
+		switch v := cell.Value.(type) {
+		case nil:
+			if cell.Style != xlsxStyleNormal {
+				xw.printf(`<c r="%s" s="%d"/>`, ref, cell.Style)
+			}
+		case int:
+			xw.printf(`<c r="%s" s="%d"><v>%d</v></c>`, ref, cell.Style, v)
+		case float64:
+			xw.printf(`<c r="%s" s="%d"><v>%s</v></c>`,
+				ref, cell.Style, formatFloat(v))
+		case string:
+			xw.printf(`<c r="%s" s="%d" t="inlineStr"><is>`+
+				`<t xml:space="preserve">%s</t></is></c>`,
+				ref, cell.Style, xlsxEscape(v))
+		default:
+			xw.err = fmt.Errorf("Unsupported XLSX value of type %T.", v)
+		}
+	}
+	xw.printf(`</row>`)
+}
+
+// stringRow is a convenience for writing a row of strings in one style.
+func (xw *xlsxWriter) stringRow(style int, values ...string) {
+	cells := make([]xlsxCell, len(values))
+	for i, v := range values {
+		cells[i] = xlsxCell{v, style}
+	}
+	xw.writeRow(cells...)
+}
+
+// close finishes the last sheet and writes the rest of the workbook.
+func (xw *xlsxWriter) close() error {
+	xw.endSheet()
+	xw.file("[Content_Types].xml", xw.contentTypes())
+	xw.file("_rels/.rels", fmt.Sprintf(
+		`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`+"\n"+
+			`<Relationships xmlns="%s">`+
+			`<Relationship Id="rId1" Type="%s/officeDocument" `+
+			`Target="xl/workbook.xml"/></Relationships>`,
+		xlsxPackageRels, xlsxOfficeRels))
+	xw.file("xl/workbook.xml", xw.workbook())
+	xw.file("xl/_rels/workbook.xml.rels", xw.workbookRels())
+	xw.file("xl/styles.xml", xw.styles())
+	if xw.err != nil {
+		return xw.err
+	}
+	return xw.zip.Close()
+}
+
+func (xw *xlsxWriter) endSheet() {
+	if xw.cur == nil || xw.err != nil {
+		return
+	}
+	xw.printf(`</sheetData></worksheet>`)
+	if xw.err == nil {
+		xw.err = xw.cur.Flush()
+	}
+	xw.cur = nil
+}
+
+func (xw *xlsxWriter) printf(format string, v ...interface{}) {
+	if xw.err == nil {
+		_, xw.err = fmt.Fprintf(xw.cur, format, v...)
+	}
+}
+
+func (xw *xlsxWriter) file(name, contents string) {
+	if xw.err != nil {
+		return
+	}
+	f, err := xw.zip.Create(name)
+	if err != nil {
+		xw.err = err
+		return
+	}
+	_, xw.err = io.WriteString(f, contents)
+}
+
+func (xw *xlsxWriter) contentTypes() string {
+	override := func(part, typ string) string {
+		return fmt.Sprintf(`<Override PartName="%s" ContentType="%s.%s"/>`,
+			part, xlsxSpreadsheetML, typ)
+	}
+	buf := []string{
+		`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`,
+		`<Types xmlns="` + xlsxContentTypes + `">`,
+		`<Default Extension="rels" ContentType="application/` +
+			`vnd.openxmlformats-package.relationships+xml"/>`,
+		`<Default Extension="xml" ContentType="application/xml"/>`,
+		override("/xl/workbook.xml", "sheet.main+xml"),
+		override("/xl/styles.xml", "styles+xml"),
+	}
+	for i := range xw.sheets {
+		buf = append(buf, override(
+			fmt.Sprintf("/xl/worksheets/sheet%d.xml", i+1),
+			"worksheet+xml"))
+	}
+	buf = append(buf, `</Types>`)
+	return strings.Join(buf, "")
+}
+
+func (xw *xlsxWriter) workbook() string {
+	buf := []string{
+		`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`,
+		fmt.Sprintf(`<workbook xmlns="%s" xmlns:r="%s"><sheets>`,
+			xlsxNamespace, xlsxOfficeRels),
+	}
+	for i, name := range xw.sheets {
+		buf = append(buf, fmt.Sprintf(
+			`<sheet name="%s" sheetId="%d" r:id="rId%d"/>`,
+			xlsxEscape(name), i+1, i+1))
+	}
+	buf = append(buf, `</sheets></workbook>`)
+	return strings.Join(buf, "")
+}
+
+func (xw *xlsxWriter) workbookRels() string {
+	rel := func(id int, typ, target string) string {
+		return fmt.Sprintf(
+			`<Relationship Id="rId%d" Type="%s/%s" Target="%s"/>`,
+			id, xlsxOfficeRels, typ, target)
+	}
+	buf := []string{
+		`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>`,
+		`<Relationships xmlns="` + xlsxPackageRels + `">`,
+	}
+	for i := range xw.sheets {
+		buf = append(buf, rel(i+1, "worksheet",
+			fmt.Sprintf("worksheets/sheet%d.xml", i+1)))
+	}
+	buf = append(buf, rel(len(xw.sheets)+1, "styles", "styles.xml"))
+	buf = append(buf, `</Relationships>`)
+	return strings.Join(buf, "")
+}
+
+func (xw *xlsxWriter) styles() string {
+	fills := []string{
+		`<fill><patternFill patternType="none"/></fill>`,
+		`<fill><patternFill patternType="gray125"/></fill>`,
+	}
+	xfs := []string{
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>`,
+		`<xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" ` +
+			`applyFont="1"/>`,
+	}
+	for i, color := range xw.fills {
+		fills = append(fills, fmt.Sprintf(
+			`<fill><patternFill patternType="solid"><fgColor rgb="FF%s"/>`+
+				`<bgColor indexed="64"/></patternFill></fill>`,
+			strings.ToUpper(strings.TrimPrefix(color, "#"))))
+		xfs = append(xfs, fmt.Sprintf(
+			`<xf numFmtId="0" fontId="0" fillId="%d" borderId="0" `+
+				`xfId="0" applyFill="1"/>`,
+			i+2))
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="%s">`+
+		`<fonts count="2">`+
+		`<font><sz val="11"/><name val="Calibri"/></font>`+
+		`<font><b/><sz val="11"/><name val="Calibri"/></font>`+
+		`</fonts>`+
+		`<fills count="%d">%s</fills>`+
+		`<borders count="1"><border>`+
+		`<left/><right/><top/><bottom/><diagonal/>`+
+		`</border></borders>`+
+		`<cellStyleXfs count="1">`+
+		`<xf numFmtId="0" fontId="0" fillId="0" borderId="0"/>`+
+		`</cellStyleXfs>`+
+		`<cellXfs count="%d">%s</cellXfs>`+
+		`<cellStyles count="1">`+
+		`<cellStyle name="Normal" xfId="0" builtinId="0"/>`+
+		`</cellStyles>`+
+		`</styleSheet>`,
+		xlsxNamespace,
+		len(fills), strings.Join(fills, ""),
+		len(xfs), strings.Join(xfs, ""))
+}
+
+// sheetName makes a sheet name valid and unique. Names can't contain any of
+// `[]:*?/\`, must be at most 31 characters and are compared without regard
+// to case.
+func (xw *xlsxWriter) sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) == 0 {
+		name = "Sheet"
+	}
+	taken := func(name string) bool {
+		for _, other := range xw.sheets {
+			if strings.EqualFold(name, other) {
+				return true
+			}
+		}
+		return false
+	}
+	base := truncateRunes(name, 31)
+	name = base
+	for i := 2; taken(name); i++ {
+		suffix := fmt.Sprintf(" (%d)", i)
+		name = truncateRunes(base, 31-len(suffix)) + suffix
+	}
+	return name
+}
+
+// xlsxColumn returns the name of the i'th column (starting at zero): A, B,
+// ..., Z, AA, AB, ...
+func xlsxColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// xlsxEscape escapes text for XML and removes characters that XML can't
+// represent at all.
+func xlsxEscape(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, s)
+	buf := new(strings.Builder)
+	xml.EscapeText(buf, []byte(s))
+	return buf.String()
+}
+
+func truncateRunes(s string, n int) string {
+	rs := []rune(s)
+	if len(rs) <= n {
+		return s
+	}
+	return string(rs[0:n])
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestXLSXWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	xw := newXLSXWriter(buf, []string{"#ffcc00", "#336699"})
+	xw.sheet("Summary")
+	xw.stringRow(xlsxStyleBold, "document", "tokens", "proportion")
+	xw.writeRow(xlsxCell{"a < b & \"c\"\x01", xlsxStyleNormal},
+		xlsxCell{12, xlsxStyleNormal}, xlsxCell{0.25, xlsxStyleFill})
+	xw.writeRow(xlsxCell{nil, xlsxStyleNormal}, xlsxCell{nil, xlsxStyleFill},
+		xlsxCell{"Ünïcode", xlsxStyleFill + 1})
+	xw.sheet("summary")
+	xw.sheet("Scores: [2014/03/01] and a name that is far too long")
+	xw.stringRow(xlsxStyleNormal, "x")
+	if err := xw.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The archive is compressed, so its entries are compared instead of
+	// the bytes of the archive.
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(bytes.Buffer)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := xmlWellFormed(contents); err != nil {
+			t.Errorf("%s is not well formed: %s", f.Name, err)
+		}
+		got.WriteString("== " + f.Name + "\n")
+		got.Write(contents)
+		got.WriteString("\n")
+	}
+	checkGolden(t, "workbook.xlsx.txt", got.Bytes())
+}
+
+func xmlWellFormed(doc []byte) error {
+	dec := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func TestXLSXColumn(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 701: "ZZ", 702: "AAA"}
+	for i, want := range tests {
+		if got := xlsxColumn(i); got != want {
+			t.Errorf("xlsxColumn(%d) = %s, want %s", i, got, want)
+		}
+	}
+}